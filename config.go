@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// configName is the name of the configuration file, looked for in the user's
+// home directory and in the working directory.
+const configName = ".goconsole"
+
+// A config file looks like the start of a Go source file without the package clause:
+// import declarations, then Go statements to run before the first prompt. E.g.
+//
+//	import (
+//		"net/http"
+//		tt "text/template"
+//	)
+//
+//	client := &http.Client{}
+type config struct {
+	Imports []Import
+	Startup string
+}
+
+// loadConfigs loads the per-user configuration file followed by the per-project one.
+// The imports of both are used, and both startup snippets are run in that order.
+func loadConfigs() (*config, error) {
+	var fns []string
+	if home := os.Getenv("HOME"); home != "" {
+		fns = append(fns, filepath.Join(home, configName))
+	}
+	fns = append(fns, configName)
+
+	cfg := &config{}
+	seen := map[string]bool{}
+	for _, fn := range fns {
+		abs, err := filepath.Abs(fn)
+		if err != nil {
+			return nil, err
+		}
+		if seen[abs] {
+			// Working directory is the home directory
+			continue
+		}
+		seen[abs] = true
+
+		c, err := loadConfig(fn)
+		if err != nil {
+			return nil, err
+		}
+		if c == nil {
+			continue
+		}
+		cfg.Imports = append(cfg.Imports, c.Imports...)
+		if c.Startup != "" {
+			cfg.Startup += c.Startup + "\n"
+		}
+	}
+	return cfg, nil
+}
+
+// loadBuildConfig loads the configuration file fn for an interpreter to build, or
+// the per-project one if fn is empty. The per-user configuration file isn't used,
+// since the interpreter may be used by others. Only a missing file named by fn is
+// an error.
+func loadBuildConfig(fn string) (*config, error) {
+	if fn == "" {
+		cfg, err := loadConfig(configName)
+		if cfg == nil && err == nil {
+			cfg = &config{}
+		}
+		return cfg, err
+	}
+	cfg, err := loadConfig(fn)
+	if cfg == nil && err == nil {
+		return nil, fmt.Errorf("configuration file %s does not exist", fn)
+	}
+	return cfg, err
+}
+
+// loadConfig reads the configuration file fn. It returns a nil config if the file does not exist.
+func loadConfig(fn string) (*config, error) {
+	src, err := ioutil.ReadFile(fn)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse just the import declarations, with a package clause added in front
+	const prefix = "package p;"
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, fn, prefix+string(src), parser.ImportsOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &config{}
+	for _, spec := range file.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			return nil, err
+		}
+		imp := Import{Path: path}
+		if spec.Name != nil {
+			if spec.Name.Name == "_" || spec.Name.Name == "." {
+				pos := fset.Position(spec.Name.Pos())
+				return nil, fmt.Errorf("%s:%d: cannot import %q as %s", fn, pos.Line, path, spec.Name.Name)
+			}
+			imp.LocalName = spec.Name.Name
+		}
+		cfg.Imports = append(cfg.Imports, imp)
+	}
+
+	// Everything after the last import declaration is the startup snippet
+	start := len(prefix)
+	if n := len(file.Decls); n > 0 {
+		start = fset.Position(file.Decls[n-1].(*ast.GenDecl).End()).Offset
+	}
+	cfg.Startup = strings.TrimSpace(string(src[start-len(prefix):]))
+	return cfg, nil
+}
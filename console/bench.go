@@ -0,0 +1,135 @@
+package console
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/parser"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/davidthomas426/goconsole/interp"
+)
+
+// benchTime is how long :bench runs an expression for, as with go test -benchtime.
+const benchTime = time.Second
+
+func timeCommand(c *Console, args string) error {
+	if args == "" {
+		return errors.New("usage: :time statements")
+	}
+	// Compile rather than run the input, so only running it is timed, and an
+	// incomplete input isn't kept for the next one
+	run, err := c.interp.Compile(args)
+	if err != nil {
+		return err
+	}
+
+	var results []interp.Object
+	var elapsed time.Duration
+	var before, after runtime.MemStats
+	interruptible(func(ctx context.Context) {
+		runtime.ReadMemStats(&before)
+		start := time.Now()
+		results, err = run(ctx)
+		elapsed = time.Since(start)
+		runtime.ReadMemStats(&after)
+	})
+	if e, ok := err.(*interp.PanicError); ok {
+		fmt.Print(e.Trace())
+	} else if err != nil {
+		return err
+	}
+	c.printer(os.Stdout, results)
+	fmt.Printf("%v, %d B, %d allocs\n", elapsed, after.TotalAlloc-before.TotalAlloc, after.Mallocs-before.Mallocs)
+	return nil
+}
+
+func benchCommand(c *Console, args string) error {
+	if _, err := parser.ParseExpr(args); err != nil {
+		return errors.New("usage: :bench expression")
+	}
+	run, err := c.interp.Compile(args)
+	if err != nil {
+		return err
+	}
+
+	var r benchResult
+	interruptible(func(ctx context.Context) {
+		r, err = bench(ctx, run)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println(r)
+	return nil
+}
+
+// A benchResult is like a testing.BenchmarkResult.
+type benchResult struct {
+	n      int
+	t      time.Duration
+	bytes  uint64
+	allocs uint64
+}
+
+func (r benchResult) String() string {
+	n := uint64(r.n)
+	return fmt.Sprintf("%10d\t%10d ns/op\t%10d B/op\t%10d allocs/op", r.n, r.t.Nanoseconds()/int64(r.n), r.bytes/n, r.allocs/n)
+}
+
+// bench runs run repeatedly, increasing the number of times like testing.B does
+// until it takes at least benchTime.
+func bench(ctx context.Context, run func(context.Context) ([]interp.Object, error)) (benchResult, error) {
+	n := 1
+	for {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		start := time.Now()
+		for j := 0; j < n; j++ {
+			if _, err := run(ctx); err != nil {
+				return benchResult{}, err
+			}
+		}
+		t := time.Since(start)
+		runtime.ReadMemStats(&after)
+		if t >= benchTime || n >= 1e9 {
+			return benchResult{
+				n:      n,
+				t:      t,
+				bytes:  after.TotalAlloc - before.TotalAlloc,
+				allocs: after.Mallocs - before.Mallocs,
+			}, nil
+		}
+
+		// Predict the number of runs that takes benchTime, then run 20% more,
+		// growing by at least one and at most 100 times
+		next := 100 * n
+		if ns := t.Nanoseconds(); ns > 0 {
+			next = int(benchTime.Nanoseconds() * int64(n) / ns)
+		}
+		next += next / 5
+		if next > 100*n {
+			next = 100 * n
+		}
+		if next <= n {
+			next = n + 1
+		}
+		n = roundUp(next)
+	}
+}
+
+// roundUp rounds n up to a number like 1, 2, 3, 5 or 10 times a power of ten.
+func roundUp(n int) int {
+	base := 1
+	for base*10 <= n {
+		base *= 10
+	}
+	for _, m := range []int{1, 2, 3, 5, 10} {
+		if m*base >= n {
+			return m * base
+		}
+	}
+	return 10 * base
+}
@@ -0,0 +1,62 @@
+package console
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/davidthomas426/goconsole/interp"
+)
+
+// newTestConsole returns a Console for running commands, which keeps the
+// results it would print in *results.
+func newTestConsole(results *[]interp.Object) *Console {
+	return &Console{
+		interp: interp.New(interp.Options{}),
+		printer: func(w io.Writer, objs []interp.Object) {
+			*results = append(*results, objs...)
+		},
+	}
+}
+
+func TestTimeAndBenchArgs(t *testing.T) {
+	var results []interp.Object
+	c := newTestConsole(&results)
+	for _, test := range []struct {
+		src, err string
+	}{
+		{":time", "usage: :time statements"},
+		{":time   ", "usage: :time statements"},
+		{":time y + ", "unexpected end of input"},
+		{":time y", "input:1: "},
+		{":bench", "usage: :bench expression"},
+		{":bench x := 1", "usage: :bench expression"},
+		{":bench 1 +", "usage: :bench expression"},
+		{":bench y", "input:1: "},
+	} {
+		err := c.runCommand(test.src)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: got error %v, want %q", test.src, err, test.err)
+		}
+	}
+
+	// The statements timed are run, but not kept in the session
+	if err := c.runCommand(":time x := 6; x * 7"); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Value.(reflect.Value).Int() != 42 {
+		t.Errorf("results %v, want 42", results)
+	}
+	if _, err := c.interp.Eval("x"); err == nil {
+		t.Errorf("x declared by :time is kept")
+	}
+}
+
+func TestRoundUp(t *testing.T) {
+	for n, want := range map[int]int{1: 1, 2: 2, 4: 5, 6: 10, 11: 20, 25: 30, 31: 50, 51: 100, 999: 1000, 1001: 2000} {
+		if got := roundUp(n); got != want {
+			t.Errorf("roundUp(%d) = %d, want %d", n, got, want)
+		}
+	}
+}
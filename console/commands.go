@@ -0,0 +1,345 @@
+package console
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/davidthomas426/goconsole/interp"
+)
+
+// A command is run by typing a colon followed by its name at the prompt, e.g. ":help".
+type command struct {
+	name  string
+	usage string
+	help  string
+	run   func(c *Console, args string) error
+}
+
+var commands map[string]*command
+
+func init() {
+	// Set up in init, since the help command refers to the commands map
+	commands = map[string]*command{}
+	for _, cmd := range []*command{
+		{
+			name: "help",
+			help: "list the available commands",
+			run:  helpCommand,
+		},
+		{
+			name:  "bench",
+			usage: "expression",
+			help:  "evaluate an expression repeatedly, reporting ns/op, B/op and allocs/op",
+			run:   benchCommand,
+		},
+		{
+			name:  "break",
+			usage: "[line]",
+			help:  "set a breakpoint at a line of the session, or list breakpoints",
+			run:   breakCommand,
+		},
+		{
+			name:  "clear",
+			usage: "line",
+			help:  "clear the breakpoint at a line of the session",
+			run:   clearCommand,
+		},
+		{
+			name: "continue",
+			help: "run stopped code until the next breakpoint",
+			run:  resumeCommand(interp.Continue),
+		},
+		{
+			name: "finish",
+			help: "run stopped code until the current function returns",
+			run:  resumeCommand(interp.Finish),
+		},
+		{
+			name: "locals",
+			help: "list the variables in scope where code stopped",
+			run:  localsCommand,
+		},
+		{
+			name: "next",
+			help: "run stopped code to the next line, stepping over calls",
+			run:  resumeCommand(interp.Next),
+		},
+		{
+			name: "stack",
+			help: "print the stack where code stopped",
+			run:  stackCommand,
+		},
+		{
+			name: "step",
+			help: "run stopped code to the next line, stepping into calls",
+			run:  resumeCommand(interp.Step),
+		},
+		{
+			name: "goroutines",
+			help: "list the goroutines started by go statements that are still running",
+			run:  goroutinesCommand,
+		},
+		{
+			name:  "profile",
+			usage: "start | stop [file]",
+			help:  "profile code, then report where time went and write a pprof file",
+			run:   profileCommand,
+		},
+		{
+			name:  "time",
+			usage: "statements",
+			help:  "run statements, reporting the time taken and memory allocated",
+			run:   timeCommand,
+		},
+		{
+			name:  "trace",
+			usage: "on | off | file path",
+			help:  "print statements as they run, or write them to a file as JSON",
+			run:   traceCommand,
+		},
+		{
+			name:  "history",
+			usage: "[text | n]",
+			help:  "list history entries containing text, or edit entry n at the prompt",
+			run:   historyCommand,
+		},
+	} {
+		commands[cmd.name] = cmd
+	}
+}
+
+// runCommand runs a line of input starting with ':'.
+func (c *Console) runCommand(src string) error {
+	name := strings.TrimPrefix(src, ":")
+	args := ""
+	if i := strings.IndexAny(name, " \t"); i >= 0 {
+		name, args = name[:i], strings.TrimSpace(name[i:])
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		return fmt.Errorf("unknown command %q (try :help)", ":"+name)
+	}
+	return cmd.run(c, args)
+}
+
+func helpCommand(c *Console, args string) error {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cmd := commands[name]
+		fmt.Printf("  :%-24s %s\n", strings.TrimSpace(cmd.name+" "+cmd.usage), cmd.help)
+	}
+	return nil
+}
+
+func historyCommand(c *Console, args string) error {
+	entries := c.history.entries
+	if n, err := strconv.Atoi(args); err == nil {
+		if n < 1 || n > len(entries) {
+			return fmt.Errorf("no history entry %d", n)
+		}
+		c.suggestion = entries[n-1]
+		return nil
+	}
+	for i, entry := range entries {
+		if strings.Contains(entry, args) {
+			fmt.Printf("%5d  %s\n", i+1, entry)
+		}
+	}
+	return nil
+}
+
+func goroutinesCommand(c *Console, args string) error {
+	gs := c.interp.Goroutines()
+	if len(gs) == 0 {
+		fmt.Println("no goroutines")
+		return nil
+	}
+	for _, g := range gs {
+		state := "running"
+		if g.Blocked != "" {
+			state = g.Blocked
+		}
+		fmt.Printf("goroutine %d [%s]:\n", g.ID, state)
+		for _, f := range g.Stack {
+			fmt.Println(f)
+		}
+		fmt.Printf("created by go statement at %s\n\n", g.Start)
+	}
+	return nil
+}
+
+// parseLine parses a line number of the session.
+func parseLine(args string) (int, error) {
+	line, err := strconv.Atoi(args)
+	if err != nil || line < 1 {
+		return 0, fmt.Errorf("bad line number %q", args)
+	}
+	return line, nil
+}
+
+func breakCommand(c *Console, args string) error {
+	if args == "" {
+		lines := c.interp.Breakpoints()
+		if len(lines) == 0 {
+			fmt.Println("no breakpoints")
+		}
+		for _, line := range lines {
+			fmt.Printf("breakpoint at input:%d\n", line)
+		}
+		return nil
+	}
+	line, err := parseLine(args)
+	if err != nil {
+		return err
+	}
+	c.interp.SetBreakpoint(line)
+	return nil
+}
+
+func clearCommand(c *Console, args string) error {
+	line, err := parseLine(args)
+	if err != nil {
+		return err
+	}
+	c.interp.ClearBreakpoint(line)
+	return nil
+}
+
+var errNotStopped = errors.New("code is not stopped in the debugger")
+
+// resumeCommand returns a command that goes on running stopped code in the given mode.
+func resumeCommand(mode interp.StepMode) func(c *Console, args string) error {
+	return func(c *Console, args string) error {
+		if c.stop == nil {
+			return errNotStopped
+		}
+		c.mode = mode
+		c.resumed = true
+		return nil
+	}
+}
+
+func localsCommand(c *Console, args string) error {
+	if c.stop == nil {
+		return errNotStopped
+	}
+	for _, v := range c.stop.Locals() {
+		if v.Value.CanInterface() {
+			fmt.Printf("%s %s = %v\n", v.Name, interp.TypeString(v.Type), v.Value.Interface())
+		}
+	}
+	return nil
+}
+
+func stackCommand(c *Console, args string) error {
+	if c.stop == nil {
+		return errNotStopped
+	}
+	for _, f := range c.stop.Stack {
+		fmt.Println(f)
+	}
+	return nil
+}
+
+func traceCommand(c *Console, args string) error {
+	switch {
+	case args == "on":
+		c.stopTrace()
+		c.interp.SetTracer(func(e interp.TraceEvent) {
+			fmt.Println(e)
+		})
+	case args == "off":
+		c.stopTrace()
+	case strings.HasPrefix(args, "file "):
+		f, err := os.Create(strings.TrimSpace(strings.TrimPrefix(args, "file ")))
+		if err != nil {
+			return err
+		}
+		c.stopTrace()
+		c.traceFile = f
+		// Events come from every goroutine, one JSON object per line
+		var mu sync.Mutex
+		enc := json.NewEncoder(f)
+		c.interp.SetTracer(func(e interp.TraceEvent) {
+			mu.Lock()
+			enc.Encode(e)
+			mu.Unlock()
+		})
+	default:
+		return errors.New("usage: :trace on | off | file path")
+	}
+	return nil
+}
+
+// stopTrace stops tracing, closing the trace file if there is one.
+func (c *Console) stopTrace() {
+	c.interp.SetTracer(nil)
+	if c.traceFile != nil {
+		if err := c.traceFile.Close(); err != nil {
+			fmt.Println("trace:", err)
+		}
+		c.traceFile = nil
+	}
+}
+
+// profileTop is the number of lines and functions reported by :profile stop.
+const profileTop = 20
+
+func profileCommand(c *Console, args string) error {
+	switch {
+	case args == "start":
+		c.interp.StartProfile()
+		return nil
+	case args == "stop" || strings.HasPrefix(args, "stop "):
+	default:
+		return errors.New("usage: :profile start | stop [file]")
+	}
+
+	prof := c.interp.StopProfile()
+	if prof == nil {
+		return errors.New("not profiling")
+	}
+	fmt.Printf("%v of profiling\n", prof.Duration)
+	for _, report := range []struct {
+		title   string
+		entries []interp.ProfileEntry
+	}{
+		{"lines", prof.Lines()},
+		{"compiled functions", prof.Funcs()},
+	} {
+		if len(report.entries) == 0 {
+			continue
+		}
+		fmt.Printf("\n%12s %10s  %s\n", "time", "count", report.title)
+		for j, e := range report.entries {
+			if j == profileTop {
+				break
+			}
+			fmt.Printf("%12v %10d  %s\n", e.Time, e.Count, e.Name)
+		}
+	}
+
+	fn := strings.TrimSpace(strings.TrimPrefix(args, "stop"))
+	if fn == "" {
+		return nil
+	}
+	f, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+	if err := prof.WritePprof(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
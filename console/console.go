@@ -0,0 +1,299 @@
+// Package console implements the prompt of a goconsole interpreter, both
+// interactively and for scripts.
+package console
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/davidthomas426/goconsole/interp"
+
+	"github.com/peterh/liner"
+	"golang.org/x/tools/go/exact"
+)
+
+// Config controls the interactive console.
+type Config struct {
+	// HistoryFile is the file that history is loaded from and saved to.
+	// If it is empty, history is not saved.
+	HistoryFile string
+	// HistorySize is the maximum number of entries kept in the history file.
+	HistorySize int
+	// Printer prints the values of top-level expressions. If it is nil, PrintResults is used.
+	Printer Printer
+}
+
+// A Printer prints the values of the top-level expression statements of an input.
+type Printer func(w io.Writer, results []interp.Object)
+
+// PrintResults prints each value on its own line, with its type.
+func PrintResults(w io.Writer, results []interp.Object) {
+	for _, obj := range results {
+		switch v := obj.Value.(type) {
+		case reflect.Value:
+			fmt.Fprintf(w, "=> %s: %v\n", interp.TypeString(obj.Typ), v.Interface())
+		case exact.Value:
+			fmt.Fprintf(w, "=> %s: %s\n", interp.TypeString(obj.Typ), interp.ConstString(v))
+		case nil:
+			fmt.Fprintf(w, "=> %s: %v\n", interp.TypeString(obj.Typ), v)
+		}
+	}
+}
+
+func (cfg Config) printer() Printer {
+	if cfg.Printer == nil {
+		return PrintResults
+	}
+	return cfg.Printer
+}
+
+// DefaultConfig returns the configuration used by goconsole. The history file
+// is ~/.goconsole_history unless set by $GOCONSOLE_HISTFILE, and its size is
+// 1000 entries unless set by $GOCONSOLE_HISTSIZE.
+func DefaultConfig() Config {
+	cfg := Config{
+		HistorySize: 1000,
+	}
+	if fn := os.Getenv("GOCONSOLE_HISTFILE"); fn != "" {
+		cfg.HistoryFile = fn
+	} else if home := os.Getenv("HOME"); home != "" {
+		cfg.HistoryFile = filepath.Join(home, ".goconsole_history")
+	}
+	if s := os.Getenv("GOCONSOLE_HISTSIZE"); s != "" {
+		var n int
+		if _, err := fmt.Sscan(s, &n); err == nil && n >= 0 {
+			cfg.HistorySize = n
+		}
+	}
+	return cfg
+}
+
+// Console is an interactive prompt for an interpreter.
+type Console struct {
+	interp  interp.Interpreter
+	line    *liner.State
+	history *history
+	printer Printer
+
+	// suggestion is placed at the next prompt for the user to edit, if not empty
+	suggestion string
+
+	// Where code is stopped in the debugger, if it is, and how to go on once
+	// a command has said so
+	stop    *interp.Stop
+	resumed bool
+	mode    interp.StepMode
+
+	// The file a trace is being written to, if any
+	traceFile *os.File
+}
+
+// Run prompts for input and runs it in the interpreter until the end of input or
+// an error. It returns liner.ErrPromptAborted if the user aborted with Ctrl-C.
+func Run(in interp.Interpreter, cfg Config) error {
+	c := &Console{
+		interp:  in,
+		history: newHistory(cfg.HistoryFile, cfg.HistorySize),
+		printer: cfg.printer(),
+	}
+
+	c.line = liner.NewLiner()
+	defer c.line.Close()
+
+	c.line.SetCtrlCAborts(true)
+	in.OnStop(c.debug)
+	defer in.OnStop(nil)
+	defer c.stopTrace()
+
+	if err := c.history.load(); err != nil {
+		fmt.Println("history:", err)
+	}
+	for _, entry := range c.history.entries {
+		c.line.AppendHistory(entry)
+	}
+
+	return c.loop()
+}
+
+func (c *Console) loop() error {
+	// Lines of the current input, which may span several prompts
+	var lines []string
+	for {
+		prompt := ">>> "
+		if len(lines) > 0 {
+			prompt = "... "
+		}
+		src, err := c.prompt(prompt)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(lines) == 0 && strings.HasPrefix(strings.TrimSpace(src), ":") {
+			c.addHistory(src)
+			if err := c.runCommand(strings.TrimSpace(src)); err != nil {
+				fmt.Println(err)
+			}
+			continue
+		}
+
+		results, err := c.eval(src)
+		if src != "" {
+			lines = append(lines, src)
+		}
+		if err == interp.ErrIncomplete {
+			continue
+		}
+		if len(lines) > 0 {
+			c.addHistory(strings.Join(lines, "\n"))
+			lines = lines[:0]
+		}
+		if err == context.Canceled {
+			// Interrupted by the user, who gets another prompt
+			fmt.Println("interrupted")
+			continue
+		}
+		switch err {
+		case interp.ErrStepLimit, interp.ErrTimeLimit, interp.ErrGoroutineLimit, interp.ErrAllocLimit:
+			// Only the input that went over its limits is stopped
+			fmt.Println(err)
+			continue
+		}
+		if e, ok := err.(*interp.PanicError); ok {
+			fmt.Print(e.Trace())
+			continue
+		}
+		if err != nil {
+			fmt.Println(err)
+			return nil
+		}
+		c.printer(os.Stdout, results)
+	}
+}
+
+// eval evaluates src, stopping if the user interrupts it with Ctrl-C.
+func (c *Console) eval(src string) (results []interp.Object, err error) {
+	interruptible(func(ctx context.Context) {
+		results, err = c.interp.EvalContext(ctx, src)
+	})
+	return results, err
+}
+
+// interruptible calls f with a context that is canceled if the user presses Ctrl-C.
+func interruptible(f func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-sig:
+			cancel()
+		case <-done:
+		}
+	}()
+	defer func() {
+		signal.Stop(sig)
+		close(done)
+	}()
+	// Goroutines started by code keep ctx, so we don't cancel it when we're done
+	f(ctx)
+}
+
+// debug prompts for debugger commands, and expressions to evaluate, where code
+// stopped, until a command says how to go on.
+func (c *Console) debug(s *interp.Stop) interp.StepMode {
+	fmt.Printf("stopped at %s\n%5d\t%s\n", s.Pos, s.Pos.Line, s.Source)
+	c.stop = s
+	c.resumed = false
+	defer func() { c.stop = nil }()
+	for !c.resumed {
+		src, err := c.prompt("(debug) ")
+		if err != nil {
+			// End of input or Ctrl-C
+			return interp.Continue
+		}
+		src = strings.TrimSpace(src)
+		if src == "" {
+			continue
+		}
+		c.addHistory(src)
+		if strings.HasPrefix(src, ":") {
+			if err := c.runCommand(src); err != nil {
+				fmt.Println(err)
+			}
+			continue
+		}
+		v, err := s.Eval(src)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		fmt.Printf("=> %v\n", v)
+	}
+	return c.mode
+}
+
+// prompt reads a line of input, with the pending suggestion, if any, ready to edit.
+func (c *Console) prompt(p string) (string, error) {
+	if c.suggestion == "" {
+		return c.line.Prompt(p)
+	}
+	s := c.suggestion
+	c.suggestion = ""
+	return c.line.PromptWithSuggestion(p, s, -1)
+}
+
+// addHistory adds an input to the history as a single entry, even if it
+// spans several lines.
+func (c *Console) addHistory(src string) {
+	entry := joinLines(src)
+	if entry == "" {
+		return
+	}
+	c.line.AppendHistory(entry)
+	if err := c.history.add(entry); err != nil {
+		fmt.Println("history:", err)
+	}
+}
+
+// RunScript runs the statements read from r one line at a time, as if they were
+// typed at the prompt. It stops at the first error. The name of the script is
+// used in error messages. Only the Printer of cfg is used.
+func RunScript(in interp.Interpreter, r io.Reader, name string, cfg Config) error {
+	printer := cfg.printer()
+	scanner := bufio.NewScanner(r)
+	incomplete := false
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		results, err := in.Eval(scanner.Text())
+		incomplete = err == interp.ErrIncomplete
+		if incomplete {
+			continue
+		}
+		if e, ok := err.(*interp.PanicError); ok {
+			return fmt.Errorf("%s:%d: %s", name, lineNum, strings.TrimSpace(e.Trace()))
+		}
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", name, lineNum, err)
+		}
+		printer(os.Stdout, results)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if incomplete {
+		return fmt.Errorf("%s:%d: unexpected end of input", name, lineNum)
+	}
+	return nil
+}
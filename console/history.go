@@ -0,0 +1,195 @@
+package console
+
+import (
+	"bufio"
+	"bytes"
+	"go/scanner"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A lock file older than staleLock was left by a session that died while
+// holding it, and is removed.
+const staleLock = 10 * time.Second
+
+// history is the list of previous inputs, kept in a file between sessions.
+// Each entry is a single line, and no two entries are the same.
+type history struct {
+	file    string
+	size    int
+	entries []string
+}
+
+func newHistory(file string, size int) *history {
+	return &history{
+		file: file,
+		size: size,
+	}
+}
+
+// load reads the history file. A missing history file is not an error.
+func (h *history) load() error {
+	if h.file == "" {
+		return nil
+	}
+	f, err := os.Open(h.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		h.append(scanner.Text())
+	}
+	return scanner.Err()
+}
+
+// add adds an entry to the history and saves it.
+func (h *history) add(entry string) error {
+	h.append(entry)
+	return h.save(entry)
+}
+
+// append adds an entry to the end of the history, removing any earlier copy
+// of it and dropping the oldest entries if there are too many.
+func (h *history) append(entry string) {
+	h.entries = appendEntry(h.entries, entry, h.size)
+}
+
+// appendEntry adds entry to the end of entries, removing any earlier copy of it
+// and keeping at most size entries.
+func appendEntry(entries []string, entry string, size int) []string {
+	for i, e := range entries {
+		if e == entry {
+			entries = append(entries[:i], entries[i+1:]...)
+			break
+		}
+	}
+	entries = append(entries, entry)
+	if len(entries) > size {
+		entries = entries[len(entries)-size:]
+	}
+	return entries
+}
+
+// save adds entry to the history file. The file is read again first, so entries
+// other sessions saved since we loaded it are kept, and it's replaced all at once
+// so that a concurrent session never sees it partially written. Sessions take
+// turns to do this, so none loses the entry another is saving.
+func (h *history) save(entry string) error {
+	if h.file == "" {
+		return nil
+	}
+	unlock, err := h.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	saved := newHistory(h.file, h.size)
+	if err := saved.load(); err != nil {
+		return err
+	}
+	saved.append(entry)
+
+	var buf bytes.Buffer
+	for _, entry := range saved.entries {
+		buf.WriteString(entry)
+		buf.WriteByte('\n')
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(h.file), filepath.Base(h.file)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(buf.Bytes())
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), h.file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// lock creates the lock file of the history file, waiting while another session
+// has it, and returns a function that removes it.
+func (h *history) lock() (unlock func(), err error) {
+	name := h.file + ".lock"
+	for {
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(name) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, err := os.Stat(name); err == nil && time.Since(info.ModTime()) > staleLock {
+			os.Remove(name)
+			continue
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// joinLines turns a multi-line input into a single line meaning the same thing, so
+// it can be kept as one history entry and edited at the prompt. A newline where Go
+// would insert a semicolon becomes a semicolon, other newlines become spaces, and
+// line comments are dropped.
+func joinLines(src string) string {
+	src = strings.TrimSpace(src)
+	if !strings.Contains(src, "\n") {
+		return src
+	}
+
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	var s scanner.Scanner
+	s.Init(file, []byte(src), nil, scanner.ScanComments)
+
+	var buf bytes.Buffer
+	last := 0 // offset in src up to which we've written
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		off := file.Offset(pos)
+		// Between tokens, there's only whitespace
+		buf.WriteString(strings.Replace(src[last:off], "\n", " ", -1))
+		last = off
+		switch {
+		case tok == token.SEMICOLON && lit == "\n":
+			// Automatically inserted, so it takes up no space in src
+			buf.WriteByte(';')
+		case tok == token.COMMENT && strings.HasPrefix(lit, "//"):
+			last += len(lit)
+		case tok == token.STRING && strings.Contains(lit, "\n"):
+			// A raw string spanning lines. Write it as an interpreted string instead.
+			raw := strings.Replace(lit[1:len(lit)-1], "\r", "", -1)
+			buf.WriteString(strconv.Quote(raw))
+			last += len(lit)
+		default:
+			text := lit
+			if text == "" {
+				text = tok.String()
+			}
+			// Only a general comment could still span lines here
+			buf.WriteString(strings.Replace(text, "\n", " ", -1))
+			last += len(text)
+		}
+	}
+	return strings.TrimSuffix(strings.TrimSpace(buf.String()), ";")
+}
@@ -0,0 +1,103 @@
+package console
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// Sessions running at the same time keep each other's entries.
+func TestHistorySessions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "history")
+	if err := ioutil.WriteFile(file, []byte("a\nb\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	h1 := newHistory(file, 4)
+	h2 := newHistory(file, 4)
+	for _, h := range []*history{h1, h2} {
+		if err := h.load(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, add := range []struct {
+		h     *history
+		entry string
+	}{{h1, "c"}, {h2, "d"}, {h1, "a"}, {h2, "e"}} {
+		if err := add.h.add(add.entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := newHistory(file, 4)
+	if err := h.load(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"c", "d", "a", "e"}; !reflect.DeepEqual(h.entries, want) {
+		t.Errorf("saved entries %q, want %q", h.entries, want)
+	}
+	// A session only lists its own entries
+	if want := []string{"b", "c", "a"}; !reflect.DeepEqual(h1.entries, want) {
+		t.Errorf("session entries %q, want %q", h1.entries, want)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "history.*")); len(files) > 0 {
+		t.Errorf("temporary files left: %q", files)
+	}
+}
+
+// Sessions saving at the same time take turns, so no entry is lost.
+func TestHistoryConcurrentSaves(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "history")
+
+	const sessions, adds = 4, 10
+	errs := make(chan error)
+	for s := 0; s < sessions; s++ {
+		go func(s int) {
+			h := newHistory(file, sessions*adds)
+			for a := 0; a < adds; a++ {
+				if err := h.add(fmt.Sprintf("%d.%d", s, a)); err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}(s)
+	}
+	for s := 0; s < sessions; s++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := newHistory(file, sessions*adds)
+	if err := h.load(); err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for s := 0; s < sessions; s++ {
+		for a := 0; a < adds; a++ {
+			want = append(want, fmt.Sprintf("%d.%d", s, a))
+		}
+	}
+	sort.Strings(h.entries)
+	if !reflect.DeepEqual(h.entries, want) {
+		t.Errorf("saved entries %q, want %q", h.entries, want)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "history.*")); len(files) > 0 {
+		t.Errorf("temporary files left: %q", files)
+	}
+}
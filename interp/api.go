@@ -1,18 +1,153 @@
 package interp
 
 import (
+	"context"
+	"errors"
+	"go/token"
+	"io"
+	"reflect"
+	"time"
+
 	"golang.org/x/tools/go/types"
 	"golang.org/x/tools/go/types/typeutil"
 )
 
+// ErrIncomplete is returned by Eval when its input is incomplete. The input is kept
+// and prepended to the input of the next call, as with Run.
+var ErrIncomplete = errors.New("unexpected end of input")
+
 type Interpreter interface {
+	// Run runs the statements in src. It reports whether src is incomplete,
+	// in which case it is kept and prepended to the src of the next call.
 	Run(src string) (bool, error)
+
+	// Eval is like Run, but returns the values of the top-level expression
+	// statements in src. If src is incomplete, it returns ErrIncomplete.
+	Eval(src string) ([]Object, error)
+
+	// RunContext and EvalContext are like Run and Eval, but stop running src
+	// and return the context's error if ctx is done first. Goroutines started
+	// by src are stopped too, since they inherit ctx.
+	RunContext(ctx context.Context, src string) (bool, error)
+	EvalContext(ctx context.Context, src string) ([]Object, error)
+
+	// Compile type checks src as if it were the next input, and returns a
+	// function that runs it like EvalContext each time it's called, without
+	// adding it to the session. Variables it declares are only visible to
+	// itself. The function must not be called once another input has run.
+	Compile(src string) (func(ctx context.Context) ([]Object, error), error)
+
+	// Define binds value to name in the top-level environment, so that code
+	// run afterwards can use it like a variable declared at the prompt. It
+	// returns an error if name is already bound, or declared by code run so far.
+	Define(name string, value interface{}) error
+
+	// Lookup returns the variable bound to name in the top-level environment,
+	// whether it was declared by code or bound with Define.
+	Lookup(name string) (reflect.Value, bool)
+
+	// Names returns the names of the variables declared by code at top level,
+	// in the order they were first declared.
+	Names() []string
+
+	// Goroutines describes the goroutines started by go statements in code
+	// run so far that have not finished, in the order they were started.
+	Goroutines() []GoroutineInfo
+
+	// SetBreakpoint and ClearBreakpoint set and clear a breakpoint at a line
+	// of the session, numbering the lines of all inputs in order, including
+	// inputs not run yet. Breakpoints returns the lines with breakpoints.
+	SetBreakpoint(line int)
+	ClearBreakpoint(line int)
+	Breakpoints() []int
+
+	// OnStop sets the function called when the code of an input stops at a
+	// breakpoint or after stepping. The code waits until it returns how to
+	// go on. Code doesn't stop while there is no such function.
+	OnStop(func(*Stop) StepMode)
+
+	// SetTracer sets the function called as code runs statements, assigns
+	// values and returns from calls, in every goroutine. Nil stops tracing.
+	SetTracer(func(TraceEvent))
+
+	// StartProfile starts profiling code in every goroutine, counting the
+	// statements run and compiled functions called, and the time spent in
+	// each. StopProfile stops and returns the profile, or nil if not profiling.
+	StartProfile()
+	StopProfile() *Profile
+}
+
+// GoroutineInfo describes a goroutine started by a go statement. Positions
+// are in the file "input", whose lines are the lines of all inputs so far.
+type GoroutineInfo struct {
+	ID      int64          // a number given to the goroutine by the interpreter
+	Start   token.Position // the call in the go statement that started it
+	Pos     token.Position // the statement it is running
+	Blocked string         // the channel operation it's blocked on, if any, like "chan receive"
+	Stack   []Frame        // innermost call first
+}
+
+// Errors returned by Run and Eval when the code run goes over the limits set in
+// Options. The code run by an input is stopped, including any goroutines it started.
+var (
+	ErrStepLimit      = errors.New("step limit exceeded")
+	ErrTimeLimit      = errors.New("time limit exceeded")
+	ErrGoroutineLimit = errors.New("goroutine limit exceeded")
+	ErrAllocLimit     = errors.New("allocation limit exceeded")
+)
+
+// Limits bounds the resources the code of each input to Run or Eval may use,
+// including the goroutines it starts. A zero field means no limit.
+type Limits struct {
+	// MaxSteps is the number of statements and expressions that may be executed.
+	MaxSteps int64
+	// Timeout is how long the code may run.
+	Timeout time.Duration
+	// MaxGoroutines is the number of goroutines that may be started by go statements.
+	MaxGoroutines int
+	// MaxAlloc is the largest size in bytes of a slice or channel buffer that
+	// may be allocated by make or append.
+	MaxAlloc int64
+}
+
+// Options configures an Interpreter.
+type Options struct {
+	// Packages are the packages available to import. PkgMap and TypeMap are
+	// used by the type checker and to find the reflect.Type of a types.Type.
+	// All three may be nil for an interpreter with no packages.
+	Packages []*Package
+	PkgMap   map[string]*types.Package
+	TypeMap  *typeutil.Map
+
+	// Stdout receives the output of the print and println builtins.
+	// It defaults to os.Stdout.
+	Stdout io.Writer
+	// Stderr receives diagnostics from the interpreter. It defaults to os.Stderr.
+	Stderr io.Writer
+
+	// Limits bounds the resources used by the code of each input.
+	Limits Limits
+
+	// Policy decides which objects of Packages code may refer to. Code that
+	// refers to any other fails to type check.
+	Policy Policy
 }
 
 func NewInterpreter(pkgs []*Package, pkgMap map[string]*types.Package, typeMap *typeutil.Map) Interpreter {
-	return newInterp(pkgs, pkgMap, typeMap)
+	return New(Options{
+		Packages: pkgs,
+		PkgMap:   pkgMap,
+		TypeMap:  typeMap,
+	})
 }
 
+// New returns an Interpreter configured by opts.
+func New(opts Options) Interpreter {
+	return newInterp(opts)
+}
+
+// Package holds the objects of an imported package. Name is the name
+// the package is imported as, which may differ from Pkg.Name().
 type Package struct {
 	Name string
 	Objs map[string]Object
@@ -24,8 +159,14 @@ func (pkg *Package) Lookup(s string) (Object, bool) {
 	return v, ok
 }
 
+// Object is a value in the interpreter. Value is a reflect.Value, except for
+// untyped constants, where it is an exact.Value, and untyped nil, where it is nil.
+// Typ is the type of the value, and Sim reports whether the reflect.Value is
+// simulating a value of that type rather than actually having it.
 type Object struct {
 	Value interface{}
 	Typ   types.Type
 	Sim   bool
+
+	fn *closureRef // for values of unsimulated function types, or nil
 }
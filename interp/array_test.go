@@ -0,0 +1,90 @@
+package interp
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Arrays are values: assigning one copies it, and they can be compared and
+// used as map keys.
+func TestArrays(t *testing.T) {
+	var stdout bytes.Buffer
+	i := New(Options{Stdout: &stdout})
+	if err := i.Define("a", [3]int{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Define("m", map[[2]string]int{{"x", "y"}: 7}); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Define("k", [2]string{"x", "y"}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"b := a", ""},
+		{"b[0] = 10", ""},
+		{"println(a[0], b[0], a == b)", "1 10 false\n"},
+		{"b[0] = 1", ""},
+		{"println(a == b, a != b)", "true false\n"},
+		{"a, b = b, a", ""},
+		{"b[1] = 20", ""},
+		{"a, b = b, a", ""},
+		{"println(a[1], b[1])", "20 2\n"},
+		{"x, y := 1, 2", ""},
+		{"x, y = y, x", ""},
+		{"println(x, y)", "2 1\n"},
+		{"p := &a", ""},
+		{"p[2] = 30", ""},
+		{"println(a[2])", "30\n"},
+		{"println(m[k])", "7\n"},
+		{"j := k", ""},
+		{"k[1] = \"z\"", ""},
+		{"m[k] = 8", ""},
+		{"println(m[j], m[k], j == k)", "7 8 false\n"},
+		{"f := func(x [3]int) [3]int { x[0] = 99; return x }", ""},
+		{"c := f(a)", ""},
+		{"println(c[0], a[0])", "99 1\n"},
+	}
+	for _, test := range tests {
+		if _, err := i.Eval(test.src); err != nil {
+			t.Errorf("%q: %v", test.src, err)
+		}
+		if got := stdout.String(); got != test.want {
+			t.Errorf("%q printed %q, want %q", test.src, got, test.want)
+		}
+		stdout.Reset()
+	}
+}
+
+func TestArrayIndexOutOfRange(t *testing.T) {
+	got := session("n := 5", "s := make([]int, 2)", "s[n]")
+	if want := "error: panic: runtime error: index out of range [5] with length 2\n"; got != want {
+		t.Errorf("slice: got %q, want %q", got, want)
+	}
+
+	i := New(Options{})
+	if err := i.Define("a", [3]int{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		src  string
+		want string
+	}{
+		{"a[3]", "input:1: invalid argument: index 3 out of bounds [0:3]"},
+		{"n := -1", ""},
+		{"a[n]", "panic: runtime error: index out of range [-1] with length 3"},
+		{"p := &a", ""},
+		{"p[n+4]", "panic: runtime error: index out of range [3] with length 3"},
+	} {
+		_, err := i.Eval(test.src)
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != test.want {
+			t.Errorf("%q: got error %q, want %q", test.src, got, test.want)
+		}
+	}
+}
@@ -2,7 +2,7 @@ package interp
 
 import (
 	"go/ast"
-	"log"
+	"go/token"
 	"reflect"
 
 	"golang.org/x/tools/go/types"
@@ -11,18 +11,48 @@ import (
 // TODO: this only covers very simple assignment. There are more complicated rules
 //   not yet implemented (see http://golang.org/ref/spec#Assignments), such as:
 //   1) nil
-//   2) Blank identifier
-
-func (env *environ) getAssignmentLhs(exprs []ast.Expr) ([]Object, map[int]bool) {
-	objs := make([]Object, len(exprs))
-	mapIndexExprs := make(map[int]bool)
-	for i, expr := range exprs {
-		if isMapIndexExpr(env, expr) {
-			mapIndexExprs[i] = true
+
+// An assignTarget is a compiled left-hand side expression of an assignment.
+// Map index expressions are assigned with setMap, since their values can't be
+// set. The value of the expression is only needed to assign to it, or for an
+// assignment operation, and there is neither for the blank identifier.
+type assignTarget struct {
+	get    evalFunc
+	setMap func(env *environ, rObj Object)
+}
+
+// assignTargets compiles the left-hand side of an assignment with tok, which
+// is DEFINE for a short variable declaration.
+func (c *compiler) assignTargets(exprs []ast.Expr, tok token.Token) []assignTarget {
+	targets := make([]assignTarget, len(exprs))
+	for j, expr := range exprs {
+		if tok == token.DEFINE {
+			// Short variable declaration
+			targets[j].get = c.declare(expr.(*ast.Ident))
+			continue
+		}
+		if id, ok := expr.(*ast.Ident); ok && id.Name == "_" {
+			continue
 		}
-		objs[i] = env.Eval(expr)[0]
+		if c.isMapIndexExpr(expr) {
+			targets[j].setMap = c.assignMapIndex(expr.(*ast.IndexExpr))
+			if tok == token.ASSIGN {
+				continue
+			}
+		}
+		targets[j].get = c.expr(expr)
+	}
+	return targets
+}
+
+// assign assigns rObj to the target, whose value is lObj.
+func (t *assignTarget) assign(env *environ, lObj, rObj Object) {
+	switch {
+	case t.setMap != nil:
+		t.setMap(env, rObj)
+	case t.get != nil:
+		assignObj(lObj, rObj)
 	}
-	return objs, mapIndexExprs
 }
 
 // assignObj assigns value of rObj to lObj.
@@ -37,26 +67,52 @@ func assignObj(lObj, rObj Object) {
 		// Must be untyped nil
 		lVal.Set(reflect.Zero(lVal.Type()))
 	}
+	if lObj.fn != nil {
+		lObj.fn.cl = rObj.closure()
+	}
+}
+
+// copyObjs returns objs with the values of variables copied to new variables,
+// for the arguments of a call made later, which mustn't see later assignments.
+func copyObjs(objs []Object) []Object {
+	copies := make([]Object, len(objs))
+	for j, obj := range objs {
+		if val, ok := obj.Value.(reflect.Value); ok && val.CanSet() {
+			newVal := reflect.New(val.Type()).Elem()
+			newVal.Set(val)
+			obj.Value = newVal
+			if obj.fn != nil {
+				obj.fn = &closureRef{cl: obj.closure()}
+			}
+		}
+		copies[j] = obj
+	}
+	return copies
 }
 
-func (env *environ) assignMapIndex(expr ast.Expr, rObj Object) {
-	indexExpr := expr.(*ast.IndexExpr)
-	mapObj := env.Eval(indexExpr.X)[0]
-	keyObj := env.Eval(indexExpr.Index)[0]
+func (c *compiler) assignMapIndex(indexExpr *ast.IndexExpr) func(env *environ, rObj Object) {
+	m := c.expr(indexExpr.X)
+	key := c.expr(indexExpr.Index)
+	elemTyp := c.info.TypeOf(indexExpr.X).Underlying().(*types.Map).Elem()
+	rTyp, _ := getReflectType(c.interp.typeMap, elemTyp)
+	if rTyp == nil {
+		c.errorf(indexExpr.Pos(), "Maps of %s not implemented yet", elemTyp)
+		return nil
+	}
+
+	return func(env *environ, rObj Object) {
+		mapObj := m(env)
+		keyObj := key(env)
 
-	mapVal := mapObj.Value.(reflect.Value)
-	keyVal := keyObj.Value.(reflect.Value)
+		mapVal := mapObj.Value.(reflect.Value)
+		keyVal := keyObj.Value.(reflect.Value)
 
-	rVal, ok := rObj.Value.(reflect.Value)
-	if ok {
-		mapVal.SetMapIndex(keyVal, rVal)
-	} else {
-		// Must be untyped nil
-		elemTyp := mapObj.Typ.(*types.Map).Elem()
-		rTyp, _ := getReflectType(env.interp.typeMap, elemTyp)
-		if rTyp == nil {
-			log.Fatal("Failed to obtain reflect.Type to represent type:", elemTyp)
+		rVal, ok := rObj.Value.(reflect.Value)
+		if ok {
+			mapVal.SetMapIndex(keyVal, rVal)
+		} else {
+			// Must be untyped nil
+			mapVal.SetMapIndex(keyVal, reflect.Zero(rTyp))
 		}
-		mapVal.SetMapIndex(keyVal, reflect.Zero(rTyp))
 	}
 }
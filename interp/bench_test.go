@@ -0,0 +1,38 @@
+package interp
+
+import (
+	"context"
+	"testing"
+)
+
+// benchInput runs src, compiled once, b.N times.
+func benchInput(b *testing.B, src string) {
+	i := New(Options{})
+	run, err := i.Compile(src)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := run(context.Background()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLoop(b *testing.B) {
+	benchInput(b, "s := 0\nfor i := 0; i < 10000; i++ {\ns += i\n}")
+}
+
+func BenchmarkArith(b *testing.B) {
+	benchInput(b, "x := 0.0\nfor i := 0; i < 10000; i++ {\nx = x*1.0001 + float64(i)/3\n}")
+}
+
+func BenchmarkFuncLoop(b *testing.B) {
+	benchInput(b, "f := func(n int) int {\nt := 0\nfor i := 0; i < n; i++ {\nt += i * 2\n}\nreturn t\n}\nf(10000)")
+}
+
+func BenchmarkCalls(b *testing.B) {
+	benchInput(b, "f := func(a int) int { return a + 1 }\nn := 0\nfor i := 0; i < 1000; i++ {\nn = f(n)\n}")
+}
@@ -3,7 +3,7 @@ package interp
 import (
 	"fmt"
 	"go/ast"
-	"log"
+	"go/token"
 	"reflect"
 )
 
@@ -12,106 +12,194 @@ import (
 //   * slice types
 //   * map types
 
-func (env *environ) evalBuiltinCall(callExpr *ast.CallExpr, async bool) []Object {
+// builtinCall compiles a call of a builtin function. If how is GO or DEFER, the
+// code calls it as a go or defer statement does. Otherwise how is ILLEGAL.
+func (c *compiler) builtinCall(callExpr *ast.CallExpr, how token.Token) multiFunc {
 	// TODO: implement builtins
 	builtinName := callExpr.Fun.(*ast.Ident).Name
-	var results []Object
 	switch builtinName {
 	case "append":
-		log.Fatal("append function not implemented yet")
+		appendFunc := c.append(callExpr)
+		return func(env *environ) []Object {
+			env.thread.step()
+			return []Object{appendFunc(env)}
+		}
 	case "cap":
-		log.Fatal("cap function not implemented yet")
+		return single(c.notImplemented(callExpr, "cap function not implemented yet"))
 	case "close":
-		argObj := env.evalFuncArgs(callExpr.Args)[0]
-		argObj.Value.(reflect.Value).Close()
+		args := c.exprs(callExpr.Args)
+		return func(env *environ) []Object {
+			env.thread.step()
+			argObjs := args(env)
+			if how != token.ILLEGAL {
+				chanVal := copyObjs(argObjs)[0].Value.(reflect.Value)
+				env.later(how, callExpr.Pos(), func(*thread) { chanVal.Close() })
+				return nil
+			}
+			argObjs[0].Value.(reflect.Value).Close()
+			return nil
+		}
 	case "complex":
-		log.Fatal("complex function not implemented yet")
+		return single(c.notImplemented(callExpr, "complex function not implemented yet"))
 	case "len":
-		log.Fatal("len function not implemented yet")
+		return single(c.notImplemented(callExpr, "len function not implemented yet"))
 	case "make":
-		obj := env.evalMake(callExpr.Args)
-		return []Object{obj}
+		makeFunc := c.make(callExpr.Args)
+		return func(env *environ) []Object {
+			env.thread.step()
+			return []Object{makeFunc(env)}
+		}
 	case "new":
-		log.Fatal("new function not implemented yet")
+		return single(c.notImplemented(callExpr, "new function not implemented yet"))
 	case "panic":
-		log.Fatal("panic function not implemented yet")
+		return single(c.notImplemented(callExpr, "panic function not implemented yet"))
 	case "print":
-		// Just forward to fmt.Print
-		fun := reflect.ValueOf(fmt.Print)
-		argObjs := env.evalFuncArgs(callExpr.Args)
-		if async {
-			go callFunWithObjs(fun, argObjs)
-		} else {
-			callFunWithObjs(fun, argObjs)
-		}
+		// Just forward to fmt.Print, writing to the interpreter's stdout
+		stdout := c.interp.stdout
+		fun := reflect.ValueOf(func(a ...interface{}) (int, error) {
+			return fmt.Fprint(stdout, a...)
+		})
+		return c.printCall(callExpr, fun, how)
 	case "println":
-		// Just forward to fmt.Println
-		fun := reflect.ValueOf(fmt.Println)
-		argObjs := env.evalFuncArgs(callExpr.Args)
-		if async {
-			go callFunWithObjs(fun, argObjs)
-		} else {
-			callFunWithObjs(fun, argObjs)
-		}
+		// Just forward to fmt.Println, writing to the interpreter's stdout
+		stdout := c.interp.stdout
+		fun := reflect.ValueOf(func(a ...interface{}) (int, error) {
+			return fmt.Fprintln(stdout, a...)
+		})
+		return c.printCall(callExpr, fun, how)
 	case "real":
-		log.Fatal("real function not implemented yet")
+		return single(c.notImplemented(callExpr, "real function not implemented yet"))
 	case "recover":
-		log.Fatal("recover function not implemented yet")
-	default:
-		log.Fatalf("builtin function %s not implemented yet", builtinName)
+		return single(c.notImplemented(callExpr, "recover function not implemented yet"))
+	}
+	return single(c.notImplemented(callExpr, "builtin function %s not implemented yet", builtinName))
+}
+
+// printCall compiles a call of print or println, which calls fun instead.
+func (c *compiler) printCall(callExpr *ast.CallExpr, fun reflect.Value, how token.Token) multiFunc {
+	args := c.exprs(callExpr.Args)
+	return func(env *environ) []Object {
+		env.thread.step()
+		argObjs := args(env)
+		if how != token.ILLEGAL {
+			argObjs = copyObjs(argObjs)
+			env.later(how, callExpr.Pos(), func(*thread) { env.interp.callFunWithObjs(fun, argObjs, false) })
+		} else {
+			env.interp.callFunWithObjs(fun, argObjs, false)
+		}
+		return nil
 	}
-	return results
 }
 
-func (env *environ) evalMake(argExprs []ast.Expr) Object {
+func (c *compiler) make(argExprs []ast.Expr) evalFunc {
 	// TODO: not finished!
 	typeExpr := argExprs[0]
-	typ := env.info.Types[typeExpr].Type
-	rtyp, sim := getReflectType(env.interp.typeMap, typ)
+	typ := c.info.Types[typeExpr].Type
+	rtyp, sim := getReflectType(c.interp.typeMap, typ)
 	if rtyp == nil {
-		log.Fatal("Failed to get reflect.Type to make")
+		return c.notImplemented(typeExpr, "make of %s not implemented yet", typ)
 	}
 	switch rtyp.Kind() {
 	case reflect.Chan:
-		buffer := 0
+		var args multiFunc
 		if len(argExprs) > 1 {
-			args := env.evalFuncArgs(argExprs[1:])
-			buffer = int(args[0].Value.(reflect.Value).Int())
+			args = c.exprs(argExprs[1:])
 		}
-		chanVal := reflect.MakeChan(rtyp, buffer)
-		return Object{
-			Value: chanVal,
-			Typ:   typ,
-			Sim:   sim,
+		return func(env *environ) Object {
+			buffer := 0
+			if args != nil {
+				buffer = int(args(env)[0].Value.(reflect.Value).Int())
+			}
+			env.thread.alloc(rtyp.Elem(), buffer)
+			chanVal := reflect.MakeChan(rtyp, buffer)
+			return Object{
+				Value: chanVal,
+				Typ:   typ,
+				Sim:   sim,
+			}
 		}
 	case reflect.Map:
 		// We are forced to ignore a length if given, since the reflect package
 		// does not provide any way to specify it.
-		mapVal := reflect.MakeMap(rtyp)
-		return Object{
-			Value: mapVal,
-			Typ:   typ,
-			Sim:   sim,
+		return func(env *environ) Object {
+			mapVal := reflect.MakeMap(rtyp)
+			return Object{
+				Value: mapVal,
+				Typ:   typ,
+				Sim:   sim,
+			}
 		}
 	case reflect.Slice:
-		args := env.evalFuncArgs(argExprs[1:])
-		sliceLen := int(args[0].Value.(reflect.Value).Int())
-		sliceCap := sliceLen
-		if len(args) > 1 {
-			sliceCap = int(args[1].Value.(reflect.Value).Int())
+		args := c.exprs(argExprs[1:])
+		return func(env *environ) Object {
+			argObjs := args(env)
+			sliceLen := int(argObjs[0].Value.(reflect.Value).Int())
+			sliceCap := sliceLen
+			if len(argObjs) > 1 {
+				sliceCap = int(argObjs[1].Value.(reflect.Value).Int())
+			}
+			env.thread.alloc(rtyp.Elem(), sliceCap)
+			sliceVal := reflect.MakeSlice(rtyp, sliceLen, sliceCap)
+			return Object{
+				Value: sliceVal,
+				Typ:   typ,
+				Sim:   sim,
+			}
+		}
+	}
+	return c.notImplemented(typeExpr, "make of %s not implemented yet", typ)
+}
+
+func (c *compiler) append(callExpr *ast.CallExpr) evalFunc {
+	typ := c.info.TypeOf(callExpr)
+	rtyp, sim := getReflectType(c.interp.typeMap, typ)
+	if rtyp == nil {
+		return c.notImplemented(callExpr, "append to %s not implemented yet", typ)
+	}
+	args := c.exprs(callExpr.Args)
+	ellipsis := callExpr.Ellipsis.IsValid()
+
+	return func(env *environ) Object {
+		argObjs := args(env)
+
+		sliceVal := reflect.Zero(rtyp)
+		if v, ok := argObjs[0].Value.(reflect.Value); ok {
+			// Otherwise untyped nil
+			sliceVal = v
+		}
+
+		var elems reflect.Value
+		if ellipsis {
+			// append(s, t...), where t may be a string if s is a []byte
+			switch v := getTypedObject(argObjs[1]).Value.(type) {
+			case reflect.Value:
+				elems = v
+				if elems.Kind() == reflect.String {
+					elems = elems.Convert(rtyp)
+				}
+			default:
+				// Must be untyped nil
+				elems = reflect.Zero(rtyp)
+			}
+		} else {
+			elems = reflect.MakeSlice(rtyp, 0, len(argObjs)-1)
+			for _, arg := range argObjs[1:] {
+				elem := reflect.Zero(rtyp.Elem())
+				if v, ok := arg.Value.(reflect.Value); ok {
+					// Otherwise untyped nil
+					elem = v
+				}
+				elems = reflect.Append(elems, elem)
+			}
+		}
+
+		if n := sliceVal.Len() + elems.Len(); n > sliceVal.Cap() {
+			env.thread.alloc(rtyp.Elem(), n)
 		}
-		sliceVal := reflect.MakeSlice(rtyp, sliceLen, sliceCap)
 		return Object{
-			Value: sliceVal,
+			Value: reflect.AppendSlice(sliceVal, elems),
 			Typ:   typ,
 			Sim:   sim,
 		}
-	default:
-		log.Fatal("make function called with unexpected type")
-	}
-	return Object{
-		Value: nil,
-		Typ:   typ,
-		Sim:   sim,
 	}
 }
@@ -2,6 +2,7 @@ package interp
 
 import (
 	"go/ast"
+	"go/token"
 	"reflect"
 
 	"golang.org/x/tools/go/types"
@@ -15,7 +16,7 @@ const (
 	conversionKind                     // The expression is a conversion
 )
 
-func (env *environ) getCallExprKind(callExpr *ast.CallExpr) callExprKind {
+func (c *compiler) callKind(callExpr *ast.CallExpr) callExprKind {
 	kindFromObj := func(obj types.Object) callExprKind {
 		switch obj.(type) {
 		case *types.Builtin:
@@ -30,10 +31,10 @@ func (env *environ) getCallExprKind(callExpr *ast.CallExpr) callExprKind {
 	kindFromSubExpr = func(e ast.Expr) callExprKind {
 		switch e := e.(type) {
 		case *ast.Ident:
-			obj := env.info.Uses[e]
+			obj := c.info.Uses[e]
 			return kindFromObj(obj)
 		case *ast.SelectorExpr:
-			obj := env.info.Uses[e.Sel]
+			obj := c.info.Uses[e.Sel]
 			return kindFromObj(obj)
 		case *ast.ArrayType, *ast.ChanType, *ast.InterfaceType,
 			*ast.FuncType, *ast.MapType, *ast.StructType:
@@ -48,67 +49,210 @@ func (env *environ) getCallExprKind(callExpr *ast.CallExpr) callExprKind {
 	return kindFromSubExpr(callExpr.Fun)
 }
 
-func (env *environ) evalFuncArgs(argExprs []ast.Expr) []Object {
-	return env.evalExprs(argExprs)
-}
-
 // callFunWithObjs calls the given function on the arguments given as a slice of Object.
 // It first converts the arguments to a slice of reflect.Value. It assumes that any Object
 // in the given slice whose Value field is not a reflect.Value is an untyped nil, which
-// should always be true in practice.
-func callFunWithObjs(fun reflect.Value, argObjs []Object) []reflect.Value {
+// should always be true in practice. Simulated functions are passed as functions of the
+// parameter type that call them. If slice is set, the function is variadic and the last
+// argument is the slice of its variadic arguments, as for reflect.Value.CallSlice.
+func (i *interp) callFunWithObjs(fun reflect.Value, argObjs []Object, slice bool) []reflect.Value {
 	argVals := make([]reflect.Value, len(argObjs))
 	funType := fun.Type()
-	for i, argObj := range argObjs {
+	for j, argObj := range argObjs {
+		var rtyp reflect.Type
+		if funType.IsVariadic() && !slice && j >= funType.NumIn()-1 {
+			rtyp = funType.In(funType.NumIn() - 1).Elem()
+		} else {
+			rtyp = funType.In(j)
+		}
 		argVal, ok := argObj.Value.(reflect.Value)
-		if !ok {
+		switch {
+		case !ok:
 			// Must be untyped nil. Use zero value of type instead
-			var rtyp reflect.Type
-			if funType.IsVariadic() && i == funType.NumIn()-1 {
-				rtyp = funType.In(i).Elem()
-			} else {
-				rtyp = fun.Type().In(i)
-			}
 			argVal = reflect.Zero(rtyp)
+		case argObj.Sim && rtyp.Kind() == reflect.Func:
+			argVal = i.realFunc(argVal.Interface().(simulatedFunc), rtyp)
 		}
-		argVals[i] = argVal
+		argVals[j] = argVal
+	}
+	if slice {
+		return fun.CallSlice(argVals)
 	}
 	return fun.Call(argVals)
 }
 
-func (env *environ) evalFuncCall(callExpr *ast.CallExpr, async bool) []Object {
-	funObj := env.Eval(callExpr.Fun)[0]
-	fun := funObj.Value.(reflect.Value)
+// callArgs compiles the arguments of a call of a function with the signature sig,
+// to one Object for each parameter. The arguments may be the results of a single
+// call. Untyped constants are converted to the types of their parameters. Unless
+// the call passes a slice with "...", the arguments of the variadic parameter of a
+// variadic function are passed in a new slice, which is nil if there are none.
+func (c *compiler) callArgs(callExpr *ast.CallExpr, sig *types.Signature) multiFunc {
+	params := sig.Params()
+	n := params.Len()
+	spread := sig.Variadic() && !callExpr.Ellipsis.IsValid()
 
-	argObjs := env.evalFuncArgs(callExpr.Args)
-	if funObj.Sim {
-		// Call by actually calling it
-		funVal := fun.Interface().(func([]Object) []Object)
-		if !async {
-			results := funVal(argObjs)
-			return results
-		} else {
-			go funVal(argObjs)
-			return nil
-		}
+	var args multiFunc
+	if len(callExpr.Args) == 1 && isTuple(c.info.TypeOf(callExpr.Args[0])) {
+		// f(g()), passing the results of g
+		args = c.multi(callExpr.Args[0])
 	} else {
-		// Now call the function on the args
-		if !async {
-			resultVals := callFunWithObjs(fun, argObjs)
-
-			// Wrap the output values in Objects
-			results := make([]Object, len(resultVals))
-			for i, resVal := range resultVals {
-				results[i] = Object{
-					Value: resVal,
-					Typ:   funObj.Typ.Underlying().(*types.Signature).Results().At(i).Type(),
+		evals := make([]evalFunc, len(callExpr.Args))
+		for j, arg := range callExpr.Args {
+			var typ types.Type
+			if spread && j >= n-1 {
+				typ = params.At(n - 1).Type().(*types.Slice).Elem()
+			} else {
+				typ = params.At(j).Type()
+			}
+			evals[j] = c.arg(arg, typ)
+		}
+		args = func(env *environ) []Object {
+			objs := make([]Object, len(evals))
+			for j, eval := range evals {
+				objs[j] = eval(env)
+			}
+			return objs
+		}
+	}
+	if !spread {
+		return args
+	}
+
+	sliceTyp := params.At(n - 1).Type()
+	rtyp, sim := getReflectType(c.interp.typeMap, sliceTyp)
+	if rtyp == nil || sim {
+		return single(c.notImplemented(callExpr, "Variadic parameter of type %s not implemented yet", sliceTyp))
+	}
+	return func(env *environ) []Object {
+		objs := args(env)
+		rest := objs[n-1:]
+		sliceVal := reflect.Zero(rtyp)
+		if len(rest) > 0 {
+			env.thread.alloc(rtyp.Elem(), len(rest))
+			sliceVal = reflect.MakeSlice(rtyp, len(rest), len(rest))
+			for j, obj := range rest {
+				v, ok := obj.Value.(reflect.Value)
+				if !ok {
+					// Must be untyped nil, and the element is already the zero value
+					continue
 				}
+				if obj.Sim && rtyp.Elem().Kind() == reflect.Func {
+					v = env.interp.realFunc(v.Interface().(simulatedFunc), rtyp.Elem())
+				}
+				sliceVal.Index(j).Set(v)
+			}
+		}
+		return append(objs[:n-1:n-1], Object{
+			Value: sliceVal,
+			Typ:   sliceTyp,
+		})
+	}
+}
+
+// isTuple reports whether typ is the type of several results.
+func isTuple(typ types.Type) bool {
+	_, ok := typ.(*types.Tuple)
+	return ok
+}
+
+// arg compiles an argument passed to a parameter of type typ. An untyped
+// constant is converted to typ, or to its default type if typ is an interface.
+func (c *compiler) arg(expr ast.Expr, typ types.Type) evalFunc {
+	tv := c.info.Types[expr]
+	if tv.Value == nil || isTyped(tv.Type) {
+		return c.expr(expr)
+	}
+	if _, ok := typ.Underlying().(*types.Basic); !ok {
+		typ = typedBasic(tv.Type)
+	}
+	obj := Object{
+		Value: convertExactToReflect(c.interp.typeMap, types.TypeAndValue{Type: typ, Value: tv.Value}),
+		Typ:   typ,
+	}
+	return func(env *environ) Object {
+		env.thread.step()
+		return obj
+	}
+}
+
+// later runs f as the statement of a call says: in a new goroutine started at
+// pos for a go statement (GO), or when the interpreted function running on the
+// thread returns for a defer statement (DEFER).
+func (env *environ) later(how token.Token, pos token.Pos, f func(t *thread)) {
+	if how == token.GO {
+		env.interp.goroutine(env.thread, pos, f)
+		return
+	}
+	env.thread.deferCall(f)
+}
+
+// funcCall compiles a call of a function. If how is GO or DEFER, the code calls
+// it as a go or defer statement does, and has no results. Otherwise how is ILLEGAL.
+func (c *compiler) funcCall(callExpr *ast.CallExpr, how token.Token) multiFunc {
+	fun := c.expr(callExpr.Fun)
+	sig := c.info.TypeOf(callExpr.Fun).Underlying().(*types.Signature)
+	args := c.callArgs(callExpr, sig)
+	resultTypes := make([]types.Type, sig.Results().Len())
+	for j := range resultTypes {
+		resultTypes[j] = sig.Results().At(j).Type()
+	}
+	name := types.ExprString(callExpr.Fun)
+	slice := sig.Variadic()
+
+	return func(env *environ) []Object {
+		env.thread.step()
+		funObj := fun(env)
+		funVal := funObj.Value.(reflect.Value)
+
+		argObjs := args(env)
+		if funObj.Sim {
+			// Call by actually calling it
+			f := funVal.Interface().(simulatedFunc)
+			if how != token.ILLEGAL {
+				argObjs = copyObjs(argObjs)
+				env.later(how, callExpr.Pos(), func(t *thread) { f(t, argObjs) })
+				return nil
+			}
+			results := f(env.thread, argObjs)
+			if env.tracing() {
+				env.traceCall(callExpr, results)
 			}
 			return results
-		} else {
-			go callFunWithObjs(fun, argObjs)
+		}
+
+		if cl := funObj.closure(); cl != nil {
+			// An interpreted function, which we run ourselves on this thread
+			if how != token.ILLEGAL {
+				argObjs = copyObjs(argObjs)
+				env.later(how, callExpr.Pos(), func(t *thread) { cl.fn.call(cl.env, t, argObjs) })
+				return nil
+			}
+			results := cl.fn.call(cl.env, env.thread, argObjs)
+			if env.tracing() {
+				env.traceCall(callExpr, results)
+			}
+			return results
+		}
+
+		// Now call the function on the args
+		if how != token.ILLEGAL {
+			argObjs = copyObjs(argObjs)
+			env.later(how, callExpr.Pos(), func(t *thread) { env.interp.callCompiled(t, name, funVal, argObjs, slice) })
 			return nil
 		}
+		resultVals := env.interp.callCompiled(env.thread, name, funVal, argObjs, slice)
 
+		// Wrap the output values in Objects
+		results := make([]Object, len(resultVals))
+		for j, resVal := range resultVals {
+			results[j] = Object{
+				Value: resVal,
+				Typ:   resultTypes[j],
+			}
+		}
+		if env.tracing() {
+			env.traceCall(callExpr, results)
+		}
+		return results
 	}
 }
@@ -0,0 +1,87 @@
+package interp
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func sumInts(xs ...int) int {
+	n := 0
+	for _, x := range xs {
+		n += x
+	}
+	return n
+}
+
+// Calls of compiled and interpreted functions, variadic or not, with arguments
+// spread from a slice or forwarded from the results of another call, and of
+// interpreted functions by compiled ones.
+func TestCalls(t *testing.T) {
+	var stdout bytes.Buffer
+	i := New(Options{Stdout: &stdout})
+	for name, v := range map[string]interface{}{
+		"sprintf":   fmt.Sprintf,
+		"sum":       sumInts,
+		"mapRunes":  strings.Map,
+		"sortSlice": sort.Slice,
+	} {
+		if err := i.Define(name, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		src  string
+		want string
+	}{
+		// Compiled variadic functions
+		{`println(sprintf("%d-%s-%v", 1, "a", 2.5))`, "1-a-2.5\n"},
+		{`args := append(make([]interface{}, 0), 7, "x")`, ""},
+		{`println(sprintf("%v/%v", args...))`, "7/x\n"},
+		{"nums := append(make([]int, 0), 1, 2, 3)", ""},
+		{"println(sum(), sum(4), sum(nums...))", "0 4 6\n"},
+
+		// Forwarding the results of a call
+		{`g := func() (string, int, int) { return "%d+%d", 3, 4 }`, ""},
+		{"println(sprintf(g()))", "3+4\n"},
+		{"two := func() (int, int) { return 5, 6 }", ""},
+		{"println(sum(two()))", "11\n"},
+
+		// Interpreted variadic functions
+		{"f := func(p string, xs ...int) []int { return xs }", ""},
+		{`println(f("a") == nil)`, "true\n"},
+		{`r := f("a", 1, 2)`, ""},
+		{"println(r[0], r[1])", "1 2\n"},
+		{`s := f("a", nums...)`, ""},
+		{"s[0] = 9", ""},
+		{"println(nums[0])", "9\n"},
+		{`h := func() (string, int, int) { return "q", 6, 7 }`, ""},
+		{"u := f(h())", ""},
+		{"println(u[0], u[1])", "6 7\n"},
+
+		// Interpreted closures called by compiled functions
+		{`println(mapRunes(func(r rune) rune { return r + 1 }, "abc"))`, "bcd\n"},
+		{"v := append(make([]int, 0), 5, 2, 9, 1)", ""},
+		{"sortSlice(v, func(i, j int) bool { return v[i] < v[j] })", ""},
+		{"println(v[0], v[1], v[2], v[3])", "1 2 5 9\n"},
+	}
+	for _, test := range tests {
+		if _, err := i.Eval(test.src); err != nil {
+			t.Errorf("%q: %v", test.src, err)
+		}
+		if got := stdout.String(); got != test.want {
+			t.Errorf("%q printed %q, want %q", test.src, got, test.want)
+		}
+		stdout.Reset()
+	}
+
+	// The results of a call can't be spread
+	if _, err := i.Eval("println(sum(two()...))"); err == nil {
+		t.Errorf("spreading the results of a call: got no error")
+	}
+	if got := stdout.String(); got != "" {
+		t.Errorf("spreading the results of a call printed %q", got)
+	}
+}
@@ -0,0 +1,224 @@
+package interp
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+
+	"golang.org/x/tools/go/types"
+)
+
+// Inputs are compiled before they run, into trees of closures. Everything that
+// only depends on the code is worked out once by the compiler: the types of
+// expressions, the values of constants, which package objects are referred to,
+// and where each variable will be kept. Running the closures then does only
+// what depends on the values.
+//
+// An evalFunc evaluates a single-valued expression, a multiFunc evaluates an
+// expression or expression list to all of its values, and an execFunc runs a
+// statement.
+type (
+	evalFunc  func(env *environ) Object
+	multiFunc func(env *environ) []Object
+	execFunc  func(env *environ) stmtResult
+)
+
+// A compiler compiles the code of one type-checked input or expression.
+type compiler struct {
+	interp *interp
+	fset   *token.FileSet
+	info   *types.Info
+	block  *block // the innermost block with variables, or nil at top level
+
+	// The first error compiling the code, which then doesn't run
+	err error
+}
+
+// A block is a scope whose variables are kept in the slots of an environment,
+// rather than by name like the variables declared at top level: a block
+// statement, the implicit block of an if or for statement or select case, or a
+// function literal. The variables of a function literal's closure that are
+// declared outside it are kept in a block of their own, one for each closure.
+type block struct {
+	parent *block
+	slots  map[*types.Var]int
+	names  []string // by slot
+
+	// For the block of a closure, the function literal and the variables it
+	// captures, in the order of their slots
+	lit      *ast.FuncLit
+	captured []*types.Var
+}
+
+// newBlock returns a block for the variables of scope, inside parent.
+func newBlock(parent *block, scope *types.Scope) *block {
+	b := &block{
+		parent: parent,
+		slots:  map[*types.Var]int{},
+	}
+	if scope == nil {
+		return b
+	}
+	for _, name := range scope.Names() {
+		if v, ok := scope.Lookup(name).(*types.Var); ok {
+			b.slots[v] = len(b.names)
+			b.names = append(b.names, name)
+		}
+	}
+	return b
+}
+
+// newEnv returns the environment to run the code of b in, inside env. A nil
+// block has no variables, so its code runs in env itself.
+func (b *block) newEnv(env *environ) *environ {
+	if b == nil {
+		return env
+	}
+	return &environ{
+		interp:   env.interp,
+		thread:   env.thread,
+		parent:   env,
+		vars:     make([]Object, len(b.names)),
+		varNames: b.names,
+	}
+}
+
+// enter starts compiling the code of scope, in a block of its own if scope has
+// variables. It returns the block, or nil if there isn't one, to pass to leave.
+func (c *compiler) enter(scope *types.Scope) *block {
+	b := newBlock(c.block, scope)
+	if len(b.names) == 0 {
+		return nil
+	}
+	c.block = b
+	return b
+}
+
+// leave finishes compiling the code of a scope started by enter.
+func (c *compiler) leave(b *block) {
+	if b != nil {
+		c.block = b.parent
+	}
+}
+
+// variable compiles a reference to the variable v named name. Variables in
+// blocks are found by counting environments up to the one holding them, and
+// others by name, from the top-level environment of the input.
+func (c *compiler) variable(v *types.Var, name string) evalFunc {
+	hops := 0
+	inClosure := false
+	for b := c.block; b != nil; b = b.parent {
+		if slot, ok := b.slots[v]; ok {
+			return func(env *environ) Object {
+				for j := 0; j < hops; j++ {
+					env = env.parent
+				}
+				return env.vars[slot]
+			}
+		}
+		if b.lit != nil {
+			if v != nil && v.Pos().IsValid() && (v.Pos() < b.lit.Pos() || v.Pos() >= b.lit.End()) {
+				// Declared outside the function literal, so its closures capture it
+				slot := len(b.names)
+				b.slots[v] = slot
+				b.names = append(b.names, name)
+				b.captured = append(b.captured, v)
+				return func(env *environ) Object {
+					for j := 0; j < hops; j++ {
+						env = env.parent
+					}
+					return env.vars[slot]
+				}
+			}
+			inClosure = true
+		}
+		hops++
+	}
+	if inClosure {
+		// A closure's environment isn't inside the one it was made in
+		return func(env *environ) Object {
+			obj, _ := env.lookupParent(name)
+			return obj
+		}
+	}
+	return func(env *environ) Object {
+		for j := 0; j < hops; j++ {
+			env = env.parent
+		}
+		obj, _ := env.lookupParent(name)
+		return obj
+	}
+}
+
+// stmtList compiles a list of statements.
+func (c *compiler) stmtList(list []ast.Stmt, topLevel bool) []execFunc {
+	code := make([]execFunc, len(list))
+	for j, stmt := range list {
+		code[j] = c.stmt(stmt, "", topLevel)
+	}
+	return code
+}
+
+// compileInput compiles the statements of an input.
+func (i *interp) compileInput(in *input) ([]execFunc, error) {
+	c := &compiler{
+		interp: i,
+		fset:   i.fset,
+		info:   in.info,
+	}
+	code := c.stmtList(in.stmts, true)
+	return code, c.err
+}
+
+// compileExpr compiles the expression of an Evaluator, parsed with fset.
+func (i *interp) compileExpr(fset *token.FileSet, info *types.Info, expr ast.Expr) (evalFunc, error) {
+	c := &compiler{
+		interp: i,
+		fset:   fset,
+		info:   info,
+	}
+	code := c.expr(expr)
+	return code, c.err
+}
+
+// cond compiles a condition, which may be an untyped boolean constant.
+func (c *compiler) cond(expr ast.Expr) func(env *environ) bool {
+	return c.boolExpr(expr)
+}
+
+// errorf records an error at pos, unless compiling the code already failed.
+func (c *compiler) errorf(pos token.Pos, format string, args ...interface{}) {
+	if c.err == nil {
+		c.err = types.Error{
+			Fset: c.fset,
+			Pos:  pos,
+			Msg:  fmt.Sprintf(format, args...),
+		}
+	}
+}
+
+// notImplemented reports that node uses what isn't implemented yet, with the
+// message given by format and args. Compiling the input then fails, so the
+// returned code never runs.
+func (c *compiler) notImplemented(node ast.Node, format string, args ...interface{}) evalFunc {
+	c.errorf(node.Pos(), format, args...)
+	return func(env *environ) Object {
+		panic("goconsole: ran code that failed to compile")
+	}
+}
+
+// execNotImplemented is notImplemented for statements.
+func (c *compiler) execNotImplemented(node ast.Node, format string, args ...interface{}) execFunc {
+	fail := c.notImplemented(node, format, args...)
+	return func(env *environ) stmtResult {
+		fail(env)
+		return nil
+	}
+}
+
+// single returns eval as code for all the values of its expression.
+func single(eval evalFunc) multiFunc {
+	return func(env *environ) []Object {
+		return []Object{eval(env)}
+	}
+}
@@ -0,0 +1,186 @@
+package interp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// session runs inputs one at a time in a new interpreter, returning what they
+// print, the values of their expression statements and their errors.
+func session(inputs ...string) string {
+	var stdout bytes.Buffer
+	i := New(Options{Stdout: &stdout})
+	var out bytes.Buffer
+	for _, src := range inputs {
+		results, err := i.Eval(src)
+		out.Write(stdout.Bytes())
+		stdout.Reset()
+		for _, obj := range results {
+			fmt.Fprintf(&out, "=> %s %s\n", formatObj(obj), TypeString(obj.Typ))
+		}
+		if err != nil {
+			fmt.Fprintf(&out, "error: %v\n", err)
+		}
+	}
+	return out.String()
+}
+
+// The closure compiler replaced an interpreter that walked the syntax tree of
+// each input as it ran. These sessions give the results the tree walker gave.
+var sessionTests = []struct {
+	inputs []string
+	want   string
+}{
+	{
+		[]string{"x := 3", "y := x*2 + 1", "println(x, y)", "x += 5", "x++", "y--", "println(x, y)", "x * y", "x / 2", "x % 3", "x << 2", "x > y", "x == 9"},
+		"3 7\n9 6\n=> 54 int\n=> 4 int\n=> 0 int\n=> 36 int\n=> true untyped bool\n=> true untyped bool\n",
+	},
+	{
+		[]string{"f := 1.5", "g := f * 2", "println(g, g/4, g-f)", `s := "ab"`, `s += "cd"`, `println(s, s < "b")`, "s"},
+		"3 0.75 1.5\nabcd true\n=> \"abcd\" string\n",
+	},
+	{
+		[]string{"s := 0", "for i := 0; i < 10; i++ {\nif i%2 == 0 {\ncontinue\n}\nif i > 7 {\nbreak\n}\ns += i\n}", "s"},
+		"=> 16 int\n",
+	},
+	{
+		[]string{"x := 5", `if y := x * 2; y > 8 { println("big", y) } else { println("small", y) }`, "if x < 3 { println(1) } else if x < 6 { println(2) } else { println(3) }"},
+		"big 10\n2\n",
+	},
+	{
+		[]string{"mk := func(base int) func(int) int { return func(d int) int { base += d; return base } }", "add := mk(10)", "add(1)", "add(5)"},
+		"=> 11 int\n=> 16 int\n",
+	},
+	{
+		[]string{"m := make(map[string]int)", `m["a"] = 1`, `m["b"] += 2`, `v, ok := m["c"]`, `w, ok2 := m["a"]`, `println(m["a"], m["b"], v, ok, w, ok2)`},
+		"1 2 0 false 1 true\n",
+	},
+	{
+		[]string{"s := make([]int, 3)", "s[1] = 5", "s = append(s, 7, 8)", "println(s[1], s[4])", "t := append(make([]int, 0), s...)", "t[0] = 1", "s[0]", "t[0]"},
+		"5 8\n=> 0 int\n=> 1 int\n",
+	},
+	{
+		[]string{"ch := make(chan int, 1)", "ch <- 3", "select {\ncase v := <-ch:\nprintln(\"got\", v)\ndefault:\nprintln(\"none\")\n}", "select {\ncase v := <-ch:\nprintln(\"got\", v)\ndefault:\nprintln(\"none\")\n}"},
+		"got 3\nnone\n",
+	},
+	{
+		[]string{"x := 1", "p := &x", "*p = 9", "println(x)", "*p += 1", "x"},
+		"9\n=> 10 int\n",
+	},
+	{
+		[]string{"x := 3", `x := "str"`, "x", `y := x + "!"`, "y"},
+		"=> \"str\" string\n=> \"str!\" string\n",
+	},
+	{
+		[]string{"1 << 10", "2.5 * 2", `"a" + "b"`, "true && false", "'a'"},
+		"=> 1024 untyped int\n=> 5 untyped float\n=> \"ab\" untyped string\n=> false untyped bool\n=> 97 untyped rune\n",
+	},
+	{
+		[]string{"x := 10", "{\nx := 2\nprintln(x)\n}", "x", "{\ny := x\nx = y + 1\n}", "x"},
+		"2\n=> 10 int\n=> 11 int\n",
+	},
+	{
+		[]string{"i := interface{}(5)", "n, ok := i.(int)", "println(n, ok)", "s, ok := i.(string)", "println(s, ok)"},
+		"5 true\n false\n",
+	},
+	{
+		[]string{`b := []byte("hi")`, `b = append(b, " there"...)`, "string(b)", "float64(3) / 2", "int(2.0)"},
+		"=> \"hi there\" string\n=> 1.5 float64\n=> 2 int\n",
+	},
+	{
+		[]string{"fs := make([]func() int, 0)", "for i := 0; i < 3; i++ {\nj := i\nfs = append(fs, func() int { return j })\n}", "fs[0]() + fs[1]()*10 + fs[2]()*100"},
+		"=> 210 int\n",
+	},
+	{
+		[]string{"f := func(n int) int {\nt := 0\nfor i := 1; i <= n; i++ {\nt += i\n}\nreturn t\n}", "f(10)", "f(100)"},
+		"=> 55 int\n=> 5050 int\n",
+	},
+	{
+		[]string{"v := 7", "g := func() { v *= 2 }", "v := 1", "g()", "v"},
+		"=> 1 int\n",
+	},
+}
+
+func TestSessions(t *testing.T) {
+	for _, test := range sessionTests {
+		if got := session(test.inputs...); got != test.want {
+			t.Errorf("%q:\ngot:\n%s\nwant:\n%s", test.inputs, got, test.want)
+		}
+	}
+}
+
+// Code using what isn't implemented yet fails to compile, so none of an
+// input runs, rather than stopping the program when it gets to it.
+func TestNotImplemented(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`println("ran")` + "\n" + `s := "abc"` + "\n" + "s[0]", "input:3: String indexing not implemented yet"},
+		{`println("ran")` + "\n" + `f := func() int { return len("x") }`, "input:2: len function not implemented yet"},
+		{"f := func(s []int) {\nfor range s {\n}\n}", "input:2: Statement *ast.RangeStmt not implemented yet"},
+	}
+	for _, test := range tests {
+		got := session(test.src)
+		if want := "error: " + test.want + "\n"; got != want {
+			t.Errorf("%q: got %q, want %q", test.src, got, want)
+		}
+	}
+
+	// The session goes on as if the input hadn't been given
+	got := session("x := 1", "x := \"abc\"\nx[0]", "x")
+	if want := "error: input:3: String indexing not implemented yet\n=> 1 int\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestTopLevelReturnDefer(t *testing.T) {
+	tests := []struct {
+		inputs []string
+		want   string
+	}{
+		{[]string{"f := func() {}", "defer f()"}, "error: input:2: defer at top level not allowed\n"},
+		{[]string{`defer println("x")`, "1"}, "error: input:1: defer at top level not allowed\n=> 1 untyped int\n"},
+		{[]string{"x := 1", "if x > 0 {\nreturn\n}"}, "error: input:3: return at top level not allowed\n"},
+		{[]string{"for {\ndefer println()\n}"}, "error: input:2: defer at top level not allowed\n"},
+		{[]string{"f := func() (n int) {\ndefer func() { n++ }()\nreturn 1\n}", "f()"}, "=> 2 int\n"},
+	}
+	for _, test := range tests {
+		if got := session(test.inputs...); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.inputs, got, test.want)
+		}
+	}
+}
+
+// The context of an input with a time limit is canceled once the input and
+// the goroutines it started have finished, rather than at the deadline.
+func TestTimeoutCanceled(t *testing.T) {
+	i := New(Options{Limits: Limits{Timeout: time.Hour}}).(*interp)
+	if _, err := i.Eval("ch := make(chan int)\ngo func() { ch <- 1 }()"); err != nil {
+		t.Fatal(err)
+	}
+	i.threadsMu.Lock()
+	var ctx context.Context
+	for _, th := range i.goroutines {
+		ctx = th.ctx
+	}
+	i.threadsMu.Unlock()
+	if ctx == nil {
+		t.Fatal("no goroutine running")
+	}
+	if err := ctx.Err(); err != nil {
+		t.Errorf("with a goroutine running: context error %v, want none", err)
+	}
+
+	if _, err := i.Eval("<-ch"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Errorf("context not canceled once the goroutine finished")
+	}
+}
@@ -0,0 +1,170 @@
+package interp
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/exact"
+	"golang.org/x/tools/go/types"
+)
+
+// Untyped constants are kept as exact values, with the arbitrary precision the
+// type checker folded them with, until they are converted to a typed value.
+// The values of top-level expression statements that are untyped constants are
+// never converted, so they can be used as a precise calculator.
+
+// The number of significant digits ConstString prints of an untyped float
+// constant that has no exact decimal representation.
+const constDigits = 40
+
+// checkConstants returns an error for the first untyped constant in node that
+// is an operand of an expression that isn't constant, and that overflows the
+// default type it takes there, like the compiler does. The type checker leaves
+// such constants untyped in top-level expression statements, where the result
+// isn't used, as in (1<<100) >> n.
+func checkConstants(fset *token.FileSet, info *types.Info, node ast.Node) error {
+	var err error
+	ast.Inspect(node, func(n ast.Node) bool {
+		if err != nil {
+			return false
+		}
+		e, ok := n.(ast.Expr)
+		if !ok {
+			return true
+		}
+		tv, ok := info.Types[e]
+		if !ok {
+			return true
+		}
+		if tv.Value != nil {
+			// Operands of constant expressions are folded with them
+			return false
+		}
+		ast.Inspect(e, func(m ast.Node) bool {
+			if m == e {
+				return true
+			}
+			x, ok := m.(ast.Expr)
+			if !ok || err != nil {
+				return false
+			}
+			tv := info.Types[x]
+			if tv.Value != nil && !isTyped(tv.Type) {
+				if typ := typedBasic(tv.Type); overflows(tv.Value, typ) {
+					err = types.Error{
+						Fset: fset,
+						Pos:  x.Pos(),
+						Msg:  fmt.Sprintf("constant %s overflows %s", ConstString(tv.Value), typ),
+					}
+				}
+			}
+			// Only the direct operands are converted
+			return false
+		})
+		return err == nil
+	})
+	return err
+}
+
+// overflows reports whether the constant v can't be represented by a value of
+// the basic type typ.
+func overflows(v exact.Value, typ types.Type) bool {
+	switch kind := typ.Underlying().(*types.Basic).Kind(); kind {
+	case types.Int, types.Int8, types.Int16, types.Int32, types.Int64:
+		i, ok := exact.Int64Val(exact.ToInt(v))
+		if wrap := wrapInt(kind); ok && wrap != nil {
+			return wrap(i) != i
+		}
+		return !ok
+	case types.Uint, types.Uint8, types.Uint16, types.Uint32, types.Uint64, types.Uintptr:
+		u, ok := exact.Uint64Val(exact.ToInt(v))
+		if wrap := wrapUint(kind); ok && wrap != nil {
+			return wrap(u) != u
+		}
+		return !ok
+	case types.Float32:
+		f, _ := exact.Float32Val(v)
+		return math.IsInf(float64(f), 0)
+	case types.Float64:
+		f, _ := exact.Float64Val(v)
+		return math.IsInf(f, 0)
+	case types.Complex64:
+		return overflows(exact.Real(v), types.Typ[types.Float32]) || overflows(exact.Imag(v), types.Typ[types.Float32])
+	case types.Complex128:
+		return overflows(exact.Real(v), types.Typ[types.Float64]) || overflows(exact.Imag(v), types.Typ[types.Float64])
+	}
+	return false
+}
+
+// ConstString returns the value of an untyped constant as Go would print it,
+// but with all the precision of the constant. Integers are printed with all
+// their digits, as are floats with an exact decimal representation. Other
+// floats are rounded to 40 significant digits.
+func ConstString(v exact.Value) string {
+	switch v.Kind() {
+	case exact.Float:
+		return floatString(v)
+	case exact.Complex:
+		return "(" + floatString(exact.Real(v)) + signed(floatString(exact.Imag(v))) + "i)"
+	case exact.String:
+		return strconv.Quote(exact.StringVal(v))
+	}
+	return v.String()
+}
+
+// floatString formats the value of the float or integer constant v for
+// ConstString.
+func floatString(v exact.Value) string {
+	if v.Kind() == exact.Int {
+		return v.String()
+	}
+	num, _ := new(big.Int).SetString(exact.Num(v).String(), 10)
+	denom, _ := new(big.Int).SetString(exact.Denom(v).String(), 10)
+	r := new(big.Rat).SetFrac(num, denom)
+	if r.IsInt() {
+		return r.Num().String()
+	}
+
+	// The decimal representation is exact if the denominator has no prime
+	// factors other than 2 and 5. There are then as many digits after the
+	// decimal point as the larger of the powers of 2 and 5.
+	d := new(big.Int).Set(r.Denom())
+	twos, fives := 0, 0
+	two, five, rem := big.NewInt(2), big.NewInt(5), new(big.Int)
+	for q := new(big.Int); ; twos++ {
+		if q.QuoRem(d, two, rem); rem.Sign() != 0 {
+			break
+		}
+		d.Set(q)
+	}
+	for q := new(big.Int); ; fives++ {
+		if q.QuoRem(d, five, rem); rem.Sign() != 0 {
+			break
+		}
+		d.Set(q)
+	}
+	if d.Cmp(big.NewInt(1)) == 0 {
+		digits := twos
+		if fives > digits {
+			digits = fives
+		}
+		if digits <= constDigits {
+			return r.FloatString(digits)
+		}
+	}
+
+	return new(big.Float).SetPrec(256).SetRat(r).Text('g', constDigits)
+}
+
+// signed returns the formatted number s with a leading sign.
+func signed(s string) string {
+	if strings.HasPrefix(s, "-") {
+		return s
+	}
+	return "+" + s
+}
@@ -0,0 +1,43 @@
+package interp
+
+import "testing"
+
+// Untyped constants are exact, and are printed with up to 40 significant digits.
+// Using one where it overflows its type is an error.
+func TestUntypedConstants(t *testing.T) {
+	got := session(
+		"1<<100 >> 90",
+		"1<<100",
+		"1.0 / 3",
+		"2.5e400 / 1e399",
+		"0.1 + 0.2",
+		"n := uint(3)",
+		"(1<<100) >> n",
+	)
+	want := "=> 1024 untyped int\n" +
+		"=> 1267650600228229401496703205376 untyped int\n" +
+		"=> 0.3333333333333333333333333333333333333333 untyped float\n" +
+		"=> 25 untyped float\n" +
+		"=> 0.3 untyped float\n" +
+		"error: input:7: constant 1267650600228229401496703205376 overflows int\n"
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// The type checker rejects these, with messages that depend on its version
+	i := New(Options{})
+	for _, src := range []string{"x := 1<<100", "println(1<<100)", "f := float32(1e39)", "u := uint8(256)"} {
+		if _, err := i.Eval(src); err == nil {
+			t.Errorf("%q: got no error", src)
+		}
+	}
+
+	ev := NewEvaluator(Options{})
+	if v, err := ev.Eval("1<<100 >> 90", nil); err != nil || v != 1024 {
+		t.Errorf("evaluating 1<<100 >> 90: got %v, %v, want 1024", v, err)
+	}
+	want = "expr:1:1: constant 1267650600228229401496703205376 overflows int"
+	if _, err := ev.Eval("1<<100", nil); err == nil || err.Error() != want {
+		t.Errorf("evaluating 1<<100: got error %v, want %q", err, want)
+	}
+}
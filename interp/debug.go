@@ -0,0 +1,239 @@
+package interp
+
+import (
+	"go/ast"
+	"go/token"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/tools/go/types"
+)
+
+// A StepMode tells the interpreter how to go on after code stops.
+type StepMode int
+
+const (
+	Continue StepMode = iota // run until the next breakpoint
+	Step                     // stop at the next line run, in whatever function
+	Next                     // stop at the next line of the same function or a caller
+	Finish                   // stop at the next line of a caller
+)
+
+// A Stop is where code stopped at a breakpoint or after stepping. It is only
+// valid until the function given to OnStop returns.
+type Stop struct {
+	Pos    token.Position
+	Source string  // the line of input stopped at
+	Stack  []Frame // innermost call first
+
+	interp *interp
+	env    *environ
+}
+
+// A Variable is a variable in scope where code stopped.
+type Variable struct {
+	Name  string
+	Value reflect.Value
+	Type  types.Type
+}
+
+// Code only stops on the thread running the input, including in interpreted
+// functions called by compiled code on that thread, since that's where the
+// prompt is waiting. Goroutines started by go statements never stop.
+type debugger struct {
+	active int32 // whether enterStmt needs to call stmt; accessed atomically
+
+	mu          sync.Mutex
+	onStop      func(*Stop) StepMode
+	breakpoints map[int]bool
+	mode        StepMode
+	depth       int // the depth of the stack where we last stopped
+}
+
+// update sets d.active from the rest of d, which must be locked.
+func (d *debugger) update() {
+	var active int32
+	if d.onStop != nil && (len(d.breakpoints) > 0 || d.mode != Continue) {
+		active = 1
+	}
+	atomic.StoreInt32(&d.active, active)
+}
+
+// reset forgets any stepping in progress, for a new input.
+func (d *debugger) reset() {
+	d.mu.Lock()
+	d.mode = Continue
+	d.update()
+	d.mu.Unlock()
+}
+
+func (i *interp) OnStop(f func(*Stop) StepMode) {
+	d := &i.debugger
+	d.mu.Lock()
+	d.onStop = f
+	d.update()
+	d.mu.Unlock()
+}
+
+func (i *interp) SetBreakpoint(line int) {
+	d := &i.debugger
+	d.mu.Lock()
+	d.breakpoints[line] = true
+	d.update()
+	d.mu.Unlock()
+}
+
+func (i *interp) ClearBreakpoint(line int) {
+	d := &i.debugger
+	d.mu.Lock()
+	delete(d.breakpoints, line)
+	d.update()
+	d.mu.Unlock()
+}
+
+func (i *interp) Breakpoints() []int {
+	d := &i.debugger
+	d.mu.Lock()
+	lines := make([]int, 0, len(d.breakpoints))
+	for line := range d.breakpoints {
+		lines = append(lines, line)
+	}
+	d.mu.Unlock()
+	sort.Ints(lines)
+	return lines
+}
+
+// stmt is called by enterStmt before running stmt in env while the debugger is
+// active. It stops if stmt starts a line at a breakpoint, or where stepping
+// says to stop, and waits for the OnStop function to say how to go on.
+func (i *interp) stmt(env *environ, stmt ast.Stmt) {
+	t := env.thread
+	if !t.input {
+		return
+	}
+	if _, ok := stmt.(*ast.BlockStmt); ok {
+		// Stop at the statements in it instead
+		return
+	}
+
+	// Stop at most once per line of a frame, however many statements it has
+	f := t.frames[len(t.frames)-1]
+	line := i.fset.Position(stmt.Pos()).Line
+	if line == f.line {
+		return
+	}
+	f.line = line
+
+	d := &i.debugger
+	depth := len(t.frames)
+	d.mu.Lock()
+	stop := d.breakpoints[line]
+	switch d.mode {
+	case Step:
+		stop = true
+	case Next:
+		stop = stop || depth <= d.depth
+	case Finish:
+		stop = stop || depth < d.depth
+	}
+	onStop := d.onStop
+	d.mu.Unlock()
+	if !stop || onStop == nil {
+		return
+	}
+
+	mode := onStop(&Stop{
+		Pos:    i.fset.Position(stmt.Pos()),
+		Source: i.sourceLine(line),
+		Stack:  t.stack(i.fset),
+		interp: i,
+		env:    env,
+	})
+
+	d.mu.Lock()
+	d.mode = mode
+	d.depth = depth
+	d.update()
+	d.mu.Unlock()
+}
+
+// sourceLine returns the line of input numbered line.
+func (i *interp) sourceLine(line int) string {
+	lines := strings.Split(strings.Join(i.stmtLists, "\n"), "\n")
+	if len(i.stmtLists) == 0 {
+		lines = nil
+	}
+	lines = append(lines, strings.Split(i.src, "\n")...)
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	return lines[line-1]
+}
+
+// Locals returns the variables in scope where code stopped, innermost scope
+// first and by name within a scope. Variables shadowed by others are left out.
+func (s *Stop) Locals() []Variable {
+	var vars []Variable
+	seen := map[string]bool{}
+	for env := s.env; env != nil && env != s.interp.hostEnv; env = env.parent {
+		env.mu.RLock()
+		objs := map[string]Object{}
+		for name, obj := range env.objs {
+			objs[name] = obj
+		}
+		env.mu.RUnlock()
+		for slot, name := range env.varNames {
+			if env.vars[slot].Value != nil {
+				// Declared by now
+				objs[name] = env.vars[slot]
+			}
+		}
+
+		names := make([]string, 0, len(objs))
+		for name := range objs {
+			if !seen[name] {
+				names = append(names, name)
+				seen[name] = true
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			obj := objs[name]
+			if v, ok := obj.Value.(reflect.Value); ok && !obj.Sim {
+				// The caller may set it
+				obj.escape()
+				vars = append(vars, Variable{
+					Name:  name,
+					Value: v,
+					Type:  obj.Typ,
+				})
+			}
+		}
+	}
+	return vars
+}
+
+// Eval evaluates the expression src where code stopped, with the variables
+// returned by Locals and the values bound with Define.
+func (s *Stop) Eval(src string) (interface{}, error) {
+	i := s.interp
+	vars := map[string]interface{}{}
+	for _, name := range i.hostEnv.getNames() {
+		if obj, ok := i.hostEnv.lookup(name); ok {
+			vars[name] = obj.Value.(reflect.Value).Interface()
+		}
+	}
+	for _, v := range s.Locals() {
+		if v.Value.CanInterface() {
+			vars[v.Name] = v.Value.Interface()
+		}
+	}
+
+	if i.evaluator == nil {
+		i.evaluator = newEvaluator(i)
+	}
+	return i.evaluator.Eval(src, vars)
+}
@@ -0,0 +1,61 @@
+package interp
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// describeStop describes where code stopped, with its locals.
+func describeStop(s *Stop) string {
+	var locals []string
+	for _, v := range s.Locals() {
+		locals = append(locals, fmt.Sprintf("%s=%v", v.Name, v.Value.Interface()))
+	}
+	return fmt.Sprintf("%d %q depth %d [%s]", s.Pos.Line, s.Source, len(s.Stack), strings.Join(locals, " "))
+}
+
+func TestBreakpointAndStep(t *testing.T) {
+	i := New(Options{})
+	if _, err := i.Eval("f := func(n int) int {\nm := n * 2\nreturn m + 1\n}"); err != nil {
+		t.Fatal(err)
+	}
+	var stops []string
+	i.OnStop(func(s *Stop) StepMode {
+		stops = append(stops, describeStop(s))
+		if len(stops) == 1 {
+			return Step
+		}
+		return Continue
+	})
+	i.SetBreakpoint(2)
+	if got := i.Breakpoints(); !reflect.DeepEqual(got, []int{2}) {
+		t.Errorf("Breakpoints() = %v, want [2]", got)
+	}
+
+	res, err := i.Eval("x := f(3)\nx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := formatObj(res[0]); got != "7" {
+		t.Errorf("x = %s, want 7", got)
+	}
+	want := []string{
+		`2 "m := n * 2" depth 2 [n=3]`,
+		`3 "return m + 1" depth 2 [m=6 n=3]`,
+	}
+	if !reflect.DeepEqual(stops, want) {
+		t.Errorf("stopped at:\n%s\nwant:\n%s", strings.Join(stops, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Without the breakpoint, code runs without stopping
+	i.ClearBreakpoint(2)
+	stops = nil
+	if _, err := i.Eval("f(4)"); err != nil {
+		t.Fatal(err)
+	}
+	if len(stops) != 0 {
+		t.Errorf("stopped without a breakpoint at %q", stops)
+	}
+}
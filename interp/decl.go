@@ -2,48 +2,58 @@ package interp
 
 import (
 	"go/ast"
-	"log"
 	"reflect"
+
+	"golang.org/x/tools/go/types"
 )
 
 func getSettableZeroVal(typ reflect.Type) reflect.Value {
-	if typ.Kind() != reflect.Array {
-		return reflect.New(typ).Elem()
-	}
-	log.Fatal("getSettableZeroVal: array types not implemented yet")
-	return reflect.Value{}
+	return reflect.New(typ).Elem()
 }
 
-func (env *environ) getDeclVars(exprs []ast.Expr) []Object {
-	lhs := make([]Object, len(exprs))
-	for i, expr := range exprs {
-		ident := expr.(*ast.Ident)
-		identDef := env.info.Defs[ident]
-		if identDef == nil || identDef.Pos() != ident.Pos() {
-			// Redeclaration: variable already exists in current scope. Look up the object.
-			obj, _ := env.lookup(ident.Name)
-			lhs[i] = obj
-		} else {
-			// New variable declaration. Create new variable with the right type.
-			typ := env.info.TypeOf(expr)
-			rtyp, sim := getReflectType(env.interp.typeMap, typ)
-			if rtyp == nil {
-				log.Fatalf("couldn't get reflect.Type corresponding to %q", typ)
-			}
-			val := getSettableZeroVal(rtyp)
-			obj := Object{
-				Value: val,
-				Typ:   typ,
-				Sim:   sim,
-			}
-			// Add the name we're declaring to env.names if it's a new name
-			if _, ok := env.lookup(ident.Name); !ok {
-				env.names = append(env.names, ident.Name)
+// declare compiles the declaration of the variable named by id in a short
+// variable declaration. The code returns the variable, after adding it to the
+// environment, or the existing variable if id redeclares one. There is no code
+// for the blank identifier, since nothing is kept.
+func (c *compiler) declare(id *ast.Ident) evalFunc {
+	if id.Name == "_" {
+		return nil
+	}
+	def := c.info.Defs[id]
+	if def == nil || def.Pos() != id.Pos() {
+		// Redeclaration: the variable already exists in the current scope
+		v, _ := c.info.Uses[id].(*types.Var)
+		return c.variable(v, id.Name)
+	}
+
+	typ := c.info.TypeOf(id)
+	rtyp, sim := getReflectType(c.interp.typeMap, typ)
+	if rtyp == nil {
+		return c.notImplemented(id, "Variables of type %s not implemented yet", typ)
+	}
+	name := id.Name
+	newVar := func() Object {
+		return Object{
+			Value: getSettableZeroVal(rtyp),
+			Typ:   typ,
+			Sim:   sim,
+			fn:    newClosureRef(rtyp, nil),
+		}
+	}
+	if c.block != nil {
+		if slot, ok := c.block.slots[def.(*types.Var)]; ok {
+			return func(env *environ) Object {
+				obj := newVar()
+				env.vars[slot] = obj
+				return obj
 			}
-			// Add the object to env.objs
-			env.objs[ident.Name] = obj
-			lhs[i] = obj
 		}
 	}
-	return lhs
+	// Declared at top level. Add the object to env, and its name to env.names
+	// if it's a new name.
+	return func(env *environ) Object {
+		obj := newVar()
+		env.define(name, obj)
+		return obj
+	}
 }
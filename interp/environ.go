@@ -1,28 +1,50 @@
 package interp
 
 import (
-	"reflect"
 	"strings"
+	"sync"
 
 	"golang.org/x/tools/go/types"
 )
 
+// An environ holds the variables of a scope while code runs in it.
+//
+// Each goroutine running interpreted code has its own chain of environments up
+// to the environment of the function it's running, since every call and every
+// block with variables gets a new one. Only the environments of closures, the
+// top-level environment and the host environment are shared between goroutines,
+// so objs and names are guarded by mu. The variables themselves are shared by
+// reference, as in compiled code, and synchronizing access to them is up to the
+// code using them.
+//
+// Variables declared at top level and bound with Define are kept in objs, by
+// name. Compiled code keeps the variables of blocks in vars instead, in the
+// slots the compiler gave them, and their names in varNames. Only the goroutine
+// running a block declares its variables, and closures capture theirs before
+// they're shared, so vars isn't guarded by mu.
 type environ struct {
 	interp *interp
-	info   *types.Info
+	thread *thread
 	scope  *types.Scope
 	parent *environ
-	objs   map[string]Object
-	names  []string
+
+	mu    sync.RWMutex
+	objs  map[string]Object
+	names []string
+
+	vars     []Object
+	varNames []string
 }
 
 func (env *environ) lookup(s string) (Object, bool) {
+	env.mu.RLock()
 	v, ok := env.objs[s]
+	env.mu.RUnlock()
 	return v, ok
 }
 
 func (env *environ) lookupParent(s string) (Object, bool) {
-	if v, ok := env.objs[s]; ok {
+	if v, ok := env.lookup(s); ok {
 		return v, true
 	}
 	if env.parent == nil {
@@ -32,33 +54,28 @@ func (env *environ) lookupParent(s string) (Object, bool) {
 	return v, ok
 }
 
-func (env *environ) addVar(varInfo *types.Var, typ reflect.Type, obj Object) {
-	varName := varInfo.Name()
-	varType := varInfo.Type()
-	sim := false
-	if typ == nil {
-		// The caller did not provide a reflect.Type to use as the type
-		typ, sim = getReflectType(env.interp.typeMap, varType)
-	}
-	// Create a variable of the right type with the zero value, then set its value from obj
-	newVal := reflect.New(typ).Elem()
-	rval, ok := obj.Value.(reflect.Value)
-	if !ok {
-		// Must be untyped nil. Use zero value of type instead
-		rval = reflect.Zero(typ)
+// define binds name to obj in env, adding name to env.names if it's new.
+func (env *environ) define(name string, obj Object) {
+	env.mu.Lock()
+	if _, ok := env.objs[name]; !ok {
+		env.names = append(env.names, name)
 	}
-	newVal.Set(rval)
-	newObj := Object{
-		Value: newVal,
-		Typ:   varType,
-		Sim:   sim,
-	}
-	env.objs[varName] = newObj
+	env.objs[name] = obj
+	env.mu.Unlock()
+}
+
+// getNames returns a copy of env.names.
+func (env *environ) getNames() []string {
+	env.mu.RLock()
+	defer env.mu.RUnlock()
+	names := make([]string, len(env.names))
+	copy(names, env.names)
+	return names
 }
 
 func (env *environ) dumpScope() (string, int) {
 	lines := []string{}
-	for _, name := range env.names {
+	for _, name := range env.getNames() {
 		_, t := env.scope.LookupParent(name)
 		switch t.(type) {
 		case *types.Var:
@@ -0,0 +1,69 @@
+package interp
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// Goroutines started by go statements look up a value bound with Define while
+// later inputs declare variables and more values are bound, and the host lists
+// and looks up the variables. Run with -race to check that the shared
+// environments are guarded.
+func TestEnvironConcurrent(t *testing.T) {
+	i := New(Options{})
+	if err := i.Define("a", 2); err != nil {
+		t.Fatal(err)
+	}
+	for _, src := range []string{
+		"tick, done := make(chan int), make(chan int)",
+		"for j := 0; j < 4; j++ {\ngo func() {\ns := 0\nfor k := 0; k < 20; k++ {\ns += a + <-tick\n}\ndone <- s\n}()\n}",
+	} {
+		if _, err := i.Eval(src); err != nil {
+			t.Fatalf("%q: %v", src, err)
+		}
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			for _, name := range i.Names() {
+				i.Lookup(name)
+			}
+		}
+	}()
+	for j := 0; j < 20; j++ {
+		if err := i.Define(fmt.Sprintf("b%d", j), j); err != nil {
+			t.Fatal(err)
+		}
+		src := fmt.Sprintf("x%d := a + b%d", j, j)
+		if _, err := i.Eval(src); err != nil {
+			t.Fatalf("%q: %v", src, err)
+		}
+		// Let each goroutine look up a once more, while we go on
+		if _, err := i.Eval("for k := 0; k < 4; k++ {\ntick <- 1\n}"); err != nil {
+			t.Fatal(err)
+		}
+		results, err := i.Eval(fmt.Sprintf("x%d", j))
+		if err != nil || len(results) != 1 || formatObj(results[0]) != fmt.Sprint(2+j) {
+			t.Errorf("x%d = %v, %v, want %d", j, results, err, 2+j)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	for j := 0; j < 4; j++ {
+		results, err := i.Eval("<-done")
+		if err != nil || len(results) != 1 || formatObj(results[0]) != "60" {
+			t.Errorf("<-done = %v, %v, want 60", results, err)
+		}
+	}
+}
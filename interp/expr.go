@@ -1,6 +1,7 @@
 package interp
 
 import (
+	"errors"
 	"fmt"
 	"go/ast"
 	"go/token"
@@ -12,434 +13,535 @@ import (
 	"golang.org/x/tools/go/types/typeutil"
 )
 
-func (env *environ) evalExprs(exprs []ast.Expr) []Object {
-	var objs []Object
+// exprs compiles a list of expressions to all of their values: either a single,
+// potentially multi-valued expression, or several single-valued ones.
+func (c *compiler) exprs(exprs []ast.Expr) multiFunc {
 	if len(exprs) == 1 {
 		// Single argument expression, potentially multi-valued
-		objs = env.Eval(exprs[0])
-	} else {
-		// Multiple argument expressions, each single-valued
-		objs = make([]Object, len(exprs))
-		for i, expr := range exprs {
-			objs[i] = env.Eval(expr)[0]
+		return c.multi(exprs[0])
+	}
+	// Multiple argument expressions, each single-valued
+	evals := make([]evalFunc, len(exprs))
+	for j, expr := range exprs {
+		evals[j] = c.expr(expr)
+	}
+	return func(env *environ) []Object {
+		objs := make([]Object, len(evals))
+		for j, eval := range evals {
+			objs[j] = eval(env)
 		}
+		return objs
 	}
-	return objs
 }
 
-func isMapIndexExpr(env *environ, expr ast.Expr) bool {
+func (c *compiler) isMapIndexExpr(expr ast.Expr) bool {
 	if e, isIndexExpr := expr.(*ast.IndexExpr); isIndexExpr {
-		if _, isMap := env.info.TypeOf(e.X).Underlying().(*types.Map); isMap {
+		if _, isMap := c.info.TypeOf(e.X).Underlying().(*types.Map); isMap {
 			return true
 		}
 	}
 	return false
 }
 
-func (env *environ) Eval(expr ast.Expr) []Object {
-	// Check for constant
-	tv := env.info.Types[expr]
-	if tv.Type == types.Typ[types.UntypedNil] {
-		obj := Object{
-			Value: tv.Value,
-			Typ:   tv.Type,
+// multi compiles an expression to all of its values. Calls may have any number
+// of results, and the "comma, ok" forms of receive operations, type assertions
+// and map index expressions have two.
+func (c *compiler) multi(expr ast.Expr) multiFunc {
+	switch e := expr.(type) {
+	case *ast.ParenExpr:
+		x := c.multi(e.X)
+		return func(env *environ) []Object {
+			env.thread.step()
+			return x(env)
+		}
+	case *ast.CallExpr:
+		switch c.callKind(e) {
+		case builtinKind:
+			return c.builtinCall(e, token.ILLEGAL)
+		case callKind:
+			return c.funcCall(e, token.ILLEGAL)
+		}
+	}
+
+	if tup, ok := c.info.TypeOf(expr).(*types.Tuple); ok {
+		var commaOk func(env *environ) (Object, bool)
+		var okTyp types.Type = types.Typ[types.Bool]
+		switch e := expr.(type) {
+		case *ast.UnaryExpr:
+			commaOk = c.recv(e, tup.At(0).Type())
+		case *ast.TypeAssertExpr:
+			commaOk = c.typeAssert(e, true)
+		case *ast.IndexExpr:
+			commaOk = c.mapIndex(e, tup.At(0).Type())
+			okTyp = tup.At(1).Type()
 		}
-		return []Object{obj}
-	}
-	if tv.Value != nil {
-		// It's a constant. Just return the exact.Value
-		// Note: We actually want to convert it to a reflect.Value if it's a typed constant, since
-		//   we know we can represent it and for convenience. But we're not doing that yet.
-		if isTyped(tv.Type) {
-			// It's a typed constant. Convert to a reflect.Value.
-			obj := Object{
-				Value: convertExactToReflect(env.interp.typeMap, tv),
-				Typ:   tv.Type,
+		if commaOk != nil {
+			return func(env *environ) []Object {
+				obj, ok := commaOk(env)
+				okObj := Object{
+					Value: reflect.ValueOf(ok),
+					Typ:   okTyp,
+					Sim:   false,
+				}
+				return []Object{obj, okObj}
 			}
-			return []Object{obj}
 		}
-		// It's an untyped constant. Just return the exact.Value as the value.
-		obj := Object{
-			Value: tv.Value,
-			Typ:   tv.Type,
+	}
+
+	eval := c.expr(expr)
+	return func(env *environ) []Object {
+		return []Object{eval(env)}
+	}
+}
+
+// expr compiles a single-valued expression.
+func (c *compiler) expr(expr ast.Expr) evalFunc {
+	// Check for constant
+	tv := c.info.Types[expr]
+	if tv.Type == types.Typ[types.UntypedNil] || tv.Value != nil {
+		obj := c.constant(tv)
+		return func(env *environ) Object {
+			env.thread.step()
+			return obj
 		}
-		return []Object{obj}
 	}
 	// Not a constant expression, so we have to evaluate it ourselves
 	typ := tv.Type
 	switch e := expr.(type) {
 	case *ast.FuncLit:
-		// TODO: Simulated functions, to interact with each other correctly inside the
-		// interpreter, should not be "func([]reflect.Value)[]reflect.Value" but instead
-		// "func([]Object)[]Object". This is because a simulated function's arguments may
-		// themselves be simulated functions! To handle this case correctly, the implementation
-		// of the function's body needs to know which arguments are simulated, which means
-		// the arguments must be of type Object rather than reflect.Value.
-		//
-		// Unsimulated functions do not have this problem as long as we guarantee that an
-		// unsimulated function type's parameter types will also be unsimulated. We will make
-		// sure that this guarantee holds.
-
-		// TODO: avoid simulating function types when possible
-		rtyp, sim := getReflectType(env.interp.typeMap, typ)
-		if sim {
-			// We must simulate the function type we want to create
-			f := createSimulatedFunc(env, e)
-			rf := reflect.ValueOf(f)
-			obj := Object{
-				Value: rf,
-				Typ:   typ,
-				Sim:   true,
-			}
-			return []Object{obj}
-		}
-		// We can actually create a function of the right type
-		f := createUnsimulatedFunc(env, e, rtyp)
-		obj := Object{
-			Value: f,
-			Typ:   typ,
-		}
-		return []Object{obj}
+		return c.funcLit(e)
 
 	case *ast.StarExpr:
-		// Because we have a StarExpr at this point in Eval, we know
+		// Because we have a StarExpr at this point, we know
 		// it is a unary "*" expression rather than a pointer type
-		xObj := env.Eval(e.X)[0]
-		xVal := xObj.Value.(reflect.Value)
-		newVal := xVal.Elem()
-		if !newVal.IsValid() {
-			// Nil pointer dereference!
-			panic("goconsole: Nil pointer dereference")
-		}
-		obj := Object{
-			Value: newVal,
-			Typ:   typ,
+		x := c.expr(e.X)
+		return func(env *environ) Object {
+			env.thread.step()
+			xVal := x(env).Value.(reflect.Value)
+			newVal := xVal.Elem()
+			if !newVal.IsValid() {
+				// Nil pointer dereference!
+				panic("goconsole: Nil pointer dereference")
+			}
+			return Object{
+				Value: newVal,
+				Typ:   typ,
+			}
 		}
-		return []Object{obj}
 	case *ast.UnaryExpr:
 		// TODO: implement unary expressions
 		switch e.Op {
 		case token.AND:
-			xObj := env.Eval(e.X)[0]
-			xVal := xObj.Value.(reflect.Value)
-			newVal := xVal.Addr()
-			obj := Object{
-				Value: newVal,
-				Typ:   typ,
+			x := c.expr(e.X)
+			return func(env *environ) Object {
+				env.thread.step()
+				xObj := x(env)
+				xObj.escape()
+				xVal := xObj.Value.(reflect.Value)
+				return Object{
+					Value: xVal.Addr(),
+					Typ:   typ,
+				}
 			}
-			return []Object{obj}
 		case token.ARROW:
-			xObj := env.Eval(e.X)[0]
-			xVal := xObj.Value.(reflect.Value)
-			var valTyp types.Type
-			commaOk := false
-			switch tup := typ.(type) {
-			case *types.Tuple:
-				valTyp = tup.At(0).Type()
-				commaOk = true
-			default:
-				valTyp = typ
+			recv := c.recv(e, typ)
+			return func(env *environ) Object {
+				obj, _ := recv(env)
+				return obj
 			}
-			newVal, ok := xVal.Recv()
-			_, sim := getReflectType(env.interp.typeMap, typ)
-			valObj := Object{
-				Value: newVal,
-				Typ:   valTyp,
-				Sim:   sim,
+		case token.NOT:
+			return c.boxBool(c.boolExpr(e), typ)
+		default:
+			return c.notImplemented(e, "Unary operator %v not implemented yet", e.Op)
+		}
+
+	case *ast.TypeAssertExpr:
+		assert := c.typeAssert(e, false)
+		return func(env *environ) Object {
+			obj, _ := assert(env)
+			return obj
+		}
+
+	case *ast.BinaryExpr:
+		return c.binary(e)
+	case *ast.Ident:
+		v, _ := c.info.Uses[e].(*types.Var)
+		get := c.variable(v, e.Name)
+		return func(env *environ) Object {
+			env.thread.step()
+			return get(env)
+		}
+	case *ast.ParenExpr:
+		x := c.expr(e.X)
+		return func(env *environ) Object {
+			env.thread.step()
+			return x(env)
+		}
+	case *ast.SelectorExpr:
+		// TODO: implement selector expressions!
+		sel, ok := c.info.Selections[e]
+		if !ok {
+			// Then this selector expression denotes a package object
+			obj := c.info.Uses[e.Sel]
+			p := obj.Pkg().Path()
+			if !c.interp.policy.allows(p, obj.Name()) {
+				// Caught by checkPolicy, unless the code got past the type checker some other way
+				err := errors.New(notAllowed(obj))
+				return func(env *environ) Object {
+					panic(unwind{err})
+				}
 			}
-			if commaOk {
-				okObj := Object{
-					Value: reflect.ValueOf(ok),
-					Typ:   types.Typ[types.Bool],
-					Sim:   false,
+			v, ok := c.interp.pkgs[p].Lookup(obj.Name())
+			if !ok {
+				return c.notImplemented(e, "Package object %s not found", obj.Name())
+			}
+			return func(env *environ) Object {
+				env.thread.step()
+				return v
+			}
+		}
+		switch sel.Kind() {
+		case types.FieldVal:
+			x := c.expr(e.X)
+			indirect := sel.Indirect()
+			index := sel.Index()
+			fieldTyp := sel.Type()
+			return func(env *environ) Object {
+				env.thread.step()
+				v := x(env).Value.(reflect.Value)
+				if indirect {
+					v = v.Elem()
+				}
+				return Object{
+					Value: v.FieldByIndex(index),
+					Typ:   fieldTyp,
 				}
-				return []Object{valObj, okObj}
 			}
-			return []Object{valObj}
+		case types.MethodVal:
+			return c.notImplemented(e, "Method values not implemented yet: %s", sel)
+		case types.MethodExpr:
+			return c.notImplemented(e, "Method expressions not implemented yet: %s", sel)
+		}
+	case *ast.CallExpr:
+		if c.callKind(e) == conversionKind {
+			return c.conversion(e)
+		}
+		call := c.multi(e)
+		return func(env *environ) Object {
+			return call(env)[0]
+		}
+	case *ast.IndexExpr:
+		// Figure out which type of object we're indexing
+		switch collTyp := c.info.TypeOf(e.X).Underlying().(type) {
+		case *types.Array:
+			return c.arrayIndex(e, typ, false)
+		case *types.Map:
+			index := c.mapIndex(e, typ)
+			return func(env *environ) Object {
+				obj, _ := index(env)
+				return obj
+			}
+		case *types.Slice:
+			return c.sliceIndex(e, typ)
+		case *types.Basic:
+			return c.notImplemented(e, "String indexing not implemented yet")
+		case *types.Pointer:
+			// Must be a pointer to an array
+			return c.arrayIndex(e, typ, true)
 		default:
-			log.Fatalf("Unary operator %q not implemented", e.Op)
+			return c.notImplemented(e, "Index expression on %s not implemented yet", collTyp)
 		}
+	}
+	return c.notImplemented(expr, "Expression %T not implemented yet", expr)
+}
 
-	case *ast.TypeAssertExpr:
-		// type assertion x.(T)
-
-		// if T is interface type:
-		//    * assert that x's dynamic type implements T
-		//    * if so, value of expr is T(val in x) or [T(val in x), true]
-		//    * otherwise, runtime panic or [ zero val of T, false ]
+// constant returns the value of a constant expression, or of untyped nil.
+func (c *compiler) constant(tv types.TypeAndValue) Object {
+	if tv.Value != nil && isTyped(tv.Type) {
+		// It's a typed constant. Convert to a reflect.Value.
+		return Object{
+			Value: convertExactToReflect(c.interp.typeMap, tv),
+			Typ:   tv.Type,
+		}
+	}
+	// It's an untyped constant, whose value is the exact.Value, or untyped nil
+	return Object{
+		Value: tv.Value,
+		Typ:   tv.Type,
+	}
+}
 
-		// if T is of non-interface type:
-		//    * assert that x's dynamic type is identical to T
-		//    * if so, value of expr is (val in x) or [ (val in x), true]
-		//    * otherwise, runtime panic or [ zero val of T, false ]
+// recv compiles a receive operation, whose value has type valTyp. The code
+// also reports whether the value was sent rather than the zero value of a
+// closed channel.
+func (c *compiler) recv(e *ast.UnaryExpr, valTyp types.Type) func(env *environ) (Object, bool) {
+	x := c.expr(e.X)
+	_, sim := getReflectType(c.interp.typeMap, c.info.TypeOf(e))
+	return func(env *environ) (Object, bool) {
+		env.thread.step()
+		xVal := x(env).Value.(reflect.Value)
+		newVal, ok := env.thread.recv(xVal)
+		return Object{
+			Value: newVal,
+			Typ:   valTyp,
+			Sim:   sim,
+		}, ok
+	}
+}
 
-		// types T and V are identical if and only if all of the following are true:
-		//    * values of T are assignable to V,
-		//    * both or neither of T and V are named types (that is, isNamed(T) == isNamed(V)),
-		//    * (T is not a channel type) OR (dir of T == dir of V)
+// typeAssert compiles a type assertion x.(T). Unless commaOk is set, the code
+// panics if the assertion fails.
+func (c *compiler) typeAssert(e *ast.TypeAssertExpr, commaOk bool) func(env *environ) (Object, bool) {
+	// if T is interface type:
+	//    * assert that x's dynamic type implements T
+	//    * if so, value of expr is T(val in x) or [T(val in x), true]
+	//    * otherwise, runtime panic or [ zero val of T, false ]
+
+	// if T is of non-interface type:
+	//    * assert that x's dynamic type is identical to T
+	//    * if so, value of expr is (val in x) or [ (val in x), true]
+	//    * otherwise, runtime panic or [ zero val of T, false ]
+
+	// types T and V are identical if and only if all of the following are true:
+	//    * values of T are assignable to V,
+	//    * both or neither of T and V are named types (that is, isNamed(T) == isNamed(V)),
+	//    * (T is not a channel type) OR (dir of T == dir of V)
+
+	typ := c.info.TypeOf(e)
+	toTyp := c.info.TypeOf(e.Type)
+	toRtyp, sim := getReflectType(c.interp.typeMap, toTyp)
+	if toRtyp == nil {
+		c.errorf(e.Type.Pos(), "Type assertion to %s not implemented yet", toTyp)
+		return nil
+	}
+	x := c.expr(e.X)
 
-		eTyp := typ
+	var implements bool
+	switch typ.Underlying().(type) {
+	case *types.Interface:
+		implements = true
+	}
 
-		// If the expression has tuple type, then it's a "comma, ok" type assertion
-		_, commaOk := eTyp.(*types.Tuple)
+	isNamed := func(t reflect.Type) bool {
+		return len(t.Name()) > 0
+	}
 
-		toTyp := env.info.TypeOf(e.Type)
-		toRtyp, sim := getReflectType(env.interp.typeMap, toTyp)
-		if toRtyp == nil {
-			log.Fatalf("Couldn't get reflect type: %v", toTyp)
+	areIdentical := func(t1, t2 reflect.Type) bool {
+		if !t1.AssignableTo(t2) {
+			return false
 		}
+		if isNamed(t1) != isNamed(t2) {
+			return false
+		}
+		if t1.Kind() == reflect.Chan && t1.ChanDir() != t2.ChanDir() {
+			return false
+		}
+		return true
+	}
 
-		obj := env.Eval(e.X)[0]
-		objVal := obj.Value.(reflect.Value)
+	return func(env *environ) (Object, bool) {
+		env.thread.step()
+		objVal := x(env).Value.(reflect.Value)
 		dynamicVal := objVal.Elem()
 		dynamicRtyp := dynamicVal.Type()
 
-		var resultObj Object
 		var assertSuccess bool
-
-		switch typ.Underlying().(type) {
-		case *types.Interface:
+		if implements {
 			// assert that dynamic type of obj implements typ
 			// TODO This should be a runtime panic if not
-
 			assertSuccess = objVal.Type().Implements(toRtyp)
+		} else {
+			assertSuccess = areIdentical(dynamicRtyp, toRtyp)
+		}
 
-			if assertSuccess {
-				resultVal := dynamicVal.Convert(toRtyp)
-				resultObj = Object{
-					Sim:   sim,
-					Typ:   toTyp,
-					Value: resultVal,
-				}
-			} else if !commaOk {
-				// TODO this should be a runtime panic
-				err := fmt.Errorf("interface conversion: interface is %v, not %v", dynamicRtyp, toRtyp)
-				panic(err)
-			} else {
-				resultObj = Object{
-					Sim:   sim,
-					Typ:   toTyp,
-					Value: reflect.Zero(toRtyp),
-				}
-			}
-
-		default:
-			isNamed := func(t reflect.Type) bool {
-				return len(t.Name()) > 0
-			}
+		if assertSuccess {
+			return Object{
+				Sim:   sim,
+				Typ:   toTyp,
+				Value: dynamicVal.Convert(toRtyp),
+			}, true
+		} else if !commaOk {
+			// TODO this should be a runtime panic
+			err := fmt.Errorf("interface conversion: interface is %v, not %v", dynamicRtyp, toRtyp)
+			panic(err)
+		}
+		return Object{
+			Sim:   sim,
+			Typ:   toTyp,
+			Value: reflect.Zero(toRtyp),
+		}, false
+	}
+}
 
-			areIdentical := func(t1, t2 reflect.Type) bool {
-				if !t1.AssignableTo(t2) {
-					return false
-				}
-				if isNamed(t1) != isNamed(t2) {
-					return false
-				}
-				if t1.Kind() == reflect.Chan && t1.ChanDir() != t2.ChanDir() {
-					return false
-				}
-				return true
-			}
+// conversion compiles a conversion T(x).
+func (c *compiler) conversion(e *ast.CallExpr) evalFunc {
+	// Get the type we're converting to
+	typ := c.info.TypeOf(e.Fun)
+	rtyp, sim := getReflectType(c.interp.typeMap, typ)
+	if rtyp == nil {
+		return c.notImplemented(e, "Conversion to %s not implemented yet", typ)
+	}
+	arg := c.expr(e.Args[0])
+	return func(env *environ) Object {
+		env.thread.step()
 
-			assertSuccess = areIdentical(dynamicRtyp, toRtyp)
-			if assertSuccess {
-				resultVal := dynamicVal.Convert(toRtyp)
-				resultObj = Object{
-					Sim:   sim,
-					Typ:   toTyp,
-					Value: resultVal,
-				}
-			} else if !commaOk {
-				// TODO this should be a runtime panic
-				err := fmt.Errorf("interface conversion: interface is %v, not %v", dynamicRtyp, toRtyp)
-				panic(err)
-			} else {
-				resultObj = Object{
-					Sim:   sim,
-					Typ:   toTyp,
-					Value: reflect.Zero(toRtyp),
-				}
-			}
-		}
+		// Evaluate the value to be converted
+		argObj := arg(env)
 
-		// Return the appropriate
-		if commaOk {
-			successObj := Object{
-				Sim:   false,
-				Typ:   types.Typ[types.Bool],
-				Value: reflect.ValueOf(assertSuccess),
-			}
-			return []Object{resultObj, successObj}
+		var val reflect.Value
+		argVal, ok := argObj.Value.(reflect.Value)
+		if !ok {
+			// This means it's a conversion of nil
+			// Use the zero value
+			val = reflect.Zero(rtyp)
 		} else {
-			return []Object{resultObj}
+			val = argVal.Convert(rtyp)
 		}
 
-	case *ast.BinaryExpr:
-		left := env.Eval(e.X)[0]
-		right := env.Eval(e.Y)[0]
+		return Object{
+			Value: val,
+			Typ:   typ,
+			Sim:   sim,
+		}
+	}
+}
 
-		var obj Object
+// mapIndex compiles a map index expression, whose value has type resultTyp.
+// The code also reports whether the key was found.
+func (c *compiler) mapIndex(e *ast.IndexExpr, resultTyp types.Type) func(env *environ) (Object, bool) {
+	mapTyp := c.info.TypeOf(e.X).Underlying().(*types.Map)
+	keyRtyp, _ := getReflectType(c.interp.typeMap, mapTyp.Key())
+	rtyp, sim := getReflectType(c.interp.typeMap, resultTyp)
+	if keyRtyp == nil || rtyp == nil {
+		c.errorf(e.Pos(), "Maps of type %s not implemented yet", mapTyp)
+		return nil
+	}
+	key := c.expr(e.Index)
+	m := c.expr(e.X)
+	return func(env *environ) (Object, bool) {
+		env.thread.step()
+		keyVal, ok := key(env).Value.(reflect.Value)
+		if !ok {
+			// Must be untyped nil. Use zero value of type.
+			keyVal = reflect.Zero(keyRtyp)
+		}
+		mapVal := m(env).Value.(reflect.Value)
+		resultVal := mapVal.MapIndex(keyVal)
+		keyFound := true
+		if !resultVal.IsValid() {
+			// The key wasn't found in the map (including case where map is nil)
+			resultVal = reflect.Zero(rtyp)
+			keyFound = false
+		}
+		return Object{
+			Value: resultVal,
+			Typ:   resultTyp,
+			Sim:   sim,
+		}, keyFound
+	}
+}
 
-		switch e.Op {
-		case token.LSS, token.GTR, token.LEQ, token.GEQ, token.EQL, token.NEQ:
-			obj = doBinaryComparisonOp(env, left, right, e.Op, typ)
-		default:
-			obj = doBinaryOp(env, left, right, e.Op)
+// sliceIndex compiles a slice index expression, whose value has type resultTyp.
+func (c *compiler) sliceIndex(e *ast.IndexExpr, resultTyp types.Type) evalFunc {
+	rtyp, sim := getReflectType(c.interp.typeMap, resultTyp)
+	if rtyp == nil {
+		return c.notImplemented(e, "Slices of %s not implemented yet", resultTyp)
+	}
+	index := c.expr(e.Index)
+	slice := c.expr(e.X)
+	return func(env *environ) Object {
+		env.thread.step()
+		ind := int(index(env).Value.(reflect.Value).Int())
+		sliceVal := slice(env).Value.(reflect.Value)
+		checkIndex(ind, sliceVal.Len())
+		resultVal := sliceVal.Index(ind)
+		return Object{
+			Value: resultVal,
+			Typ:   resultTyp,
+			Sim:   sim,
 		}
+	}
+}
 
-		return []Object{obj}
-	case *ast.Ident:
-		val, _ := env.lookupParent(e.String())
-		return []Object{val}
-	case *ast.ParenExpr:
-		return env.Eval(e.X)
-	case *ast.SelectorExpr:
-		// TODO: implement selector expressions!
-		sel, ok := env.info.Selections[e]
-		if !ok {
-			// Then this selector expression denotes a package object
-			obj := env.info.Uses[e.Sel]
-			p := obj.Pkg().Name()
-			v, ok := env.interp.pkgs[p].Lookup(obj.Name())
-			if !ok {
-				log.Fatalf("Package object %q not found", obj)
-			}
-			return []Object{v}
+// arrayIndex compiles an index expression on an array, or on a pointer to an
+// array if ptr is set. The elements of an array that is a variable are
+// variables too, so they can be assigned to.
+func (c *compiler) arrayIndex(e *ast.IndexExpr, resultTyp types.Type, ptr bool) evalFunc {
+	rtyp, sim := getReflectType(c.interp.typeMap, resultTyp)
+	if rtyp == nil {
+		return c.notImplemented(e, "Arrays of %s not implemented yet", resultTyp)
+	}
+	arrayTyp := c.info.TypeOf(e.X).Underlying()
+	if ptr {
+		arrayTyp = arrayTyp.(*types.Pointer).Elem().Underlying()
+	}
+	length := arrayTyp.(*types.Array).Len()
+	if v := c.info.Types[e.Index].Value; v != nil {
+		if n, ok := exact.Int64Val(exact.ToInt(v)); !ok || n < 0 || n >= length {
+			c.errorf(e.Index.Pos(), "invalid argument: index %s out of bounds [0:%d]", v, length)
+			return nil
 		}
-		switch sel.Kind() {
-		case types.FieldVal:
-			xo := env.Eval(e.X)[0]
-			v := xo.Value.(reflect.Value)
-			if sel.Indirect() {
-				v = v.Elem()
-			}
-			obj := Object{
-				Value: v.FieldByIndex(sel.Index()),
-				Typ:   sel.Type(),
+	}
+	array := c.expr(e.X)
+	index := c.index(e.Index)
+	return func(env *environ) Object {
+		env.thread.step()
+		arrayVal := array(env).Value.(reflect.Value)
+		if ptr {
+			arrayVal = arrayVal.Elem()
+			if !arrayVal.IsValid() {
+				// Nil pointer dereference!
+				panic("goconsole: Nil pointer dereference")
 			}
-			return []Object{obj}
-		case types.MethodVal:
-			log.Fatal("Method values not yet implemented:", sel.String())
-		case types.MethodExpr:
-			log.Fatal("Method expressions not yet implemented:", sel.String())
 		}
-	case *ast.CallExpr:
-		switch env.getCallExprKind(e) {
-		case builtinKind:
-			return env.evalBuiltinCall(e, false)
-		case conversionKind:
-			// Get the type we're converting to
-			typ := env.info.TypeOf(e.Fun)
-			rtyp, sim := getReflectType(env.interp.typeMap, typ)
-			if rtyp == nil {
-				log.Fatal("Failed to obtain reflect.Type to represent type:", typ)
-			}
+		ind := index(env)
+		checkIndex(ind, arrayVal.Len())
+		return Object{
+			Value: arrayVal.Index(ind),
+			Typ:   resultTyp,
+			Sim:   sim,
+		}
+	}
+}
 
-			// Evaluate the value to be converted
-			argObj := env.Eval(e.Args[0])[0]
+// An indexError is the run-time panic for an index out of range, as in compiled code.
+type indexError struct {
+	index, length int
+}
 
-			var val reflect.Value
-			argVal, ok := argObj.Value.(reflect.Value)
-			if !ok {
-				// This means it's a conversion of nil
-				// Use the zero value
-				val = reflect.Zero(rtyp)
-			} else {
-				val = argVal.Convert(rtyp)
-			}
+func (e indexError) Error() string {
+	return fmt.Sprintf("runtime error: index out of range [%d] with length %d", e.index, e.length)
+}
 
-			obj := Object{
-				Value: val,
-				Typ:   typ,
-				Sim:   sim,
-			}
-			return []Object{obj}
-		case callKind:
-			return env.evalFuncCall(e, false)
-		}
-	case *ast.IndexExpr:
-		resultTyp := typ
-		collTyp := env.info.TypeOf(e.X)
+func (e indexError) RuntimeError() {}
 
-		// Figure out which type of object we're indexing
-		objTyp := collTyp.Underlying()
-		switch objTyp := objTyp.(type) {
-		case *types.Array:
-			log.Fatal("Array indexing not implemented yet")
-		case *types.Map:
-			keyObj := env.Eval(e.Index)[0]
-			keyVal, ok := keyObj.Value.(reflect.Value)
-			if !ok {
-				// Must be untyped nil. Use zero value of type.
-				rtyp, _ := getReflectType(env.interp.typeMap, objTyp.Key())
-				if rtyp == nil {
-					log.Fatalf("couldn't get reflect.Type corresponding to %q", objTyp)
-				}
-				keyVal = reflect.Zero(rtyp)
-			}
-			commaOk := false
-			var boolTyp types.Type
-			if tupleTyp, ok := resultTyp.(*types.Tuple); ok {
-				// "Comma-ok" map index expression
-				resultTyp = tupleTyp.At(0).Type()
-				boolTyp = tupleTyp.At(1).Type()
-				commaOk = true
-			}
-			rtyp, sim := getReflectType(env.interp.typeMap, resultTyp)
-			if rtyp == nil {
-				log.Fatal("Couldn't get reflect.Type of result type of map index expression")
-			}
-			mapObj := env.Eval(e.X)[0]
-			mapVal := mapObj.Value.(reflect.Value)
-			resultVal := mapVal.MapIndex(keyVal)
-			keyFound := true
-			if !resultVal.IsValid() {
-				// The key wasn't found in the map (including case where map is nil)
-				resultVal = reflect.Zero(rtyp)
-				keyFound = false
-			}
-			resultObj := Object{
-				Value: resultVal,
-				Typ:   resultTyp,
-				Sim:   sim,
-			}
-			if commaOk {
-				foundObj := Object{
-					Value: reflect.ValueOf(keyFound),
-					Typ:   boolTyp,
-					Sim:   false,
-				}
-				return []Object{resultObj, foundObj}
-			}
-			return []Object{resultObj}
+// checkIndex panics if index is out of range for a length of length.
+func checkIndex(index, length int) {
+	if index < 0 || index >= length {
+		panic(indexError{index, length})
+	}
+}
 
-		case *types.Slice:
-			indObj := env.Eval(e.Index)[0]
-			ind := int(indObj.Value.(reflect.Value).Int())
-			sliceObj := env.Eval(e.X)[0]
-			sliceVal := sliceObj.Value.(reflect.Value)
-			resultVal := sliceVal.Index(ind)
-			rtyp, sim := getReflectType(env.interp.typeMap, resultTyp)
-			if rtyp == nil {
-				log.Fatal("Couldn't get reflect.Type of result type of slice index expression")
-			}
-			resultObj := Object{
-				Value: resultVal,
-				Typ:   resultTyp,
-				Sim:   sim,
-			}
-			return []Object{resultObj}
-		case *types.Basic:
-			log.Fatal("String indexing not implemented yet")
-		case *types.Pointer:
-			log.Fatal("Pointer-to-array indexing not implemented yet")
+// index compiles an index, which may be of any integer type.
+func (c *compiler) index(expr ast.Expr) func(env *environ) int {
+	if class, _ := basicClassOf(c.info.TypeOf(expr)); class == uintClass {
+		x := c.uintExpr(expr)
+		return func(env *environ) int {
+			return int(x(env))
 		}
+	}
+	x := c.intExpr(expr)
+	return func(env *environ) int {
+		return int(x(env))
+	}
+}
 
-		log.Fatalf("Unhandled expression type: %T", e)
-	default:
-		log.Fatalf("Unhandled expression type: %T", e)
+// isTrue returns the value of a boolean, which may be an untyped constant.
+func isTrue(obj Object) bool {
+	if v, ok := obj.Value.(reflect.Value); ok {
+		return v.Bool()
 	}
-	return []Object{}
+	return exact.BoolVal(obj.Value.(exact.Value))
 }
 
 func isTyped(typ types.Type) bool {
@@ -465,7 +567,10 @@ func convertExactToReflect(typeMap *typeutil.Map, tv types.TypeAndValue) reflect
 	case types.Uint, types.Uint8, types.Uint16, types.Uint32, types.Uint64, types.Uintptr:
 		ui64, _ := exact.Uint64Val(ev)
 		rv.SetUint(ui64)
-	case types.Float32, types.Float64:
+	case types.Float32:
+		f32, _ := exact.Float32Val(ev)
+		rv.SetFloat(float64(f32))
+	case types.Float64:
 		f64, _ := exact.Float64Val(ev)
 		rv.SetFloat(f64)
 	case types.Complex64, types.Complex128:
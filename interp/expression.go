@@ -0,0 +1,400 @@
+package interp
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+
+	"golang.org/x/tools/go/exact"
+	"golang.org/x/tools/go/types"
+)
+
+// As with values bound with Define, the variables of an expression are declared
+// to the type checker as package-level variables initialized from exported
+// variables of a package made just for them.
+const (
+	exprPkgPath = "goconsole/vars"
+	exprPkgName = "goconsole_vars_"
+)
+
+// The number of expression sources an Evaluator keeps compiled expressions for.
+const exprCacheSize = 256
+
+// Evaluator evaluates single Go expressions against variables given as a map
+// from name to value. Compiled expressions are cached, keyed by the source of the
+// expression and the types of the variables, so evaluating the same expression
+// again with variables of the same types does not type check it again. Only the
+// expressions of the 256 most recently used sources are kept.
+//
+// An Evaluator and its Exprs are safe for concurrent use. Expressions are type
+// checked one at a time, but evaluated concurrently.
+type Evaluator struct {
+	interp    *interp
+	compileMu sync.Mutex // held while compiling an expression
+
+	mu    sync.Mutex               // guards cache and lru
+	cache map[string]*list.Element // of *cachedExprs, by source
+	lru   *list.List               // of *cachedExprs, most recently used first
+}
+
+// cachedExprs are the compiled expressions of a source, one for each set of
+// variable types it was compiled with.
+type cachedExprs struct {
+	src   string
+	exprs []*Expr
+}
+
+// NewEvaluator returns an Evaluator for expressions that may use the packages in opts.
+func NewEvaluator(opts Options) *Evaluator {
+	return newEvaluator(newInterp(opts))
+}
+
+// newEvaluator returns an Evaluator for expressions that may use the packages of i.
+func newEvaluator(i *interp) *Evaluator {
+	return &Evaluator{
+		interp: i,
+		cache:  map[string]*list.Element{},
+		lru:    list.New(),
+	}
+}
+
+var (
+	defaultEvaluator     *Evaluator
+	defaultEvaluatorOnce sync.Once
+)
+
+// EvalExpr evaluates the expression src, which may use the variables in vars but no packages.
+func EvalExpr(src string, vars map[string]interface{}) (interface{}, error) {
+	defaultEvaluatorOnce.Do(func() {
+		defaultEvaluator = NewEvaluator(Options{})
+	})
+	return defaultEvaluator.Eval(src, vars)
+}
+
+// Expr is a type-checked expression, ready to be evaluated.
+type Expr struct {
+	ev       *Evaluator
+	src      string
+	expr     ast.Expr
+	code     evalFunc
+	info     *types.Info
+	scope    *types.Scope
+	varTypes map[string]reflect.Type
+	varObjs  map[string]*types.Var
+}
+
+// Type returns the type of the expression.
+func (x *Expr) Type() types.Type {
+	return x.info.TypeOf(x.expr)
+}
+
+// Eval evaluates the expression src using the variables in vars. A nil value in
+// vars is a variable of type interface{}. Evaluating it is bound by the limits of
+// the Options the Evaluator was made with, and returns ErrStepLimit and so on when
+// it goes over them.
+func (ev *Evaluator) Eval(src string, vars map[string]interface{}) (interface{}, error) {
+	return ev.EvalContext(context.Background(), src, vars)
+}
+
+// EvalContext is like Eval, but stops evaluating src and returns the context's
+// error if ctx is done first.
+func (ev *Evaluator) EvalContext(ctx context.Context, src string, vars map[string]interface{}) (interface{}, error) {
+	x, err := ev.compile(src, vars)
+	if err != nil {
+		return nil, err
+	}
+	return x.eval(ctx, vars)
+}
+
+// Compile type checks the expression src, given variables of the same types as the
+// values in vars. The returned Expr may be evaluated with any variables of those types.
+// It returns an error if src uses what the interpreter doesn't implement yet.
+func (ev *Evaluator) Compile(src string, vars map[string]interface{}) (*Expr, error) {
+	return ev.compile(src, vars)
+}
+
+// Eval evaluates the expression using the variables in vars, which must have the
+// same names and types as the variables it was compiled with.
+func (x *Expr) Eval(vars map[string]interface{}) (interface{}, error) {
+	return x.EvalContext(context.Background(), vars)
+}
+
+// EvalContext is like Eval, but stops evaluating the expression and returns the
+// context's error if ctx is done first.
+func (x *Expr) EvalContext(ctx context.Context, vars map[string]interface{}) (interface{}, error) {
+	return x.eval(ctx, vars)
+}
+
+// varType returns the reflect.Type of a variable with the value v.
+func varType(v interface{}) reflect.Type {
+	if v == nil {
+		var emptyInterface interface{}
+		return reflect.TypeOf(&emptyInterface).Elem()
+	}
+	return reflect.TypeOf(v)
+}
+
+// matches reports whether the variables in vars have the types x was compiled with.
+func (x *Expr) matches(vars map[string]interface{}) bool {
+	if len(vars) != len(x.varTypes) {
+		return false
+	}
+	for name, v := range vars {
+		if rtyp, ok := x.varTypes[name]; !ok || rtyp != varType(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// cached returns the cached expression compiled from src with variables of the
+// types of those in vars, or nil if there isn't one.
+func (ev *Evaluator) cached(src string, vars map[string]interface{}) *Expr {
+	ev.mu.Lock()
+	defer ev.mu.Unlock()
+	if el, ok := ev.cache[src]; ok {
+		ev.lru.MoveToFront(el)
+		for _, x := range el.Value.(*cachedExprs).exprs {
+			if x.matches(vars) {
+				return x
+			}
+		}
+	}
+	return nil
+}
+
+func (ev *Evaluator) compile(src string, vars map[string]interface{}) (*Expr, error) {
+	if x := ev.cached(src, vars); x != nil {
+		return x, nil
+	}
+	ev.compileMu.Lock()
+	defer ev.compileMu.Unlock()
+	if x := ev.cached(src, vars); x != nil {
+		// Compiled while we waited
+		return x, nil
+	}
+
+	// The expression is parsed on its own, so that positions in errors are
+	// positions in src
+	fset := token.NewFileSet()
+	expr, err := parser.ParseExprFrom(fset, "expr", src, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	i := ev.interp
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	x := &Expr{
+		ev:       ev,
+		src:      src,
+		varTypes: map[string]reflect.Type{},
+		varObjs:  map[string]*types.Var{},
+	}
+
+	// Declare the variables in a package of their own
+	varsPkg := types.NewPackage(exprPkgPath, exprPkgName)
+	for _, name := range names {
+		if e, err := parser.ParseExpr(name); err != nil || name == "_" {
+			return nil, fmt.Errorf("variable %q: not an identifier", name)
+		} else if _, ok := e.(*ast.Ident); !ok {
+			return nil, fmt.Errorf("variable %q: not an identifier", name)
+		}
+		rtyp := varType(vars[name])
+		typ, err := i.getCheckerType(rtyp)
+		if err != nil {
+			return nil, fmt.Errorf("variable %s: %v", name, err)
+		}
+		v := types.NewVar(token.NoPos, varsPkg, hostVarName(name), typ)
+		varsPkg.Scope().Insert(v)
+		x.varTypes[name] = rtyp
+		x.varObjs[name] = v
+	}
+	varsPkg.MarkComplete()
+
+	pkgMap := map[string]*types.Package{}
+	for path, pkg := range i.checker.config.Packages {
+		pkgMap[path] = pkg
+	}
+	pkgMap[exprPkgPath] = varsPkg
+
+	// The variables are declared in one file, and the expression is an
+	// expression statement in a function in another
+	var srcBuf bytes.Buffer
+	fmt.Fprintf(&srcBuf, "package p;import %s %q;var(", exprPkgName, exprPkgPath)
+	for _, name := range names {
+		fmt.Fprintf(&srcBuf, "%s=%s.%s;", name, exprPkgName, hostVarName(name))
+	}
+	srcBuf.WriteString(")")
+	varsFile, err := parser.ParseFile(fset, "vars", srcBuf.String(), 0)
+	if err != nil {
+		return nil, err
+	}
+	imports := &ast.GenDecl{Tok: token.IMPORT}
+	for _, pkg := range i.pkgs {
+		if _, ok := vars[pkg.Name]; ok {
+			// The variable shadows the package
+			continue
+		}
+		imports.Specs = append(imports.Specs, &ast.ImportSpec{
+			Name: ast.NewIdent(pkg.Name),
+			Path: &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(pkg.Pkg.Path())},
+		})
+	}
+	exprFile := &ast.File{
+		Name: ast.NewIdent("p"),
+		Decls: []ast.Decl{imports, &ast.FuncDecl{
+			Name: ast.NewIdent("_"),
+			Type: &ast.FuncType{Params: &ast.FieldList{}},
+			Body: &ast.BlockStmt{List: []ast.Stmt{&ast.ExprStmt{X: expr}}},
+		}},
+	}
+
+	c := newChecker(nil, pkgMap)
+	info := &types.Info{
+		Types:      map[ast.Expr]types.TypeAndValue{},
+		Selections: map[*ast.SelectorExpr]*types.Selection{},
+		Defs:       map[*ast.Ident]types.Object{},
+		Uses:       map[*ast.Ident]types.Object{},
+		Scopes:     map[ast.Node]*types.Scope{},
+	}
+	pkg, _ := c.config.Check("", fset, []*ast.File{varsFile, exprFile}, info)
+	if len(c.errs) > 0 {
+		return nil, c.errs[0]
+	}
+	if err := checkValue(fset, info, expr); err != nil {
+		return nil, err
+	}
+	x.expr = expr
+	if err := i.checkPolicy(fset, info, x.expr); err != nil {
+		return nil, err
+	}
+	x.info = info
+	x.scope = pkg.Scope()
+	code, err := i.compileExpr(fset, info, x.expr)
+	if err != nil {
+		return nil, err
+	}
+	x.code = code
+
+	ev.addToCache(x)
+	return x, nil
+}
+
+// addToCache caches the compiled expression x, forgetting the expressions of
+// the least recently used source if there are too many.
+func (ev *Evaluator) addToCache(x *Expr) {
+	ev.mu.Lock()
+	defer ev.mu.Unlock()
+	if el, ok := ev.cache[x.src]; ok {
+		cached := el.Value.(*cachedExprs)
+		cached.exprs = append(cached.exprs, x)
+		return
+	}
+	ev.cache[x.src] = ev.lru.PushFront(&cachedExprs{src: x.src, exprs: []*Expr{x}})
+	if ev.lru.Len() > exprCacheSize {
+		oldest := ev.lru.Remove(ev.lru.Back()).(*cachedExprs)
+		delete(ev.cache, oldest.src)
+	}
+}
+
+// checkValue returns an error if the type-checked expression expr doesn't
+// have a single value, or is an untyped constant that overflows its default
+// type. The type checker doesn't check these for an expression statement.
+func checkValue(fset *token.FileSet, info *types.Info, expr ast.Expr) error {
+	var msg string
+	tv := info.Types[expr]
+	switch typ := tv.Type.(type) {
+	case *types.Tuple:
+		if typ.Len() == 0 {
+			msg = fmt.Sprintf("%s (no value) used as value", types.ExprString(expr))
+		} else {
+			msg = fmt.Sprintf("multiple-value %s in single-value context", types.ExprString(expr))
+		}
+	case *types.Basic:
+		switch {
+		case typ.Kind() == types.UntypedNil:
+			msg = "use of untyped nil"
+		case tv.Value != nil && !isTyped(typ) && overflows(tv.Value, typedBasic(typ)):
+			msg = fmt.Sprintf("constant %s overflows %s", ConstString(tv.Value), typedBasic(typ))
+		}
+	}
+	if msg == "" {
+		return nil
+	}
+	return types.Error{Fset: fset, Pos: expr.Pos(), Msg: msg}
+}
+
+// eval evaluates the expression on a new thread with the given context, like
+// runStmts runs an input.
+func (x *Expr) eval(ctx context.Context, vars map[string]interface{}) (result interface{}, err error) {
+	if !x.matches(vars) {
+		return nil, fmt.Errorf("variables do not match those %q was compiled with", x.src)
+	}
+
+	i := x.ev.interp
+	parentCtx := ctx
+	if i.limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, i.limits.Timeout)
+		defer cancel()
+	}
+	th := &thread{
+		ctx:    ctx,
+		budget: &budget{limits: i.limits},
+	}
+	leave := i.enterThread(th)
+	defer leave()
+	env := &environ{
+		interp: i,
+		thread: th,
+		scope:  x.scope,
+		objs:   map[string]Object{},
+	}
+	for name, rtyp := range x.varTypes {
+		val := reflect.New(rtyp).Elem()
+		if v := vars[name]; v != nil {
+			val.Set(reflect.ValueOf(v))
+		}
+		env.objs[name] = Object{
+			Value: val,
+			Typ:   x.varObjs[name].Type(),
+		}
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			u, ok := r.(unwind)
+			if !ok {
+				err = fmt.Errorf("%s: panic: %v", x.src, r)
+				return
+			}
+			err = u.err
+			if err == context.DeadlineExceeded && parentCtx.Err() == nil {
+				// Our deadline, not the caller's
+				err = ErrTimeLimit
+			}
+		}
+	}()
+	obj := x.code(env)
+	switch v := obj.Value.(type) {
+	case reflect.Value:
+		return v.Interface(), nil
+	case exact.Value:
+		// Untyped constant. Use the value of its default type.
+		return getTypedObject(obj).Value.(reflect.Value).Interface(), nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,152 @@
+package interp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEvalExpr(t *testing.T) {
+	tests := []struct {
+		src  string
+		vars map[string]interface{}
+		want interface{}
+	}{
+		{`age >= 18 && country == "NZ"`, map[string]interface{}{"age": 20, "country": "NZ"}, true},
+		{`age >= 18 && country == "NZ"`, map[string]interface{}{"age": 20, "country": "AU"}, false},
+		{`age < 18 || country != "NZ"`, map[string]interface{}{"age": 20, "country": "AU"}, true},
+		{`!(a == b)`, map[string]interface{}{"a": 1.5, "b": 2.5}, true},
+		{`v != nil`, map[string]interface{}{"v": nil}, false},
+		{`a*b + 1`, map[string]interface{}{"a": 3, "b": 4}, 13},
+	}
+	for _, test := range tests {
+		got, err := EvalExpr(test.src, test.vars)
+		if err != nil || got != test.want {
+			t.Errorf("EvalExpr(%q, %v) = %v, %v, want %v", test.src, test.vars, got, err, test.want)
+		}
+	}
+}
+
+func TestEvalExprShortCircuit(t *testing.T) {
+	// The right operands would divide by zero if they were evaluated
+	vars := map[string]interface{}{"n": 0}
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		{"n != 0 && 10/n > 1", false},
+		{"n == 0 || 10/n > 1", true},
+	}
+	for _, test := range tests {
+		got, err := EvalExpr(test.src, vars)
+		if err != nil || got != test.want {
+			t.Errorf("EvalExpr(%q) = %v, %v, want %v", test.src, got, err, test.want)
+		}
+	}
+}
+
+func TestEvaluatorNotImplemented(t *testing.T) {
+	ev := NewEvaluator(Options{})
+	_, err := ev.Compile(`s[0] == 'a'`, map[string]interface{}{"s": "abc"})
+	if err == nil || !strings.Contains(err.Error(), "String indexing not implemented yet") {
+		t.Errorf("Compile: got error %v, want one for string indexing", err)
+	}
+}
+
+// Errors are reported at positions in the expression, and don't describe the
+// code the expression is checked in.
+func TestEvaluatorErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		vars map[string]interface{}
+		want string
+	}{
+		{"1 << 70", nil, "expr:1:1: constant 1180591620717411303424 overflows int"},
+		{"a + b", map[string]interface{}{"a": 1, "b": "x"}, "expr:1:1: invalid operation"},
+		{"a +", nil, "expr:1:4: expected operand, found 'EOF'"},
+		{"nil", nil, "expr:1:1: use of untyped nil"},
+	}
+	ev := NewEvaluator(Options{})
+	for _, test := range tests {
+		_, err := ev.Compile(test.src, test.vars)
+		if err == nil || !strings.HasPrefix(err.Error(), test.want) {
+			t.Errorf("Compile(%q): got error %v, want %q", test.src, err, test.want)
+		}
+	}
+}
+
+func TestEvaluatorCache(t *testing.T) {
+	ev := NewEvaluator(Options{})
+	for n := 0; n < exprCacheSize+10; n++ {
+		if _, err := ev.Eval(fmt.Sprintf("x + %d", n), map[string]interface{}{"x": 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(ev.cache) != exprCacheSize || ev.lru.Len() != exprCacheSize {
+		t.Errorf("cached %d sources, want %d", len(ev.cache), exprCacheSize)
+	}
+	if _, ok := ev.cache["x + 0"]; ok {
+		t.Errorf("least recently used source still cached")
+	}
+}
+
+func TestEvaluatorLimits(t *testing.T) {
+	loop := "func() int {\nfor {\n}\n}()"
+	ev := NewEvaluator(Options{Limits: Limits{MaxSteps: 5}})
+	if _, err := ev.Eval(loop, nil); err != ErrStepLimit {
+		t.Errorf("with MaxSteps: got error %v, want %v", err, ErrStepLimit)
+	}
+	ev = NewEvaluator(Options{Limits: Limits{Timeout: 10 * time.Millisecond}})
+	if _, err := ev.Eval(loop, nil); err != ErrTimeLimit {
+		t.Errorf("with Timeout: got error %v, want %v", err, ErrTimeLimit)
+	}
+
+	// The caller's deadline gives the context's error
+	ev = NewEvaluator(Options{Limits: Limits{Timeout: time.Minute}})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := ev.EvalContext(ctx, loop, nil); err != context.DeadlineExceeded {
+		t.Errorf("with the caller's deadline: got error %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+// Expressions are evaluated concurrently, so one that runs for a long time
+// doesn't hold up others.
+func TestEvaluatorConcurrent(t *testing.T) {
+	ev := NewEvaluator(Options{})
+	double, err := ev.Compile("x * 2", map[string]interface{}{"x": 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	looped := make(chan error)
+	go func() {
+		_, err := ev.EvalContext(ctx, "func() int {\nfor {\n}\n}()", nil)
+		looped <- err
+	}()
+
+	var wg sync.WaitGroup
+	for n := 0; n < 8; n++ {
+		wg.Add(2)
+		go func(n int) {
+			defer wg.Done()
+			if v, err := double.Eval(map[string]interface{}{"x": n}); err != nil || v != n*2 {
+				t.Errorf("x * 2 with x = %d: got %v, %v", n, v, err)
+			}
+		}(n)
+		go func(n int) {
+			defer wg.Done()
+			if v, err := ev.Eval("x + 1", map[string]interface{}{"x": n}); err != nil || v != n+1 {
+				t.Errorf("x + 1 with x = %d: got %v, %v", n, v, err)
+			}
+		}(n)
+	}
+	wg.Wait()
+	cancel()
+	if err := <-looped; err != context.Canceled {
+		t.Errorf("loop: got error %v, want %v", err, context.Canceled)
+	}
+}
@@ -2,178 +2,327 @@ package interp
 
 import (
 	"go/ast"
-	"go/token"
 	"reflect"
+	"sync/atomic"
 
 	"golang.org/x/tools/go/types"
-	"golang.org/x/tools/go/types/typeutil"
 )
 
-// Assumes we want an Object wrapping a settable reflect.Value with the zero value
-func getObjectOfType(typeMap *typeutil.Map, typ types.Type) Object {
-	rtyp, sim := getReflectType(typeMap, typ)
-	val := reflect.New(rtyp).Elem()
+// A funcLit is a compiled function literal. Its code runs in an environment
+// holding its parameters and local variables, inside the environment of the
+// closure, which holds the variables it captured when it was made.
+type funcLit struct {
+	lit *ast.FuncLit
+
+	block  *block
+	params []funcVar // by parameter
+	body   []execFunc
+
+	// The results, each of which starts out as the zero value
+	results []funcVar
+
+	// The variables the closure captures, and their code in the block
+	// enclosing the function literal
+	captures *block
+	resolve  []evalFunc
+}
+
+// A funcVar is a parameter or result of a function literal.
+type funcVar struct {
+	slot int // in the function's block, or -1 if it has none
+	typ  types.Type
+	rtyp reflect.Type
+	sim  bool
+}
+
+// newFuncVar returns a funcVar for v, kept in the slot of b it has, if any.
+func (c *compiler) newFuncVar(b *block, v *types.Var) funcVar {
+	fv := funcVar{
+		slot: -1,
+		typ:  v.Type(),
+	}
+	if slot, ok := b.slots[v]; ok {
+		fv.slot = slot
+	}
+	fv.rtyp, fv.sim = getReflectType(c.interp.typeMap, fv.typ)
+	return fv
+}
+
+// newVar returns a new variable of the type of fv, with the value of obj.
+func (fv *funcVar) newVar(obj Object) Object {
+	// Create a variable of the right type with the zero value, then set its value from obj
+	newVal := reflect.New(fv.rtyp).Elem()
+	rval, ok := obj.Value.(reflect.Value)
+	if !ok {
+		// Must be untyped nil. Use zero value of type instead
+		rval = reflect.Zero(fv.rtyp)
+	}
+	newVal.Set(rval)
+	return Object{
+		Value: newVal,
+		Typ:   fv.typ,
+		Sim:   fv.sim,
+		fn:    newClosureRef(fv.rtyp, obj.closure()),
+	}
+}
+
+// zero returns a new variable of the type of fv, with the zero value.
+func (fv *funcVar) zero() Object {
 	return Object{
-		Value: val,
-		Typ:   typ,
-		Sim:   sim,
+		Value: reflect.New(fv.rtyp).Elem(),
+		Typ:   fv.typ,
+		Sim:   fv.sim,
+		fn:    newClosureRef(fv.rtyp, nil),
 	}
 }
 
-func createUnsimulatedFunc(env *environ, funcLit *ast.FuncLit, rtyp reflect.Type) reflect.Value {
-	funcType := env.info.Types[funcLit].Type.(*types.Signature)
-	funcScope := env.info.Scopes[funcLit.Type]
+// funcLit compiles a function literal. Its body is compiled once, and the code
+// makes a closure of it each time the literal is evaluated.
+func (c *compiler) funcLit(lit *ast.FuncLit) evalFunc {
+	// TODO: Simulated functions, to interact with each other correctly inside the
+	// interpreter, should not be "func([]reflect.Value)[]reflect.Value" but instead
+	// "func([]Object)[]Object". This is because a simulated function's arguments may
+	// themselves be simulated functions! To handle this case correctly, the implementation
+	// of the function's body needs to know which arguments are simulated, which means
+	// the arguments must be of type Object rather than reflect.Value.
+	//
+	// Unsimulated functions do not have this problem as long as we guarantee that an
+	// unsimulated function type's parameter types will also be unsimulated. We will make
+	// sure that this guarantee holds.
+	typ := c.info.TypeOf(lit)
+	funcType := typ.(*types.Signature)
 	funcParams := funcType.Params()
 	funcResults := funcType.Results()
 
-	closureEnv := environ{
-		info:   env.info,
-		interp: env.interp,
-		scope:  env.scope,
-		parent: env.parent,
-		objs:   map[string]Object{},
-	}
-	vis := newVisitor(env, &closureEnv, funcLit)
-	ast.Walk(vis, funcLit)
-	funcVal := func(in []reflect.Value) (results []reflect.Value) {
-		// 1) Create new environment that "inherits" from closureEnv
-		funcEnv := &environ{
-			info:   closureEnv.info,
-			interp: closureEnv.interp,
-			scope:  funcScope,
-			parent: &closureEnv,
-			objs:   map[string]Object{},
-		}
+	fn := &funcLit{
+		lit: lit,
+		captures: &block{
+			parent: c.block,
+			slots:  map[*types.Var]int{},
+			lit:    lit,
+		},
+	}
+	fn.block = newBlock(fn.captures, c.info.Scopes[lit.Type])
+	for j := 0; j < funcParams.Len(); j++ {
+		fn.params = append(fn.params, c.newFuncVar(fn.block, funcParams.At(j)))
+	}
+	for j := 0; j < funcResults.Len(); j++ {
+		fn.results = append(fn.results, c.newFuncVar(fn.block, funcResults.At(j)))
+	}
+	c.block = fn.block
+	fn.body = c.stmtList(lit.Body.List, false)
+	c.block = fn.captures.parent
 
-		// 2) Add parameters to environment with values from `in`
-		for i := 0; i < funcParams.Len(); i++ {
-			// Add variable to environment for this param
-			param := funcParams.At(i)
-			obj := Object{
-				Value: in[i],
-				Typ:   param.Type(),
-				Sim:   false, // parameters to unsimulated function type must be unsimulated
-			}
-			funcEnv.addVar(param, nil, obj)
-		}
+	// The captured variables are only known now that the body is compiled
+	for j, v := range fn.captures.captured {
+		fn.resolve = append(fn.resolve, c.variable(v, fn.captures.names[j]))
+	}
 
-		// 3) If we have named result parameters, add those to the environment with zero value
-		// TODO: add named results
-		var resultObjs []Object
-		if funcResults.Len() > 0 {
-			// TODO: I should probably stick with a []Object so named results will be easier
-			//   Then I can copy the reflect.Value out of each result at the end into a slice
-			//   to actually return from the function.
-			resultObjs = make([]Object, funcResults.Len())
-			results = make([]reflect.Value, funcResults.Len())
-			for i, _ := range results {
-				resultObjs[i] = getObjectOfType(funcEnv.interp.typeMap, funcResults.At(i).Type())
-				results[i] = resultObjs[i].Value.(reflect.Value)
-			}
+	rtyp, sim := getReflectType(c.interp.typeMap, typ)
+	return func(env *environ) Object {
+		env.thread.step()
 
+		// Make an environment to hold the variables this function closes over.
+		// We'll use this as the parent environment of calls instead of env.
+		// That way, if the user rebinds the names of variables that this function
+		// closes over, the function will continue referencing the old variables.
+		closureEnv := &environ{
+			interp:   env.interp,
+			parent:   env.parent,
+			vars:     make([]Object, len(fn.resolve)),
+			varNames: fn.captures.names,
+		}
+		for j, resolve := range fn.resolve {
+			closureEnv.vars[j] = resolve(env)
 		}
 
-		// 4) Evaluate the body of the function (topLevel=false)
-		//     Note: If results are returned, handle them
-		stmtRes := funcEnv.runStmt(funcLit.Body, "", false)
-		if res, ok := stmtRes.(returnResult); ok {
-			for i, resObj := range res {
-				assignObj(resultObjs[i], resObj)
+		if sim {
+			// We must simulate the function type we want to create
+			f := createSimulatedFunc(closureEnv, fn)
+			return Object{
+				Value: reflect.ValueOf(f),
+				Typ:   typ,
+				Sim:   true,
 			}
 		}
-		return
+		// We can actually create a function of the right type
+		return Object{
+			Value: createUnsimulatedFunc(closureEnv, fn, rtyp),
+			Typ:   typ,
+			fn:    &closureRef{cl: &closure{env: closureEnv, fn: fn}},
+		}
 	}
-	return reflect.MakeFunc(rtyp, funcVal)
 }
 
-func createSimulatedFunc(env *environ, funcLit *ast.FuncLit) func([]Object) []Object {
-	funcType := env.info.Types[funcLit].Type.(*types.Signature)
-	funcScope := env.info.Scopes[funcLit.Type]
-	funcParams := funcType.Params()
-	funcResults := funcType.Results()
+// call runs the body of fn on th, in a new environment inside closureEnv with
+// the parameters set from in. It returns the objects of the results.
+func (fn *funcLit) call(closureEnv *environ, th *thread, in []Object) []Object {
+	// 1) Create new environment that "inherits" from closureEnv
+	funcEnv := &environ{
+		interp:   closureEnv.interp,
+		thread:   th,
+		parent:   closureEnv,
+		vars:     make([]Object, len(fn.block.names)),
+		varNames: fn.block.names,
+	}
 
-	// Make an environment to hold the variables this function closes over.
-	// We'll use this as the parent environment of calls instead of env.
-	// That way, if the user rebinds the names of variables that this function
-	// closes over, the function will continue referencing the old variables.
-	closureEnv := environ{
-		info:   env.info,
-		interp: env.interp,
-		scope:  env.scope,
-		parent: env.parent,
-		objs:   map[string]Object{},
-	}
-	vis := newVisitor(env, &closureEnv, funcLit)
-	ast.Walk(vis, funcLit)
-	return func(in []Object) (results []Object) {
-		// 1) Create new environment that "inherits" from closureEnv
-		funcEnv := &environ{
-			info:   closureEnv.info,
-			interp: closureEnv.interp,
-			scope:  funcScope,
-			parent: &closureEnv,
-			objs:   map[string]Object{},
+	// 2) Add parameters to environment with values from `in`
+	for j, param := range fn.params {
+		if param.slot >= 0 {
+			funcEnv.vars[param.slot] = param.newVar(in[j])
 		}
+	}
 
-		// 2) Add parameters to environment with values from `in`
-		for i := 0; i < funcParams.Len(); i++ {
-			// Add variable to environment for this param
-			param := funcParams.At(i)
-			funcEnv.addVar(param, nil, in[i])
+	// 3) Make the results with the zero value, adding named results to the environment.
+	// A return with values assigns them to these Objects, and a bare return leaves them
+	var results []Object
+	named := false
+	if len(fn.results) > 0 {
+		results = make([]Object, len(fn.results))
+		for j, result := range fn.results {
+			results[j] = result.zero()
+			if result.slot >= 0 {
+				funcEnv.vars[result.slot] = results[j]
+				named = true
+			}
 		}
+	}
 
-		// 3) If we have named result parameters, add those to the environment with zero value
-		// TODO: add named results
-		if funcResults.Len() > 0 {
-			results = make([]Object, funcResults.Len())
-			for i, _ := range results {
-				// Make an Object of the right type with the zero value.
-				// On return with values, we will assign given values to these Objects
-				results[i] = getObjectOfType(funcEnv.interp.typeMap, funcResults.At(i).Type())
+	// 4) Evaluate the body of the function (topLevel=false)
+	//     Note: If results are returned, handle them
+	fr := &frame{name: "func literal", entry: fn.lit.Pos()}
+	depth := th.push(fr)
+	defer func() {
+		// Deferred calls run when the function panics too, but not when the
+		// interpreter unwinds the thread to stop it
+		if len(fr.defers) > 0 {
+			if r := recover(); r != nil {
+				if _, ok := r.(unwind); !ok {
+					th.runDeferred(fr)
+				}
+				panic(r)
 			}
 		}
-
-		// 4) Evaluate the body of the function (topLevel=false)
-		//     Note: If results are returned, handle them
-		stmtRes := funcEnv.runStmt(funcLit.Body, "", false)
-		if res, ok := stmtRes.(returnResult); ok {
-			for i, resObj := range res {
-				assignObj(results[i], resObj)
+	}()
+	funcEnv.enterStmt(fn.lit.Body)
+	for _, stmt := range fn.body {
+		if stmtRes := stmt(funcEnv); stmtRes != nil {
+			if res, ok := stmtRes.(returnResult); ok {
+				if named && len(res) > 1 {
+					// The values may be those of the results themselves, as in return b, a
+					for j, resObj := range res {
+						res[j] = fn.results[j].newVar(resObj)
+					}
+				}
+				for j, resObj := range res {
+					assignObj(results[j], resObj)
+				}
 			}
+			break
 		}
-		return
 	}
+
+	// 5) Run the deferred calls, which may change named results before they are returned
+	th.runDeferred(fr)
+	th.popTo(depth)
+	return results
 }
 
-type visitor struct {
-	oldEnv, newEnv *environ
-	begin, end     token.Pos
+// A closure is the function made by evaluating a function literal, which runs
+// its body in an environment holding the variables it captured.
+type closure struct {
+	env *environ
+	fn  *funcLit
 }
 
-func (v *visitor) Visit(node ast.Node) (w ast.Visitor) {
-	if id, ok := node.(*ast.Ident); ok {
-		if obj, ok := v.oldEnv.info.Uses[id]; ok {
-			idPos := obj.Pos()
-			if idPos == 0 {
-				// Identifier is defined outside this package, so we don't care.
-				return nil
-			}
-			// Check for PkgName, as these don't count as "closed over"
-			if _, isPkgName := obj.(*types.PkgName); isPkgName {
-				// We're done visiting this node
-				return nil
-			}
-			if !(idPos > v.begin && idPos < v.end) {
-				// Add the closed-over variable to newEnv
-				if val, ok := v.oldEnv.lookupParent(id.Name); ok {
-					v.newEnv.objs[id.Name] = val
-				}
-			}
+// A closureRef holds the closure that is the value of an Object of function
+// type, so interpreted code can call it directly instead of through
+// reflect.MakeFunc. For a variable, it's shared by the copies of its Object and
+// follows assignments to it, until its address is taken and it may change
+// without the interpreter knowing.
+type closureRef struct {
+	cl      *closure
+	escaped int32 // accessed atomically
+}
+
+// closure returns the closure that is the value of obj, if it's known to be one.
+func (obj Object) closure() *closure {
+	if obj.fn == nil || atomic.LoadInt32(&obj.fn.escaped) != 0 {
+		return nil
+	}
+	return obj.fn.cl
+}
+
+// escape records that the variable obj may be set by compiled code.
+func (obj Object) escape() {
+	if obj.fn != nil {
+		atomic.StoreInt32(&obj.fn.escaped, 1)
+	}
+}
+
+// newClosureRef returns the closureRef for a new variable of type rtyp holding
+// the closure cl, or nil if rtyp isn't a function type.
+func newClosureRef(rtyp reflect.Type, cl *closure) *closureRef {
+	if rtyp.Kind() != reflect.Func {
+		return nil
+	}
+	return &closureRef{cl: cl}
+}
+
+// createUnsimulatedFunc returns a function of type rtyp that runs fn in closureEnv.
+// Interpreted code calls fn directly, so the function is only called by compiled code.
+func createUnsimulatedFunc(closureEnv *environ, fn *funcLit, rtyp reflect.Type) reflect.Value {
+	funcVal := func(in []reflect.Value) []reflect.Value {
+		// Run on the thread of whoever called us
+		th, leave := closureEnv.interp.currentThread()
+		defer leave()
+
+		// Parameters to unsimulated function type must be unsimulated
+		inObjs := make([]Object, len(in))
+		for j, v := range in {
+			inObjs[j] = Object{Value: v}
 		}
+		resultObjs := fn.call(closureEnv, th, inObjs)
+		results := make([]reflect.Value, len(resultObjs))
+		for j, obj := range resultObjs {
+			results[j] = obj.Value.(reflect.Value)
+		}
+		return results
+	}
+	return reflect.MakeFunc(rtyp, funcVal)
+}
+
+// A simulatedFunc is the value of a function whose type can't be made with
+// reflect, since some of its parameter or result types are simulated. It runs
+// on the thread of its caller.
+type simulatedFunc func(t *thread, in []Object) []Object
+
+func createSimulatedFunc(closureEnv *environ, fn *funcLit) simulatedFunc {
+	return func(th *thread, in []Object) []Object {
+		return fn.call(closureEnv, th, in)
 	}
-	return v
 }
 
-func newVisitor(oldEnv, newEnv *environ, funcLit *ast.FuncLit) ast.Visitor {
-	return &visitor{oldEnv: oldEnv, newEnv: newEnv, begin: funcLit.Pos(), end: funcLit.End()}
+// realFunc returns a function of type rtyp that calls the simulated function f,
+// for passing f to compiled code.
+func (i *interp) realFunc(f simulatedFunc, rtyp reflect.Type) reflect.Value {
+	return reflect.MakeFunc(rtyp, func(in []reflect.Value) []reflect.Value {
+		// Run on the thread of the goroutine compiled code called us on
+		th, leave := i.currentThread()
+		defer leave()
+
+		inObjs := make([]Object, len(in))
+		for j, v := range in {
+			inObjs[j] = Object{Value: v}
+		}
+		resultObjs := f(th, inObjs)
+		results := make([]reflect.Value, len(resultObjs))
+		for j, obj := range resultObjs {
+			results[j] = obj.Value.(reflect.Value)
+			if obj.Sim && rtyp.Out(j).Kind() == reflect.Func {
+				results[j] = i.realFunc(results[j].Interface().(simulatedFunc), rtyp.Out(j))
+			}
+		}
+		return results
+	})
 }
@@ -0,0 +1,54 @@
+package interp
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Interpreted code calls closures directly, so the closure called must follow
+// every way the variable holding it can change.
+func TestClosureVariables(t *testing.T) {
+	got := session(
+		"one := func() int { return 1 }",
+		"two := func() int { return 2 }",
+		"f := one",
+		"f()",
+		"f = two",
+		"f()",
+		"g := f",
+		"f = one",
+		"println(f(), g())",
+		"f, g = g, f",
+		"println(f(), g())",
+		"call := func(h func() int) int { return h() }",
+		"call(f)",
+		"p := &f",
+		"*p = one",
+		"f()",
+		"call(f)",
+	)
+	want := "=> 1 int\n=> 2 int\n1 2\n2 1\n=> 2 int\n=> 1 int\n=> 1 int\n"
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+// A closure in a variable the host sets through Lookup is replaced too.
+func TestClosureVariableSetByHost(t *testing.T) {
+	i := New(Options{})
+	if _, err := i.Eval("f := func() int { return 1 }"); err != nil {
+		t.Fatal(err)
+	}
+	v, ok := i.Lookup("f")
+	if !ok {
+		t.Fatal("f not found")
+	}
+	v.Set(reflect.ValueOf(func() int { return 2 }))
+	res, err := i.Eval("f()")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := formatObj(res[0]); got != "2" {
+		t.Errorf("f() = %s, want 2", got)
+	}
+}
@@ -0,0 +1,96 @@
+package interp
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"reflect"
+
+	"golang.org/x/tools/go/types"
+)
+
+// Values bound with Define are declared to the type checker as package-level
+// variables of the code being checked, initialized from exported variables of
+// the host package. That way their types never need to be written in source.
+const (
+	hostPkgPath = "goconsole/host"
+	hostPkgName = "goconsole_host_"
+)
+
+// hostVarName returns the name of the exported variable in the host package
+// holding the value bound to name.
+func hostVarName(name string) string {
+	return "X" + name
+}
+
+// writeHostDecls writes the declarations of the values bound with Define to buf.
+// It reports whether there were any.
+func (i *interp) writeHostDecls(buf *bytes.Buffer) bool {
+	names := i.hostEnv.getNames()
+	if len(names) == 0 {
+		return false
+	}
+	buf.WriteString("var(")
+	for _, name := range names {
+		fmt.Fprintf(buf, "%s=%s.%s;", name, hostPkgName, hostVarName(name))
+	}
+	buf.WriteString(");")
+	return true
+}
+
+func (i *interp) Define(name string, value interface{}) error {
+	if e, err := parser.ParseExpr(name); err != nil || name == "_" {
+		return fmt.Errorf("cannot define %q: not an identifier", name)
+	} else if _, ok := e.(*ast.Ident); !ok {
+		return fmt.Errorf("cannot define %q: not an identifier", name)
+	}
+	if _, ok := i.hostEnv.lookup(name); ok {
+		return fmt.Errorf("cannot define %s: already defined", name)
+	}
+	if _, ok := i.topEnv.lookup(name); ok {
+		// Code would go on using the variable it declared
+		return fmt.Errorf("cannot define %s: already declared at top level", name)
+	}
+	for _, pkg := range i.pkgs {
+		if pkg.Name == name {
+			return fmt.Errorf("cannot define %s: name of an imported package", name)
+		}
+	}
+	if value == nil {
+		return fmt.Errorf("cannot define %s as untyped nil", name)
+	}
+
+	rval := reflect.ValueOf(value)
+	typ, err := i.getCheckerType(rval.Type())
+	if err != nil {
+		return fmt.Errorf("cannot define %s: %v", name, err)
+	}
+	hostVar := types.NewVar(token.NoPos, i.hostPkg, hostVarName(name), typ)
+	i.hostPkg.Scope().Insert(hostVar)
+
+	// Keep our own copy of the value in a variable, like any other
+	val := reflect.New(rval.Type()).Elem()
+	val.Set(rval)
+	i.hostEnv.define(name, Object{
+		Value: val,
+		Typ:   typ,
+	})
+	return nil
+}
+
+func (i *interp) Lookup(name string) (reflect.Value, bool) {
+	obj, ok := i.topEnv.lookupParent(name)
+	if !ok {
+		return reflect.Value{}, false
+	}
+	// The caller may set it
+	obj.escape()
+	val, ok := obj.Value.(reflect.Value)
+	return val, ok
+}
+
+func (i *interp) Names() []string {
+	return i.topEnv.getNames()
+}
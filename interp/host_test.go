@@ -0,0 +1,51 @@
+package interp
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/tools/go/types"
+)
+
+func TestDefine(t *testing.T) {
+	i := New(Options{})
+	if err := i.Define("n", 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Define("n", 4); err == nil {
+		t.Errorf("defining n again: got no error")
+	}
+	if _, err := i.Eval("x := n + 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// x is the variable declared by code, which Define mustn't seem to change
+	if err := i.Define("x", 42); err == nil {
+		t.Errorf("defining x after declaring it: got no error")
+	}
+	results, err := i.Eval("x")
+	if err != nil || len(results) != 1 || results[0].Value.(reflect.Value).Interface() != 4 {
+		t.Errorf("x = %v, %v, want 4", results, err)
+	}
+}
+
+type hostPoint struct{ X, Y int }
+
+// A named type missing from the package the type checker has for its path is
+// declared elsewhere, leaving the package as it was.
+func TestDefineTypeMissingFromPackage(t *testing.T) {
+	path := reflect.TypeOf(hostPoint{}).PkgPath()
+	pkg := types.NewPackage(path, "interp")
+	pkg.MarkComplete()
+	i := New(Options{PkgMap: map[string]*types.Package{path: pkg}})
+	if err := i.Define("p", hostPoint{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if n := pkg.Scope().Len(); n != 0 {
+		t.Errorf("package has %d names after Define, want 0", n)
+	}
+	results, err := i.Eval("p.X + p.Y")
+	if err != nil || len(results) != 1 || results[0].Value.(reflect.Value).Interface() != 3 {
+		t.Errorf("p.X + p.Y = %v, %v, want 3", results, err)
+	}
+}
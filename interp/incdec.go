@@ -1,113 +1,44 @@
 package interp
 
-import "reflect"
+import (
+	"go/ast"
+	"go/token"
+	"reflect"
+)
 
-// doInc implements the IncDec statement for ++
-func doInc(obj Object) {
-	val := obj.Value.(reflect.Value)
-
-	switch val.Kind() {
-	case reflect.Int:
-		sum := int(val.Int()) + 1
-		val.SetInt(int64(sum))
-	case reflect.Int8:
-		sum := int8(val.Int()) + 1
-		val.SetInt(int64(sum))
-	case reflect.Int16:
-		sum := int16(val.Int()) + 1
-		val.SetInt(int64(sum))
-	case reflect.Int32:
-		sum := int32(val.Int()) + 1
-		val.SetInt(int64(sum))
-	case reflect.Int64:
-		sum := int64(val.Int()) + 1
-		val.SetInt(int64(sum))
-	case reflect.Uint:
-		sum := uint(val.Uint()) + 1
-		val.SetUint(uint64(sum))
-	case reflect.Uint8:
-		sum := uint8(val.Uint()) + 1
-		val.SetUint(uint64(sum))
-	case reflect.Uint16:
-		sum := uint16(val.Uint()) + 1
-		val.SetUint(uint64(sum))
-	case reflect.Uint32:
-		sum := uint32(val.Uint()) + 1
-		val.SetUint(uint64(sum))
-	case reflect.Uint64:
-		sum := uint64(val.Uint()) + 1
-		val.SetUint(uint64(sum))
-	case reflect.Uintptr:
-		sum := uintptr(val.Uint()) + 1
-		val.SetUint(uint64(sum))
-	case reflect.Float32:
-		sum := float32(val.Float()) + 1
-		val.SetFloat(float64(sum))
-	case reflect.Float64:
-		sum := float64(val.Float()) + 1
-		val.SetFloat(float64(sum))
-	case reflect.Complex64:
-		sum := complex64(val.Complex()) + 1
-		val.SetComplex(complex128(sum))
-	case reflect.Complex128:
-		sum := complex128(val.Complex()) + 1
-		val.SetComplex(complex128(sum))
+// incDec compiles an IncDec statement, which adds 1 to or subtracts 1 from the
+// value of its operand in place.
+func (c *compiler) incDec(stmt *ast.IncDecStmt) execFunc {
+	op := token.ADD
+	if stmt.Tok == token.DEC {
+		op = token.SUB
+	}
+	typ := c.info.TypeOf(stmt.X)
+	var incDec func(val reflect.Value)
+	switch class, kind := basicClassOf(typ); class {
+	case intClass:
+		f := intOp(op, kind)
+		incDec = func(val reflect.Value) { val.SetInt(f(val.Int(), 1)) }
+	case uintClass:
+		f := uintOp(op, kind)
+		incDec = func(val reflect.Value) { val.SetUint(f(val.Uint(), 1)) }
+	case floatClass:
+		f := floatOp(op, kind)
+		incDec = func(val reflect.Value) { val.SetFloat(f(val.Float(), 1)) }
+	case complexClass:
+		f := complexOp(op, kind)
+		incDec = func(val reflect.Value) { val.SetComplex(f(val.Complex(), 1)) }
 	default:
-		panic("Type error: Invalid operand to ++: " + TypeString(obj.Typ))
+		return c.execNotImplemented(stmt, "Invalid operand to %v: %s", stmt.Tok, TypeString(typ))
 	}
-}
 
-// doDec implements the IncDec statement for --
-func doDec(obj Object) {
-	val := obj.Value.(reflect.Value)
-
-	switch val.Kind() {
-	case reflect.Int:
-		sum := int(val.Int()) - 1
-		val.SetInt(int64(sum))
-	case reflect.Int8:
-		sum := int8(val.Int()) - 1
-		val.SetInt(int64(sum))
-	case reflect.Int16:
-		sum := int16(val.Int()) - 1
-		val.SetInt(int64(sum))
-	case reflect.Int32:
-		sum := int32(val.Int()) - 1
-		val.SetInt(int64(sum))
-	case reflect.Int64:
-		sum := int64(val.Int()) - 1
-		val.SetInt(int64(sum))
-	case reflect.Uint:
-		sum := uint(val.Uint()) - 1
-		val.SetUint(uint64(sum))
-	case reflect.Uint8:
-		sum := uint8(val.Uint()) - 1
-		val.SetUint(uint64(sum))
-	case reflect.Uint16:
-		sum := uint16(val.Uint()) - 1
-		val.SetUint(uint64(sum))
-	case reflect.Uint32:
-		sum := uint32(val.Uint()) - 1
-		val.SetUint(uint64(sum))
-	case reflect.Uint64:
-		sum := uint64(val.Uint()) - 1
-		val.SetUint(uint64(sum))
-	case reflect.Uintptr:
-		sum := uintptr(val.Uint()) - 1
-		val.SetUint(uint64(sum))
-	case reflect.Float32:
-		sum := float32(val.Float()) - 1
-		val.SetFloat(float64(sum))
-	case reflect.Float64:
-		sum := float64(val.Float()) - 1
-		val.SetFloat(float64(sum))
-	case reflect.Complex64:
-		sum := complex64(val.Complex()) - 1
-		val.SetComplex(complex128(sum))
-	case reflect.Complex128:
-		sum := complex128(val.Complex()) - 1
-		val.SetComplex(complex128(sum))
-	default:
-		panic("Type error: Invalid operand to ++: " + TypeString(obj.Typ))
+	x := c.expr(stmt.X)
+	return func(env *environ) stmtResult {
+		obj := x(env)
+		incDec(obj.Value.(reflect.Value))
+		if env.tracing() {
+			env.traceAssign([]ast.Expr{stmt.X}, []Object{obj})
+		}
+		return nil
 	}
 }
@@ -2,13 +2,18 @@ package interp
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"go/ast"
 	"go/parser"
 	"go/scanner"
 	"go/token"
+	"io"
 	"log"
+	"os"
+	"reflect"
 	"strings"
+	"sync"
 
 	"golang.org/x/tools/go/types"
 	"golang.org/x/tools/go/types/typeutil"
@@ -17,32 +22,159 @@ import (
 type interp struct {
 	oldSrc       string
 	topEnv       *environ
+	hostEnv      *environ
+	hostPkg      *types.Package
 	pkgs         map[string]*Package
 	checker      *checker
 	typeMap      *typeutil.Map
 	stmtLists    []string
 	stmtListLens []int
+	src          string // the input being run
+
+	// The types.Types of reflect.Types, the reverse of typeMap, built when first
+	// needed. Define and the Evaluator add to it while interpreted goroutines run
+	checkerTypesMu sync.Mutex
+	checkerTypes   map[reflect.Type]types.Type
+	hiddenPkgs     map[string]*types.Package // declaring named types missing from imported packages
+
+	// All inputs are parsed into fset, so that the positions of code from earlier
+	// inputs, like the bodies of functions they declared, stay valid
+	fset *token.FileSet
+
+	// Values of the top-level expression statements of the current input
+	results []Object
+
+	// Threads running interpreted code, by goroutine id, and the threads of
+	// goroutines started by go statements, by the id we gave them
+	threadsMu       sync.Mutex
+	threads         map[int64]*thread
+	goroutines      map[int64]*thread
+	lastGoroutineID int64
+
+	stdout io.Writer
+	stderr io.Writer
+	limits Limits
+	policy Policy
+
+	debugger debugger
+	tracer   tracer
+	profiler profiler
+	// Evaluates expressions where code stopped
+	evaluator *Evaluator
 }
 
-func newInterp(pkgs []*Package, pkgMap map[string]*types.Package, typeMap *typeutil.Map) Interpreter {
-	// Setup package map
+func newInterp(opts Options) *interp {
+	pkgs := opts.Packages
+	pkgMap := opts.PkgMap
+	if pkgMap == nil {
+		pkgMap = map[string]*types.Package{}
+	}
+	typeMap := opts.TypeMap
+	if typeMap == nil {
+		typeMap = new(typeutil.Map)
+	}
+
+	// Setup package map, keyed by path since a package may be imported under a local name
 	pkgObjMap := map[string]*Package{}
 	for _, pkg := range pkgs {
-		pkgObjMap[pkg.Name] = pkg
+		pkgObjMap[pkg.Pkg.Path()] = pkg
 	}
 	addBasicTypes(typeMap)
+
+	// Values bound with Define live in the host package as far as the type checker
+	// is concerned, and in hostEnv, the parent of topEnv, as far as we're concerned
+	hostPkg := types.NewPackage(hostPkgPath, hostPkgName)
+	hostPkg.MarkComplete()
+	pkgMap[hostPkgPath] = hostPkg
+
 	i := &interp{
 		pkgs: pkgObjMap,
-		topEnv: &environ{
+		hostEnv: &environ{
 			objs: map[string]Object{},
 		},
+		hostPkg: hostPkg,
 		checker: newChecker(pkgs, pkgMap),
 		typeMap: typeMap,
+		stdout:  opts.Stdout,
+		stderr:  opts.Stderr,
+		limits:  opts.Limits,
+		policy:  opts.Policy,
+		fset:    token.NewFileSet(),
+
+		threads:    map[int64]*thread{},
+		goroutines: map[int64]*thread{},
+	}
+	if i.stdout == nil {
+		i.stdout = os.Stdout
+	}
+	if i.stderr == nil {
+		i.stderr = os.Stderr
+	}
+	i.hostEnv.interp = i
+	i.debugger.breakpoints = map[int]bool{}
+	i.topEnv = &environ{
+		interp: i,
+		parent: i.hostEnv,
+		objs:   map[string]Object{},
 	}
-	i.topEnv.interp = i
 	return i
 }
 
+// runStmts runs the compiled top-level statements of an input in env, on a new
+// thread with the given context. If the context is done before they finish, it
+// returns the context's error.
+func (i *interp) runStmts(ctx context.Context, env *environ, code []execFunc) (err error) {
+	b := &budget{limits: i.limits}
+	parentCtx := ctx
+	if i.limits.Timeout > 0 {
+		// Goroutines started by this input are stopped at the deadline too,
+		// so the budget calls the cancel function once they're all done
+		ctx, b.cancel = context.WithTimeout(ctx, i.limits.Timeout)
+	}
+	b.start()
+	defer b.done()
+	th := &thread{
+		ctx:    ctx,
+		budget: b,
+		input:  true,
+	}
+	th.push(&frame{name: "top level"})
+	i.debugger.reset()
+	leave := i.enterThread(th)
+	defer leave()
+	env.thread = th
+	defer func() {
+		if i.profiling() {
+			i.profiler.leave(th)
+		}
+	}()
+
+	defer func() {
+		if r := recover(); r != nil {
+			u, ok := r.(unwind)
+			if !ok {
+				err = &PanicError{
+					Value: r,
+					Stack: th.stack(i.fset),
+				}
+				return
+			}
+			err = u.err
+			if err == context.DeadlineExceeded && parentCtx.Err() == nil {
+				// Our deadline, not the caller's
+				err = ErrTimeLimit
+			}
+		}
+	}()
+
+	// The statements can't return or branch out of the input, which
+	// checkTopLevel and the type checker rule out
+	for _, stmt := range code {
+		stmt(env)
+	}
+	return nil
+}
+
 type checker struct {
 	config types.Config
 	errs   []error
@@ -71,12 +203,35 @@ func newChecker(pkgs []*Package, pkgMap map[string]*types.Package) *checker {
 }
 
 func (i *interp) Run(src string) (bool, error) {
+	return i.RunContext(context.Background(), src)
+}
+
+func (i *interp) RunContext(ctx context.Context, src string) (bool, error) {
+	_, incomplete, err := i.run(ctx, src)
+	return incomplete, err
+}
+
+func (i *interp) Eval(src string) ([]Object, error) {
+	return i.EvalContext(context.Background(), src)
+}
+
+func (i *interp) EvalContext(ctx context.Context, src string) ([]Object, error) {
+	results, incomplete, err := i.run(ctx, src)
+	if incomplete {
+		return nil, ErrIncomplete
+	}
+	return results, err
+}
+
+// run runs the statements in src, returning the values of top-level expression
+// statements and whether src is incomplete.
+func (i *interp) run(ctx context.Context, src string) ([]Object, bool, error) {
 	src = strings.TrimSpace(src)
 	if len(src) == 0 {
 		if i.oldSrc == "" {
-			return false, nil
+			return nil, false, nil
 		}
-		return true, nil
+		return nil, true, nil
 	}
 
 	if i.oldSrc != "" {
@@ -84,20 +239,67 @@ func (i *interp) Run(src string) (bool, error) {
 		i.oldSrc = ""
 	}
 
+	in, incomplete, err := i.check(src)
+	if incomplete {
+		i.oldSrc = src
+		return nil, true, nil
+	}
+	if err != nil || in == nil {
+		return nil, false, err
+	}
+
+	i.topEnv.scope = in.scope
+	results, err := i.exec(ctx, i.topEnv, in)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Add current input to the stmtLists slice for next time
+	i.stmtLists = append(i.stmtLists, src)
+	i.stmtListLens = append(i.stmtListLens, len(in.stmts))
+
+	return results, false, nil
+}
+
+// An input is a type-checked input, compiled and ready to run.
+type input struct {
+	src   string
+	stmts []ast.Stmt
+	info  *types.Info
+	scope *types.Scope // the scope of the statements
+	code  []execFunc
+}
+
+// check parses and type checks src as the next input, after the inputs run so
+// far. It returns a nil input if src has no statements, and reports whether src
+// is incomplete.
+func (i *interp) check(src string) (*input, bool, error) {
 	// TODO: Only dump out declarations after each input rather than entire history of source.
 	var allSrcBuf bytes.Buffer
 	allSrcBuf.WriteString("package p;import(")
 	for _, pkg := range i.pkgs {
-		fmt.Fprintf(&allSrcBuf, "%q;", pkg.Pkg.Path())
+		fmt.Fprintf(&allSrcBuf, "%s %q;", pkg.Name, pkg.Pkg.Path())
+	}
+	fmt.Fprintf(&allSrcBuf, "%s %q;", hostPkgName, hostPkgPath)
+	allSrcBuf.WriteString(");")
+	numDecls := 2 // the imports and the function holding the code
+	if i.writeHostDecls(&allSrcBuf) {
+		numDecls++
 	}
-	allSrcBuf.WriteString(");func _(){")
+	allSrcBuf.WriteString("func _(){")
 
-	// Add previous code, one stmtList at a time, each in a nested scope
+	// Add previous code, one stmtList at a time, each in a nested scope. Line
+	// comments number the lines of the inputs as if they were one file named
+	// "input", so positions refer to lines of the session.
+	line := 1
 	for _, stmtList := range i.stmtLists {
+		fmt.Fprintf(&allSrcBuf, "\n//line input:%d\n", line)
 		allSrcBuf.WriteString(stmtList)
 		allSrcBuf.WriteString("\n{")
+		line += strings.Count(stmtList, "\n") + 1
 	}
 	// Add current code in the innermost scope and close the scopes
+	fmt.Fprintf(&allSrcBuf, "\n//line input:%d\n", line)
 	allSrcBuf.WriteString(src)
 	allSrcBuf.WriteString("\n")
 	for _ = range i.stmtLists {
@@ -110,7 +312,7 @@ func (i *interp) Run(src string) (bool, error) {
 	fileSize := len(allSrc)
 
 	// Parse it
-	fset := token.NewFileSet()
+	fset := i.fset
 	file, err := parser.ParseFile(fset, "input", allSrc, 0)
 	if err != nil {
 		if errList, ok := err.(scanner.ErrorList); ok {
@@ -120,21 +322,20 @@ func (i *interp) Run(src string) (bool, error) {
 					// If this is the first error, it actually just means the source is incomplete,
 					// unless there is a superfluous '}' at the end of their code
 					if j == 0 && err.Msg != "expected declaration, found '}'" {
-						i.oldSrc = src
-						return true, nil
+						return nil, true, nil
 					}
 				}
 			}
 		} else {
 			log.Fatal("Parsing yielded a non-nil error that's not a scanner.ErrorList")
 		}
-		return false, err
+		return nil, false, err
 	}
 
-	if len(file.Decls) != 2 {
+	if len(file.Decls) != numDecls {
 		// The input must have done something strange with braces
 		err := fmt.Errorf("Unexpected '}'")
-		return false, err
+		return nil, false, err
 	}
 
 	// Walk down the scopes to the inner statement list, checking that nothing
@@ -144,17 +345,17 @@ func (i *interp) Run(src string) (bool, error) {
 		if len(stmtList) != i.stmtListLens[j]+1 {
 			// There must be an extra closing brace that escaped our block statement
 			err := fmt.Errorf("Unexpected '}'")
-			return false, err
+			return nil, false, err
 		}
 		blockStmt, ok := stmtList[len(stmtList)-1].(*ast.BlockStmt)
 		if !ok {
 			err := fmt.Errorf("Parse error")
-			return false, err
+			return nil, false, err
 		}
 		stmtList = blockStmt.List
 	}
 	if len(stmtList) == 0 {
-		return false, nil
+		return nil, false, nil
 	}
 
 	// Clear the type-checker errors and create a struct to hold type info
@@ -164,12 +365,24 @@ func (i *interp) Run(src string) (bool, error) {
 		Selections: map[*ast.SelectorExpr]*types.Selection{},
 		Defs:       map[*ast.Ident]types.Object{},
 		Uses:       map[*ast.Ident]types.Object{},
+		Scopes:     map[ast.Node]*types.Scope{},
 	}
 	// Type check the statement list
 	files := []*ast.File{file}
 	pkg, _ := i.checker.config.Check("", fset, files, &info)
 	if len(i.checker.errs) > 0 {
-		return false, i.checker.errs[0]
+		return nil, false, i.checker.errs[0]
+	}
+	for _, stmt := range stmtList {
+		if err := i.checkPolicy(fset, &info, stmt); err != nil {
+			return nil, false, err
+		}
+		if err := checkConstants(fset, &info, stmt); err != nil {
+			return nil, false, err
+		}
+		if err := checkTopLevel(fset, stmt); err != nil {
+			return nil, false, err
+		}
 	}
 
 	// Walk down the scopes to the inner statement list, checking that nothing
@@ -180,21 +393,71 @@ func (i *interp) Run(src string) (bool, error) {
 	for _ = range i.stmtLists {
 		currScope = currScope.Child(currScope.NumChildren() - 1)
 	}
-	// get the scope of the block stmt containing user code
-	i.topEnv.scope = currScope
-	i.topEnv.info = &info
+	in := &input{
+		src:   src,
+		stmts: stmtList,
+		info:  &info,
+		scope: currScope,
+	}
+	code, err := i.compileInput(in)
+	if err != nil {
+		return nil, false, err
+	}
+	in.code = code
+	return in, false, nil
+}
 
-	// Run each statement in the list
-	for _, stmt := range stmtList {
-		stmtRes := i.topEnv.runStmt(stmt, "", true)
-		if stmtRes != nil {
-			log.Fatal("return from top level not allowed")
+// checkTopLevel returns an error for the first return or defer statement in
+// stmt that isn't in a function literal. The type checker allows them, since
+// inputs are checked in the body of a function.
+func checkTopLevel(fset *token.FileSet, stmt ast.Stmt) error {
+	var err error
+	ast.Inspect(stmt, func(n ast.Node) bool {
+		if err != nil {
+			return false
 		}
-	}
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.ReturnStmt:
+			err = types.Error{Fset: fset, Pos: n.Pos(), Msg: "return at top level not allowed"}
+		case *ast.DeferStmt:
+			err = types.Error{Fset: fset, Pos: n.Pos(), Msg: "defer at top level not allowed"}
+		}
+		return err == nil
+	})
+	return err
+}
 
-	// Add current input to the stmtLists slice for next time
-	i.stmtLists = append(i.stmtLists, src)
-	i.stmtListLens = append(i.stmtListLens, len(stmtList))
+// exec runs in in env, returning the values of its top-level expression statements.
+func (i *interp) exec(ctx context.Context, env *environ, in *input) ([]Object, error) {
+	i.results = nil
+	i.src = in.src
+	if err := i.runStmts(ctx, env, in.code); err != nil {
+		return nil, err
+	}
+	return i.results, nil
+}
 
-	return false, nil
+func (i *interp) Compile(src string) (func(ctx context.Context) ([]Object, error), error) {
+	in, incomplete, err := i.check(strings.TrimSpace(src))
+	if incomplete {
+		return nil, ErrIncomplete
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context) ([]Object, error) {
+		if in == nil {
+			return nil, nil
+		}
+		// Declarations go in an environment of their own, under the top level
+		env := &environ{
+			interp: i,
+			scope:  in.scope,
+			parent: i.topEnv,
+			objs:   map[string]Object{},
+		}
+		return i.exec(ctx, env, in)
+	}, nil
 }
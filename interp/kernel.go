@@ -0,0 +1,672 @@
+package interp
+
+import (
+	"go/ast"
+	"go/token"
+	"reflect"
+
+	"golang.org/x/tools/go/exact"
+	"golang.org/x/tools/go/types"
+)
+
+// Expressions of basic types that are operands of operators are compiled to
+// kernels, which evaluate them to unboxed values of their class (see
+// basicClass) instead of Objects. Whole trees of operations then run without
+// allocating, and their values are only boxed into Objects where they're used
+// as such.
+type (
+	intFunc     func(env *environ) int64
+	uintFunc    func(env *environ) uint64
+	floatFunc   func(env *environ) float64
+	complexFunc func(env *environ) complex128
+	stringFunc  func(env *environ) string
+	boolFunc    func(env *environ) bool
+)
+
+// binary compiles a binary expression.
+func (c *compiler) binary(e *ast.BinaryExpr) evalFunc {
+	typ := c.info.TypeOf(e)
+	switch e.Op {
+	case token.LSS, token.GTR, token.LEQ, token.GEQ, token.EQL, token.NEQ, token.LAND, token.LOR:
+		return c.boxBool(c.boolExpr(e), typ)
+	}
+	switch class, _ := basicClassOf(typ); class {
+	case intClass:
+		return c.boxInt(c.intExpr(e), typ)
+	case uintClass:
+		return c.boxUint(c.uintExpr(e), typ)
+	case floatClass:
+		return c.boxFloat(c.floatExpr(e), typ)
+	case complexClass:
+		return c.boxComplex(c.complexExpr(e), typ)
+	case stringClass:
+		return c.boxString(c.stringExpr(e), typ)
+	}
+	return c.notImplemented(e, "Binary operator %v not implemented yet", e.Op)
+}
+
+// intExpr compiles an expression of a signed integer type.
+func (c *compiler) intExpr(expr ast.Expr) intFunc {
+	tv := c.info.Types[expr]
+	if tv.Value != nil {
+		i, _ := exact.Int64Val(tv.Value)
+		return func(env *environ) int64 {
+			env.thread.step()
+			return i
+		}
+	}
+	switch e := expr.(type) {
+	case *ast.ParenExpr:
+		x := c.intExpr(e.X)
+		return func(env *environ) int64 {
+			env.thread.step()
+			return x(env)
+		}
+	case *ast.BinaryExpr:
+		_, kind := basicClassOf(tv.Type)
+		return c.intOperation(e.Op, kind, c.intExpr(e.X), e.Y)
+	}
+	return unboxInt(c.expr(expr))
+}
+
+// intOperation compiles the binary operation op on signed integers of the given
+// kind, from the code of its left operand and the expression of its right one.
+func (c *compiler) intOperation(op token.Token, kind types.BasicKind, x intFunc, yExpr ast.Expr) intFunc {
+	if op == token.SHL || op == token.SHR {
+		f := intShift(op, kind)
+		n := c.shiftCount(yExpr)
+		return func(env *environ) int64 {
+			env.thread.step()
+			return f(x(env), n(env))
+		}
+	}
+	f := intOp(op, kind)
+	if f == nil {
+		c.errorf(yExpr.Pos(), "Binary operator %v not implemented yet", op)
+		return nil
+	}
+	y := c.intExpr(yExpr)
+	return func(env *environ) int64 {
+		env.thread.step()
+		return f(x(env), y(env))
+	}
+}
+
+// uintExpr compiles an expression of an unsigned integer type.
+func (c *compiler) uintExpr(expr ast.Expr) uintFunc {
+	tv := c.info.Types[expr]
+	if tv.Value != nil {
+		u, _ := exact.Uint64Val(tv.Value)
+		return func(env *environ) uint64 {
+			env.thread.step()
+			return u
+		}
+	}
+	switch e := expr.(type) {
+	case *ast.ParenExpr:
+		x := c.uintExpr(e.X)
+		return func(env *environ) uint64 {
+			env.thread.step()
+			return x(env)
+		}
+	case *ast.BinaryExpr:
+		_, kind := basicClassOf(tv.Type)
+		return c.uintOperation(e.Op, kind, c.uintExpr(e.X), e.Y)
+	}
+	return unboxUint(c.expr(expr))
+}
+
+// uintOperation compiles the binary operation op on unsigned integers of the
+// given kind, from the code of its left operand and the expression of its right
+// one.
+func (c *compiler) uintOperation(op token.Token, kind types.BasicKind, x uintFunc, yExpr ast.Expr) uintFunc {
+	if op == token.SHL || op == token.SHR {
+		f := uintShift(op, kind)
+		n := c.shiftCount(yExpr)
+		return func(env *environ) uint64 {
+			env.thread.step()
+			return f(x(env), n(env))
+		}
+	}
+	f := uintOp(op, kind)
+	if f == nil {
+		c.errorf(yExpr.Pos(), "Binary operator %v not implemented yet", op)
+		return nil
+	}
+	y := c.uintExpr(yExpr)
+	return func(env *environ) uint64 {
+		env.thread.step()
+		return f(x(env), y(env))
+	}
+}
+
+// shiftCount compiles the count of a shift, which may be of a signed integer
+// type but mustn't be negative.
+func (c *compiler) shiftCount(expr ast.Expr) uintFunc {
+	tv := c.info.Types[expr]
+	if tv.Value != nil {
+		n, _ := exact.Uint64Val(exact.ToInt(tv.Value))
+		return func(env *environ) uint64 {
+			env.thread.step()
+			return n
+		}
+	}
+	if class, _ := basicClassOf(tv.Type); class == intClass {
+		x := c.intExpr(expr)
+		return func(env *environ) uint64 {
+			n := x(env)
+			if n < 0 {
+				panic("goconsole: negative shift amount")
+			}
+			return uint64(n)
+		}
+	}
+	return c.uintExpr(expr)
+}
+
+// floatExpr compiles an expression of a floating-point type.
+func (c *compiler) floatExpr(expr ast.Expr) floatFunc {
+	tv := c.info.Types[expr]
+	if tv.Value != nil {
+		f, _ := exact.Float64Val(tv.Value)
+		if _, kind := basicClassOf(tv.Type); kind == types.Float32 {
+			f32, _ := exact.Float32Val(tv.Value)
+			f = float64(f32)
+		}
+		return func(env *environ) float64 {
+			env.thread.step()
+			return f
+		}
+	}
+	switch e := expr.(type) {
+	case *ast.ParenExpr:
+		x := c.floatExpr(e.X)
+		return func(env *environ) float64 {
+			env.thread.step()
+			return x(env)
+		}
+	case *ast.BinaryExpr:
+		_, kind := basicClassOf(tv.Type)
+		return c.floatOperation(e.Op, kind, c.floatExpr(e.X), e.Y)
+	}
+	return unboxFloat(c.expr(expr))
+}
+
+// floatOperation compiles the binary operation op on floats of the given kind,
+// from the code of its left operand and the expression of its right one.
+func (c *compiler) floatOperation(op token.Token, kind types.BasicKind, x floatFunc, yExpr ast.Expr) floatFunc {
+	f := floatOp(op, kind)
+	if f == nil {
+		c.errorf(yExpr.Pos(), "Binary operator %v not implemented yet", op)
+		return nil
+	}
+	y := c.floatExpr(yExpr)
+	return func(env *environ) float64 {
+		env.thread.step()
+		return f(x(env), y(env))
+	}
+}
+
+// complexExpr compiles an expression of a complex type.
+func (c *compiler) complexExpr(expr ast.Expr) complexFunc {
+	tv := c.info.Types[expr]
+	if tv.Value != nil {
+		re, _ := exact.Float64Val(exact.Real(tv.Value))
+		im, _ := exact.Float64Val(exact.Imag(tv.Value))
+		z := complex(re, im)
+		return func(env *environ) complex128 {
+			env.thread.step()
+			return z
+		}
+	}
+	switch e := expr.(type) {
+	case *ast.ParenExpr:
+		x := c.complexExpr(e.X)
+		return func(env *environ) complex128 {
+			env.thread.step()
+			return x(env)
+		}
+	case *ast.BinaryExpr:
+		_, kind := basicClassOf(tv.Type)
+		return c.complexOperation(e.Op, kind, c.complexExpr(e.X), e.Y)
+	}
+	return unboxComplex(c.expr(expr))
+}
+
+// complexOperation compiles the binary operation op on complex numbers of the
+// given kind, from the code of its left operand and the expression of its right
+// one.
+func (c *compiler) complexOperation(op token.Token, kind types.BasicKind, x complexFunc, yExpr ast.Expr) complexFunc {
+	f := complexOp(op, kind)
+	if f == nil {
+		c.errorf(yExpr.Pos(), "Binary operator %v not implemented yet", op)
+		return nil
+	}
+	y := c.complexExpr(yExpr)
+	return func(env *environ) complex128 {
+		env.thread.step()
+		return f(x(env), y(env))
+	}
+}
+
+// stringExpr compiles an expression of a string type.
+func (c *compiler) stringExpr(expr ast.Expr) stringFunc {
+	tv := c.info.Types[expr]
+	if tv.Value != nil {
+		s := exact.StringVal(tv.Value)
+		return func(env *environ) string {
+			env.thread.step()
+			return s
+		}
+	}
+	switch e := expr.(type) {
+	case *ast.ParenExpr:
+		x := c.stringExpr(e.X)
+		return func(env *environ) string {
+			env.thread.step()
+			return x(env)
+		}
+	case *ast.BinaryExpr:
+		return c.stringOperation(e.Op, c.stringExpr(e.X), e.Y)
+	}
+	return unboxString(c.expr(expr))
+}
+
+// stringOperation compiles the binary operation op on strings, from the code of
+// its left operand and the expression of its right one.
+func (c *compiler) stringOperation(op token.Token, x stringFunc, yExpr ast.Expr) stringFunc {
+	f := stringOp(op)
+	if f == nil {
+		c.errorf(yExpr.Pos(), "Binary operator %v not implemented yet", op)
+		return nil
+	}
+	y := c.stringExpr(yExpr)
+	return func(env *environ) string {
+		env.thread.step()
+		return f(x(env), y(env))
+	}
+}
+
+// boolExpr compiles an expression of a boolean type.
+func (c *compiler) boolExpr(expr ast.Expr) boolFunc {
+	tv := c.info.Types[expr]
+	if tv.Value != nil {
+		b := exact.BoolVal(tv.Value)
+		return func(env *environ) bool {
+			env.thread.step()
+			return b
+		}
+	}
+	switch e := expr.(type) {
+	case *ast.ParenExpr:
+		x := c.boolExpr(e.X)
+		return func(env *environ) bool {
+			env.thread.step()
+			return x(env)
+		}
+	case *ast.BinaryExpr:
+		switch e.Op {
+		case token.LSS, token.GTR, token.LEQ, token.GEQ, token.EQL, token.NEQ:
+			return c.comparison(e)
+		case token.LAND:
+			x, y := c.boolExpr(e.X), c.boolExpr(e.Y)
+			return func(env *environ) bool {
+				env.thread.step()
+				return x(env) && y(env)
+			}
+		case token.LOR:
+			x, y := c.boolExpr(e.X), c.boolExpr(e.Y)
+			return func(env *environ) bool {
+				env.thread.step()
+				return x(env) || y(env)
+			}
+		}
+		c.errorf(e.OpPos, "Binary operator %v not implemented yet", e.Op)
+		return nil
+	case *ast.UnaryExpr:
+		if e.Op == token.NOT {
+			x := c.boolExpr(e.X)
+			return func(env *environ) bool {
+				env.thread.step()
+				return !x(env)
+			}
+		}
+	}
+	return unboxBool(c.expr(expr))
+}
+
+// comparison compiles a comparison. Operands of the same basic class are
+// compared unboxed; anything else can only be compared for equality or
+// inequality.
+func (c *compiler) comparison(e *ast.BinaryExpr) boolFunc {
+	op := e.Op
+	xClass, _ := basicClassOf(c.info.TypeOf(e.X))
+	yClass, _ := basicClassOf(c.info.TypeOf(e.Y))
+	if xClass == yClass {
+		switch xClass {
+		case intClass:
+			if f := intCmp(op); f != nil {
+				x, y := c.intExpr(e.X), c.intExpr(e.Y)
+				return func(env *environ) bool {
+					env.thread.step()
+					return f(x(env), y(env))
+				}
+			}
+		case uintClass:
+			if f := uintCmp(op); f != nil {
+				x, y := c.uintExpr(e.X), c.uintExpr(e.Y)
+				return func(env *environ) bool {
+					env.thread.step()
+					return f(x(env), y(env))
+				}
+			}
+		case floatClass:
+			if f := floatCmp(op); f != nil {
+				x, y := c.floatExpr(e.X), c.floatExpr(e.Y)
+				return func(env *environ) bool {
+					env.thread.step()
+					return f(x(env), y(env))
+				}
+			}
+		case complexClass:
+			if f := complexCmp(op); f != nil {
+				x, y := c.complexExpr(e.X), c.complexExpr(e.Y)
+				return func(env *environ) bool {
+					env.thread.step()
+					return f(x(env), y(env))
+				}
+			}
+		case stringClass:
+			if f := stringCmp(op); f != nil {
+				x, y := c.stringExpr(e.X), c.stringExpr(e.Y)
+				return func(env *environ) bool {
+					env.thread.step()
+					return f(x(env), y(env))
+				}
+			}
+		case boolClass:
+			if f := boolCmp(op); f != nil {
+				x, y := c.boolExpr(e.X), c.boolExpr(e.Y)
+				return func(env *environ) bool {
+					env.thread.step()
+					return f(x(env), y(env))
+				}
+			}
+		}
+	}
+	if op != token.EQL && op != token.NEQ {
+		c.errorf(e.OpPos, "Binary comparison operator %v not implemented for these operands", op)
+		return nil
+	}
+	x, y := c.expr(e.X), c.expr(e.Y)
+	neq := op == token.NEQ
+	return func(env *environ) bool {
+		env.thread.step()
+		left := x(env)
+		right := y(env)
+		return equalObjects(left, right) != neq
+	}
+}
+
+// The unbox functions evaluate an expression compiled to an Object as a kernel.
+// The value may be an untyped constant only for booleans, since untyped boolean
+// values such as the result of a comparison can be used as they are.
+
+func unboxInt(eval evalFunc) intFunc {
+	return func(env *environ) int64 {
+		return eval(env).Value.(reflect.Value).Int()
+	}
+}
+
+func unboxUint(eval evalFunc) uintFunc {
+	return func(env *environ) uint64 {
+		return eval(env).Value.(reflect.Value).Uint()
+	}
+}
+
+func unboxFloat(eval evalFunc) floatFunc {
+	return func(env *environ) float64 {
+		return eval(env).Value.(reflect.Value).Float()
+	}
+}
+
+func unboxComplex(eval evalFunc) complexFunc {
+	return func(env *environ) complex128 {
+		return eval(env).Value.(reflect.Value).Complex()
+	}
+}
+
+func unboxString(eval evalFunc) stringFunc {
+	return func(env *environ) string {
+		return eval(env).Value.(reflect.Value).String()
+	}
+}
+
+func unboxBool(eval evalFunc) boolFunc {
+	return func(env *environ) bool {
+		return isTrue(eval(env))
+	}
+}
+
+// The box functions compile a kernel of an expression of type typ to code
+// making an Object of its value. An untyped value gets its default type.
+
+func (c *compiler) boxInt(f intFunc, typ types.Type) evalFunc {
+	typ = typedBasic(typ)
+	rtyp, _ := getReflectType(c.interp.typeMap, typ)
+	return func(env *environ) Object {
+		v := reflect.New(rtyp).Elem()
+		v.SetInt(f(env))
+		return Object{Value: v, Typ: typ}
+	}
+}
+
+func (c *compiler) boxUint(f uintFunc, typ types.Type) evalFunc {
+	typ = typedBasic(typ)
+	rtyp, _ := getReflectType(c.interp.typeMap, typ)
+	return func(env *environ) Object {
+		v := reflect.New(rtyp).Elem()
+		v.SetUint(f(env))
+		return Object{Value: v, Typ: typ}
+	}
+}
+
+func (c *compiler) boxFloat(f floatFunc, typ types.Type) evalFunc {
+	typ = typedBasic(typ)
+	rtyp, _ := getReflectType(c.interp.typeMap, typ)
+	return func(env *environ) Object {
+		v := reflect.New(rtyp).Elem()
+		v.SetFloat(f(env))
+		return Object{Value: v, Typ: typ}
+	}
+}
+
+func (c *compiler) boxComplex(f complexFunc, typ types.Type) evalFunc {
+	typ = typedBasic(typ)
+	rtyp, _ := getReflectType(c.interp.typeMap, typ)
+	return func(env *environ) Object {
+		v := reflect.New(rtyp).Elem()
+		v.SetComplex(f(env))
+		return Object{Value: v, Typ: typ}
+	}
+}
+
+func (c *compiler) boxString(f stringFunc, typ types.Type) evalFunc {
+	typ = typedBasic(typ)
+	rtyp, _ := getReflectType(c.interp.typeMap, typ)
+	return func(env *environ) Object {
+		v := reflect.New(rtyp).Elem()
+		v.SetString(f(env))
+		return Object{Value: v, Typ: typ}
+	}
+}
+
+// boxBool is like the other box functions, but the two results are made once
+// and shared, since they can't be assigned to. An untyped result keeps its type.
+func (c *compiler) boxBool(f boolFunc, typ types.Type) evalFunc {
+	rtyp, _ := getReflectType(c.interp.typeMap, typedBasic(typ))
+	t := Object{Value: reflect.ValueOf(true).Convert(rtyp), Typ: typ}
+	fl := Object{Value: reflect.ValueOf(false).Convert(rtyp), Typ: typ}
+	return func(env *environ) Object {
+		if f(env) {
+			return t
+		}
+		return fl
+	}
+}
+
+// assignBasic compiles the assignment of rhs to the variable of the basic type
+// typ given by target, or the assignment operation op= if op isn't ILLEGAL. The
+// value is set in place, and the code returns the variable. It returns nil if
+// typ isn't a basic type.
+func (c *compiler) assignBasic(target evalFunc, typ types.Type, op token.Token, rhs ast.Expr) evalFunc {
+	class, kind := basicClassOf(typ)
+	if op == token.ILLEGAL {
+		switch class {
+		case intClass:
+			y := c.intExpr(rhs)
+			return func(env *environ) Object {
+				lObj := target(env)
+				lObj.Value.(reflect.Value).SetInt(y(env))
+				return lObj
+			}
+		case uintClass:
+			y := c.uintExpr(rhs)
+			return func(env *environ) Object {
+				lObj := target(env)
+				lObj.Value.(reflect.Value).SetUint(y(env))
+				return lObj
+			}
+		case floatClass:
+			y := c.floatExpr(rhs)
+			return func(env *environ) Object {
+				lObj := target(env)
+				lObj.Value.(reflect.Value).SetFloat(y(env))
+				return lObj
+			}
+		case complexClass:
+			y := c.complexExpr(rhs)
+			return func(env *environ) Object {
+				lObj := target(env)
+				lObj.Value.(reflect.Value).SetComplex(y(env))
+				return lObj
+			}
+		case stringClass:
+			y := c.stringExpr(rhs)
+			return func(env *environ) Object {
+				lObj := target(env)
+				lObj.Value.(reflect.Value).SetString(y(env))
+				return lObj
+			}
+		case boolClass:
+			y := c.boolExpr(rhs)
+			return func(env *environ) Object {
+				lObj := target(env)
+				lObj.Value.(reflect.Value).SetBool(y(env))
+				return lObj
+			}
+		}
+		return nil
+	}
+
+	// The right-hand side is evaluated before the variable's value is read
+	switch class {
+	case intClass:
+		if op == token.SHL || op == token.SHR {
+			f := intShift(op, kind)
+			n := c.shiftCount(rhs)
+			return func(env *environ) Object {
+				lObj := target(env)
+				l := lObj.Value.(reflect.Value)
+				r := n(env)
+				l.SetInt(f(l.Int(), r))
+				return lObj
+			}
+		}
+		if f := intOp(op, kind); f != nil {
+			y := c.intExpr(rhs)
+			return func(env *environ) Object {
+				lObj := target(env)
+				l := lObj.Value.(reflect.Value)
+				r := y(env)
+				l.SetInt(f(l.Int(), r))
+				return lObj
+			}
+		}
+	case uintClass:
+		if op == token.SHL || op == token.SHR {
+			f := uintShift(op, kind)
+			n := c.shiftCount(rhs)
+			return func(env *environ) Object {
+				lObj := target(env)
+				l := lObj.Value.(reflect.Value)
+				r := n(env)
+				l.SetUint(f(l.Uint(), r))
+				return lObj
+			}
+		}
+		if f := uintOp(op, kind); f != nil {
+			y := c.uintExpr(rhs)
+			return func(env *environ) Object {
+				lObj := target(env)
+				l := lObj.Value.(reflect.Value)
+				r := y(env)
+				l.SetUint(f(l.Uint(), r))
+				return lObj
+			}
+		}
+	case floatClass:
+		if f := floatOp(op, kind); f != nil {
+			y := c.floatExpr(rhs)
+			return func(env *environ) Object {
+				lObj := target(env)
+				l := lObj.Value.(reflect.Value)
+				r := y(env)
+				l.SetFloat(f(l.Float(), r))
+				return lObj
+			}
+		}
+	case complexClass:
+		if f := complexOp(op, kind); f != nil {
+			y := c.complexExpr(rhs)
+			return func(env *environ) Object {
+				lObj := target(env)
+				l := lObj.Value.(reflect.Value)
+				r := y(env)
+				l.SetComplex(f(l.Complex(), r))
+				return lObj
+			}
+		}
+	case stringClass:
+		if f := stringOp(op); f != nil {
+			y := c.stringExpr(rhs)
+			return func(env *environ) Object {
+				lObj := target(env)
+				l := lObj.Value.(reflect.Value)
+				r := y(env)
+				l.SetString(f(l.String(), r))
+				return lObj
+			}
+		}
+	}
+	return nil
+}
+
+// operation compiles the binary operation op on the value of a variable of type
+// typ, given by x, and rhs, for an assignment operation on a map element, which
+// can't be set in place.
+func (c *compiler) operation(op token.Token, typ types.Type, x evalFunc, rhs ast.Expr) evalFunc {
+	switch class, kind := basicClassOf(typ); class {
+	case intClass:
+		return c.boxInt(c.intOperation(op, kind, unboxInt(x), rhs), typ)
+	case uintClass:
+		return c.boxUint(c.uintOperation(op, kind, unboxUint(x), rhs), typ)
+	case floatClass:
+		return c.boxFloat(c.floatOperation(op, kind, unboxFloat(x), rhs), typ)
+	case complexClass:
+		return c.boxComplex(c.complexOperation(op, kind, unboxComplex(x), rhs), typ)
+	case stringClass:
+		return c.boxString(c.stringOperation(op, unboxString(x), rhs), typ)
+	}
+	return c.notImplemented(rhs, "Binary operator %v not implemented yet", op)
+}
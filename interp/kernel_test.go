@@ -0,0 +1,32 @@
+package interp
+
+import (
+	"strings"
+	"testing"
+)
+
+// Integer operations wrap around, shift out every bit for counts of at least
+// the width, and panic on division by zero, as in Go.
+func TestIntegerKernels(t *testing.T) {
+	got := session(
+		"a, b, c := int8(127), uint8(0), int8(-128)",
+		"a + 1", "a * 2", "b - 1", "b - 2", "c / -1", "c - 1",
+		"s := uint(8)",
+		"b = 1",
+		"b << s", "a >> s", "c >> s", "b << (s - 1)",
+		"x, m := 1, -1",
+		"s = 70",
+		"x << s", "m >> s",
+		"z := 0",
+		"x / z",
+		"x % z",
+		"b / uint8(z)",
+	)
+	want := "=> -128 int8\n=> -2 int8\n=> 255 uint8\n=> 254 uint8\n=> -128 int8\n=> 127 int8\n" +
+		"=> 0 uint8\n=> 0 int8\n=> -1 int8\n=> 128 uint8\n" +
+		"=> 0 int\n=> -1 int\n" +
+		strings.Repeat("error: panic: runtime error: integer divide by zero\n", 3)
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"go/token"
 	"log"
 	"reflect"
+	"strconv"
 
 	"golang.org/x/tools/go/exact"
 	"golang.org/x/tools/go/types"
@@ -27,56 +28,339 @@ var assignOps = map[token.Token]token.Token{
 	token.XOR_ASSIGN:     token.XOR,
 }
 
-func doBinaryOp(env *environ, left, right Object, op token.Token) Object {
-	var obj Object
+// Operators on basic types are carried out on unboxed Go values: int64 for
+// signed integers, uint64 for unsigned ones, float64, complex128, string and
+// bool. The function for an operator is chosen when the code is compiled, from
+// the operator and the kind of its operands' type, and results are wrapped
+// around or rounded to the size of that kind.
+type basicClass int
+
+const (
+	notBasic basicClass = iota
+	intClass
+	uintClass
+	floatClass
+	complexClass
+	stringClass
+	boolClass
+)
+
+// basicClassOf returns the class of the values of typ, and the kind of the basic
+// type they have. Untyped types have the kind of their default type.
+func basicClassOf(typ types.Type) (basicClass, types.BasicKind) {
+	t, ok := typ.Underlying().(*types.Basic)
+	if !ok {
+		return notBasic, types.Invalid
+	}
+	kind := typedBasic(t).(*types.Basic).Kind()
+	info := t.Info()
+	switch {
+	case info&types.IsBoolean != 0:
+		return boolClass, kind
+	case info&types.IsInteger != 0 && info&types.IsUnsigned != 0:
+		return uintClass, kind
+	case info&types.IsInteger != 0:
+		return intClass, kind
+	case info&types.IsFloat != 0:
+		return floatClass, kind
+	case info&types.IsComplex != 0:
+		return complexClass, kind
+	case info&types.IsString != 0:
+		return stringClass, kind
+	}
+	return notBasic, kind
+}
+
+// typedBasic returns the default type of typ if it's untyped, or typ.
+func typedBasic(typ types.Type) types.Type {
+	if isTyped(typ) {
+		return typ
+	}
+	switch typ.Underlying().(*types.Basic).Kind() {
+	case types.UntypedBool:
+		return types.Typ[types.Bool]
+	case types.UntypedInt:
+		return types.Typ[types.Int]
+	case types.UntypedRune:
+		return types.Typ[types.Rune]
+	case types.UntypedFloat:
+		return types.Typ[types.Float64]
+	case types.UntypedComplex:
+		return types.Typ[types.Complex128]
+	case types.UntypedString:
+		return types.Typ[types.String]
+	}
+	return typ
+}
+
+// intOp returns the binary operator op on signed integers of the given kind.
+func intOp(op token.Token, kind types.BasicKind) func(x, y int64) int64 {
+	var f func(x, y int64) int64
 	switch op {
 	case token.ADD:
-		obj = operatorAdd(env, left, right)
+		f = func(x, y int64) int64 { return x + y }
 	case token.SUB:
-		obj = operatorSubtract(env, left, right)
+		f = func(x, y int64) int64 { return x - y }
 	case token.MUL:
-		obj = operatorMultiply(env, left, right)
+		f = func(x, y int64) int64 { return x * y }
 	case token.QUO:
-		obj = operatorQuotient(env, left, right)
+		f = func(x, y int64) int64 { return x / y }
 	case token.REM:
-		obj = operatorRemainder(env, left, right)
+		f = func(x, y int64) int64 { return x % y }
 	case token.AND:
-		obj = operatorAnd(env, left, right)
+		f = func(x, y int64) int64 { return x & y }
 	case token.OR:
-		obj = operatorOr(env, left, right)
+		f = func(x, y int64) int64 { return x | y }
 	case token.XOR:
-		obj = operatorXor(env, left, right)
+		f = func(x, y int64) int64 { return x ^ y }
 	case token.AND_NOT:
-		obj = operatorAndNot(env, left, right)
-	case token.SHR:
-		obj = operatorShiftRight(env, left, right)
-	case token.SHL:
-		obj = operatorShiftLeft(env, left, right)
+		f = func(x, y int64) int64 { return x &^ y }
 	default:
-		// TODO: Implement other binary operators
-		log.Fatalf("Binary operator %v not implemented yet", op)
+		return nil
 	}
-	return obj
+	if wrap := wrapInt(kind); wrap != nil {
+		return func(x, y int64) int64 { return wrap(f(x, y)) }
+	}
+	return f
+}
+
+// intShift returns the shift operator op on signed integers of the given kind.
+func intShift(op token.Token, kind types.BasicKind) func(x int64, n uint64) int64 {
+	f := func(x int64, n uint64) int64 { return x >> n }
+	if op == token.SHL {
+		f = func(x int64, n uint64) int64 { return x << n }
+	}
+	if wrap := wrapInt(kind); wrap != nil {
+		return func(x int64, n uint64) int64 { return wrap(f(x, n)) }
+	}
+	return f
+}
+
+// wrapInt returns the function wrapping an int64 around to a signed integer of
+// the given kind, or nil if there's nothing to do.
+func wrapInt(kind types.BasicKind) func(x int64) int64 {
+	switch kind {
+	case types.Int8:
+		return func(x int64) int64 { return int64(int8(x)) }
+	case types.Int16:
+		return func(x int64) int64 { return int64(int16(x)) }
+	case types.Int32:
+		return func(x int64) int64 { return int64(int32(x)) }
+	case types.Int:
+		if strconv.IntSize == 32 {
+			return func(x int64) int64 { return int64(int32(x)) }
+		}
+	}
+	return nil
+}
+
+// uintOp returns the binary operator op on unsigned integers of the given kind.
+func uintOp(op token.Token, kind types.BasicKind) func(x, y uint64) uint64 {
+	var f func(x, y uint64) uint64
+	switch op {
+	case token.ADD:
+		f = func(x, y uint64) uint64 { return x + y }
+	case token.SUB:
+		f = func(x, y uint64) uint64 { return x - y }
+	case token.MUL:
+		f = func(x, y uint64) uint64 { return x * y }
+	case token.QUO:
+		f = func(x, y uint64) uint64 { return x / y }
+	case token.REM:
+		f = func(x, y uint64) uint64 { return x % y }
+	case token.AND:
+		f = func(x, y uint64) uint64 { return x & y }
+	case token.OR:
+		f = func(x, y uint64) uint64 { return x | y }
+	case token.XOR:
+		f = func(x, y uint64) uint64 { return x ^ y }
+	case token.AND_NOT:
+		f = func(x, y uint64) uint64 { return x &^ y }
+	default:
+		return nil
+	}
+	if wrap := wrapUint(kind); wrap != nil {
+		return func(x, y uint64) uint64 { return wrap(f(x, y)) }
+	}
+	return f
+}
+
+// uintShift returns the shift operator op on unsigned integers of the given kind.
+func uintShift(op token.Token, kind types.BasicKind) func(x, n uint64) uint64 {
+	f := func(x, n uint64) uint64 { return x >> n }
+	if op == token.SHL {
+		f = func(x, n uint64) uint64 { return x << n }
+	}
+	if wrap := wrapUint(kind); wrap != nil {
+		return func(x, n uint64) uint64 { return wrap(f(x, n)) }
+	}
+	return f
+}
+
+// wrapUint returns the function wrapping a uint64 around to an unsigned integer
+// of the given kind, or nil if there's nothing to do.
+func wrapUint(kind types.BasicKind) func(x uint64) uint64 {
+	switch kind {
+	case types.Uint8:
+		return func(x uint64) uint64 { return uint64(uint8(x)) }
+	case types.Uint16:
+		return func(x uint64) uint64 { return uint64(uint16(x)) }
+	case types.Uint32:
+		return func(x uint64) uint64 { return uint64(uint32(x)) }
+	case types.Uint, types.Uintptr:
+		if strconv.IntSize == 32 {
+			return func(x uint64) uint64 { return uint64(uint32(x)) }
+		}
+	}
+	return nil
+}
+
+// floatOp returns the binary operator op on floats of the given kind.
+func floatOp(op token.Token, kind types.BasicKind) func(x, y float64) float64 {
+	var f func(x, y float64) float64
+	switch op {
+	case token.ADD:
+		f = func(x, y float64) float64 { return x + y }
+	case token.SUB:
+		f = func(x, y float64) float64 { return x - y }
+	case token.MUL:
+		f = func(x, y float64) float64 { return x * y }
+	case token.QUO:
+		f = func(x, y float64) float64 { return x / y }
+	default:
+		return nil
+	}
+	if kind == types.Float32 {
+		return func(x, y float64) float64 { return float64(float32(f(x, y))) }
+	}
+	return f
 }
 
-func doBinaryComparisonOp(env *environ, left, right Object, op token.Token, typ types.Type) Object {
-	var obj Object
+// complexOp returns the binary operator op on complex numbers of the given kind.
+func complexOp(op token.Token, kind types.BasicKind) func(x, y complex128) complex128 {
+	var f func(x, y complex128) complex128
+	switch op {
+	case token.ADD:
+		f = func(x, y complex128) complex128 { return x + y }
+	case token.SUB:
+		f = func(x, y complex128) complex128 { return x - y }
+	case token.MUL:
+		f = func(x, y complex128) complex128 { return x * y }
+	case token.QUO:
+		f = func(x, y complex128) complex128 { return x / y }
+	default:
+		return nil
+	}
+	if kind == types.Complex64 {
+		return func(x, y complex128) complex128 { return complex128(complex64(f(x, y))) }
+	}
+	return f
+}
+
+// stringOp returns the binary operator op on strings.
+func stringOp(op token.Token) func(x, y string) string {
+	if op == token.ADD {
+		return func(x, y string) string { return x + y }
+	}
+	return nil
+}
+
+// intCmp returns the comparison operator op on signed integers.
+func intCmp(op token.Token) func(x, y int64) bool {
 	switch op {
 	case token.LSS:
-		obj = operatorLess(env, left, right, typ)
+		return func(x, y int64) bool { return x < y }
 	case token.GTR:
-		obj = operatorGreater(env, left, right, typ)
+		return func(x, y int64) bool { return x > y }
 	case token.LEQ:
-		obj = operatorLessEqual(env, left, right, typ)
+		return func(x, y int64) bool { return x <= y }
 	case token.GEQ:
-		obj = operatorGreaterEqual(env, left, right, typ)
+		return func(x, y int64) bool { return x >= y }
 	case token.EQL:
-		obj = operatorEqual(env, left, right, typ)
-	default:
-		// TODO: Implement other binary operators
-		log.Fatalf("Binary comparison operator %v not implemented yet", op)
+		return func(x, y int64) bool { return x == y }
+	case token.NEQ:
+		return func(x, y int64) bool { return x != y }
 	}
-	return obj
+	return nil
+}
+
+// uintCmp returns the comparison operator op on unsigned integers.
+func uintCmp(op token.Token) func(x, y uint64) bool {
+	switch op {
+	case token.LSS:
+		return func(x, y uint64) bool { return x < y }
+	case token.GTR:
+		return func(x, y uint64) bool { return x > y }
+	case token.LEQ:
+		return func(x, y uint64) bool { return x <= y }
+	case token.GEQ:
+		return func(x, y uint64) bool { return x >= y }
+	case token.EQL:
+		return func(x, y uint64) bool { return x == y }
+	case token.NEQ:
+		return func(x, y uint64) bool { return x != y }
+	}
+	return nil
+}
+
+// floatCmp returns the comparison operator op on floats.
+func floatCmp(op token.Token) func(x, y float64) bool {
+	switch op {
+	case token.LSS:
+		return func(x, y float64) bool { return x < y }
+	case token.GTR:
+		return func(x, y float64) bool { return x > y }
+	case token.LEQ:
+		return func(x, y float64) bool { return x <= y }
+	case token.GEQ:
+		return func(x, y float64) bool { return x >= y }
+	case token.EQL:
+		return func(x, y float64) bool { return x == y }
+	case token.NEQ:
+		return func(x, y float64) bool { return x != y }
+	}
+	return nil
+}
+
+// stringCmp returns the comparison operator op on strings.
+func stringCmp(op token.Token) func(x, y string) bool {
+	switch op {
+	case token.LSS:
+		return func(x, y string) bool { return x < y }
+	case token.GTR:
+		return func(x, y string) bool { return x > y }
+	case token.LEQ:
+		return func(x, y string) bool { return x <= y }
+	case token.GEQ:
+		return func(x, y string) bool { return x >= y }
+	case token.EQL:
+		return func(x, y string) bool { return x == y }
+	case token.NEQ:
+		return func(x, y string) bool { return x != y }
+	}
+	return nil
+}
+
+// complexCmp returns the comparison operator op on complex numbers.
+func complexCmp(op token.Token) func(x, y complex128) bool {
+	switch op {
+	case token.EQL:
+		return func(x, y complex128) bool { return x == y }
+	case token.NEQ:
+		return func(x, y complex128) bool { return x != y }
+	}
+	return nil
+}
+
+// boolCmp returns the comparison operator op on booleans.
+func boolCmp(op token.Token) func(x, y bool) bool {
+	switch op {
+	case token.EQL:
+		return func(x, y bool) bool { return x == y }
+	case token.NEQ:
+		return func(x, y bool) bool { return x != y }
+	}
+	return nil
 }
 
 func getTypedObject(obj Object) Object {
@@ -131,880 +415,10 @@ func getTypedObject(obj Object) Object {
 	return obj
 }
 
-// operatorAdd implements the binary operation '+'.
-// If this is being called at all, then the left and right objects
-// have a value that's a "reflect.Value". Also, both have the same type,
-// since the expression passed type checking.
-func operatorAdd(env *environ, left, right Object) Object {
-	left = getTypedObject(left)
-	right = getTypedObject(right)
-	lv := left.Value.(reflect.Value)
-	rv := right.Value.(reflect.Value)
-
-	newTyp := left.Typ
-	newRtyp, _ := getReflectType(env.interp.typeMap, newTyp)
-	if newRtyp == nil {
-		log.Fatal("operatorAdd: Couldn't get reflect.Type from types.Type")
-	}
-	newVal := getSettableZeroVal(newRtyp)
-
-	switch lv.Kind() {
-	case reflect.Int:
-		sum := int(lv.Int()) + int(rv.Int())
-		newVal.SetInt(int64(sum))
-	case reflect.Int8:
-		sum := int8(lv.Int()) + int8(rv.Int())
-		newVal.SetInt(int64(sum))
-	case reflect.Int16:
-		sum := int16(lv.Int()) + int16(rv.Int())
-		newVal.SetInt(int64(sum))
-	case reflect.Int32:
-		sum := int32(lv.Int()) + int32(rv.Int())
-		newVal.SetInt(int64(sum))
-	case reflect.Int64:
-		sum := int64(lv.Int()) + int64(rv.Int())
-		newVal.SetInt(int64(sum))
-	case reflect.Uint:
-		sum := uint(lv.Uint()) + uint(rv.Uint())
-		newVal.SetUint(uint64(sum))
-	case reflect.Uint8:
-		sum := uint8(lv.Uint()) + uint8(rv.Uint())
-		newVal.SetUint(uint64(sum))
-	case reflect.Uint16:
-		sum := uint16(lv.Uint()) + uint16(rv.Uint())
-		newVal.SetUint(uint64(sum))
-	case reflect.Uint32:
-		sum := uint32(lv.Uint()) + uint32(rv.Uint())
-		newVal.SetUint(uint64(sum))
-	case reflect.Uint64:
-		sum := uint64(lv.Uint()) + uint64(rv.Uint())
-		newVal.SetUint(uint64(sum))
-	case reflect.Uintptr:
-		sum := uintptr(lv.Uint()) + uintptr(rv.Uint())
-		newVal.SetUint(uint64(sum))
-	case reflect.Float32:
-		sum := float32(lv.Float()) + float32(rv.Float())
-		newVal.SetFloat(float64(sum))
-	case reflect.Float64:
-		sum := float64(lv.Float()) + float64(rv.Float())
-		newVal.SetFloat(float64(sum))
-	case reflect.Complex64:
-		sum := complex64(lv.Complex()) + complex64(rv.Complex())
-		newVal.SetComplex(complex128(sum))
-	case reflect.Complex128:
-		sum := complex128(lv.Complex()) + complex128(rv.Complex())
-		newVal.SetComplex(complex128(sum))
-	case reflect.String:
-		sum := lv.String() + rv.String()
-		newVal.SetString(sum)
-	default:
-		panic("Type error: Invalid operands to addition: " + TypeString(left.Typ) + ", " + TypeString(right.Typ))
-	}
-
-	return Object{
-		Value: newVal,
-		Typ:   newTyp,
-	}
-}
-
-// operatorSubtract implements the binary operation '-'.
-func operatorSubtract(env *environ, left, right Object) Object {
-	left = getTypedObject(left)
-	right = getTypedObject(right)
-	lv := left.Value.(reflect.Value)
-	rv := right.Value.(reflect.Value)
-
-	newTyp := left.Typ
-	newRtyp, _ := getReflectType(env.interp.typeMap, newTyp)
-	if newRtyp == nil {
-		log.Fatal("operatorSubtract: Couldn't get reflect.Type from types.Type")
-	}
-	newVal := getSettableZeroVal(newRtyp)
-
-	switch lv.Kind() {
-	case reflect.Int:
-		diff := int(lv.Int()) - int(rv.Int())
-		newVal.SetInt(int64(diff))
-	case reflect.Int8:
-		diff := int8(lv.Int()) - int8(rv.Int())
-		newVal.SetInt(int64(diff))
-	case reflect.Int16:
-		diff := int16(lv.Int()) - int16(rv.Int())
-		newVal.SetInt(int64(diff))
-	case reflect.Int32:
-		diff := int32(lv.Int()) - int32(rv.Int())
-		newVal.SetInt(int64(diff))
-	case reflect.Int64:
-		diff := int64(lv.Int()) - int64(rv.Int())
-		newVal.SetInt(int64(diff))
-	case reflect.Uint:
-		diff := uint(lv.Uint()) - uint(rv.Uint())
-		newVal.SetUint(uint64(diff))
-	case reflect.Uint8:
-		diff := uint8(lv.Uint()) - uint8(rv.Uint())
-		newVal.SetUint(uint64(diff))
-	case reflect.Uint16:
-		diff := uint16(lv.Uint()) - uint16(rv.Uint())
-		newVal.SetUint(uint64(diff))
-	case reflect.Uint32:
-		diff := uint32(lv.Uint()) - uint32(rv.Uint())
-		newVal.SetUint(uint64(diff))
-	case reflect.Uint64:
-		diff := uint64(lv.Uint()) - uint64(rv.Uint())
-		newVal.SetUint(uint64(diff))
-	case reflect.Uintptr:
-		diff := uintptr(lv.Uint()) - uintptr(rv.Uint())
-		newVal.SetUint(uint64(diff))
-	case reflect.Float32:
-		diff := float32(lv.Float()) - float32(rv.Float())
-		newVal.SetFloat(float64(diff))
-	case reflect.Float64:
-		diff := float64(lv.Float()) - float64(rv.Float())
-		newVal.SetFloat(float64(diff))
-	case reflect.Complex64:
-		diff := complex64(lv.Complex()) - complex64(rv.Complex())
-		newVal.SetComplex(complex128(diff))
-	case reflect.Complex128:
-		diff := complex128(lv.Complex()) - complex128(rv.Complex())
-		newVal.SetComplex(complex128(diff))
-	default:
-		panic("Type error: Invalid operands to subtraction: " + TypeString(left.Typ) + ", " + TypeString(right.Typ))
-	}
-	return Object{
-		Value: newVal,
-		Typ:   newTyp,
-	}
-}
-
-// operatorMultiply implements the binary operation '*'.
-func operatorMultiply(env *environ, left, right Object) Object {
-	left = getTypedObject(left)
-	right = getTypedObject(right)
-	lv := left.Value.(reflect.Value)
-	rv := right.Value.(reflect.Value)
-
-	newTyp := left.Typ
-	newRtyp, _ := getReflectType(env.interp.typeMap, newTyp)
-	if newRtyp == nil {
-		log.Fatal("operatorMultiply: Couldn't get reflect.Type from types.Type")
-	}
-	newVal := getSettableZeroVal(newRtyp)
-
-	switch lv.Kind() {
-	case reflect.Int:
-		prod := int(lv.Int()) * int(rv.Int())
-		newVal.SetInt(int64(prod))
-	case reflect.Int8:
-		prod := int8(lv.Int()) * int8(rv.Int())
-		newVal.SetInt(int64(prod))
-	case reflect.Int16:
-		prod := int16(lv.Int()) * int16(rv.Int())
-		newVal.SetInt(int64(prod))
-	case reflect.Int32:
-		prod := int32(lv.Int()) * int32(rv.Int())
-		newVal.SetInt(int64(prod))
-	case reflect.Int64:
-		prod := int64(lv.Int()) * int64(rv.Int())
-		newVal.SetInt(int64(prod))
-	case reflect.Uint:
-		prod := uint(lv.Uint()) * uint(rv.Uint())
-		newVal.SetUint(uint64(prod))
-	case reflect.Uint8:
-		prod := uint8(lv.Uint()) * uint8(rv.Uint())
-		newVal.SetUint(uint64(prod))
-	case reflect.Uint16:
-		prod := uint16(lv.Uint()) * uint16(rv.Uint())
-		newVal.SetUint(uint64(prod))
-	case reflect.Uint32:
-		prod := uint32(lv.Uint()) * uint32(rv.Uint())
-		newVal.SetUint(uint64(prod))
-	case reflect.Uint64:
-		prod := uint64(lv.Uint()) * uint64(rv.Uint())
-		newVal.SetUint(uint64(prod))
-	case reflect.Uintptr:
-		prod := uintptr(lv.Uint()) * uintptr(rv.Uint())
-		newVal.SetUint(uint64(prod))
-	case reflect.Float32:
-		prod := float32(lv.Float()) * float32(rv.Float())
-		newVal.SetFloat(float64(prod))
-	case reflect.Float64:
-		prod := float64(lv.Float()) * float64(rv.Float())
-		newVal.SetFloat(float64(prod))
-	case reflect.Complex64:
-		prod := complex64(lv.Complex()) * complex64(rv.Complex())
-		newVal.SetComplex(complex128(prod))
-	case reflect.Complex128:
-		prod := complex128(lv.Complex()) * complex128(rv.Complex())
-		newVal.SetComplex(complex128(prod))
-	default:
-		panic("Type error: Invalid operands to multiplication: " + TypeString(left.Typ) + ", " + TypeString(right.Typ))
-	}
-	return Object{
-		Value: newVal,
-		Typ:   newTyp,
-	}
-}
-
-// operatorQuotient implements the binary operation '/'.
-func operatorQuotient(env *environ, left, right Object) Object {
-	left = getTypedObject(left)
-	right = getTypedObject(right)
-	lv := left.Value.(reflect.Value)
-	rv := right.Value.(reflect.Value)
-
-	newTyp := left.Typ
-	newRtyp, _ := getReflectType(env.interp.typeMap, newTyp)
-	if newRtyp == nil {
-		log.Fatal("operatorQuotient: Couldn't get reflect.Type from types.Type")
-	}
-	newVal := getSettableZeroVal(newRtyp)
-
-	switch lv.Kind() {
-	case reflect.Int:
-		quot := int(lv.Int()) / int(rv.Int())
-		newVal.SetInt(int64(quot))
-	case reflect.Int8:
-		quot := int8(lv.Int()) / int8(rv.Int())
-		newVal.SetInt(int64(quot))
-	case reflect.Int16:
-		quot := int16(lv.Int()) / int16(rv.Int())
-		newVal.SetInt(int64(quot))
-	case reflect.Int32:
-		quot := int32(lv.Int()) / int32(rv.Int())
-		newVal.SetInt(int64(quot))
-	case reflect.Int64:
-		quot := int64(lv.Int()) / int64(rv.Int())
-		newVal.SetInt(int64(quot))
-	case reflect.Uint:
-		quot := uint(lv.Uint()) / uint(rv.Uint())
-		newVal.SetUint(uint64(quot))
-	case reflect.Uint8:
-		quot := uint8(lv.Uint()) / uint8(rv.Uint())
-		newVal.SetUint(uint64(quot))
-	case reflect.Uint16:
-		quot := uint16(lv.Uint()) / uint16(rv.Uint())
-		newVal.SetUint(uint64(quot))
-	case reflect.Uint32:
-		quot := uint32(lv.Uint()) / uint32(rv.Uint())
-		newVal.SetUint(uint64(quot))
-	case reflect.Uint64:
-		quot := uint64(lv.Uint()) / uint64(rv.Uint())
-		newVal.SetUint(uint64(quot))
-	case reflect.Uintptr:
-		quot := uintptr(lv.Uint()) / uintptr(rv.Uint())
-		newVal.SetUint(uint64(quot))
-	case reflect.Float32:
-		quot := float32(lv.Float()) / float32(rv.Float())
-		newVal.SetFloat(float64(quot))
-	case reflect.Float64:
-		quot := float64(lv.Float()) / float64(rv.Float())
-		newVal.SetFloat(float64(quot))
-	case reflect.Complex64:
-		quot := complex64(lv.Complex()) / complex64(rv.Complex())
-		newVal.SetComplex(complex128(quot))
-	case reflect.Complex128:
-		quot := complex128(lv.Complex()) / complex128(rv.Complex())
-		newVal.SetComplex(complex128(quot))
-	default:
-		panic("Type error: Invalid operands to division: " + TypeString(left.Typ) + ", " + TypeString(right.Typ))
-	}
-	return Object{
-		Value: newVal,
-		Typ:   newTyp,
-	}
-}
-
-// operatorRemainder implements the binary operation '%'.
-func operatorRemainder(env *environ, left, right Object) Object {
-	left = getTypedObject(left)
-	right = getTypedObject(right)
-	lv := left.Value.(reflect.Value)
-	rv := right.Value.(reflect.Value)
-
-	newTyp := left.Typ
-	newRtyp, _ := getReflectType(env.interp.typeMap, newTyp)
-	if newRtyp == nil {
-		log.Fatal("operatorRemainder: Couldn't get reflect.Type from types.Type")
-	}
-	newVal := getSettableZeroVal(newRtyp)
-
-	switch lv.Kind() {
-	case reflect.Int:
-		rem := int(lv.Int()) % int(rv.Int())
-		newVal.SetInt(int64(rem))
-	case reflect.Int8:
-		rem := int8(lv.Int()) % int8(rv.Int())
-		newVal.SetInt(int64(rem))
-	case reflect.Int16:
-		rem := int16(lv.Int()) % int16(rv.Int())
-		newVal.SetInt(int64(rem))
-	case reflect.Int32:
-		rem := int32(lv.Int()) % int32(rv.Int())
-		newVal.SetInt(int64(rem))
-	case reflect.Int64:
-		rem := int64(lv.Int()) % int64(rv.Int())
-		newVal.SetInt(int64(rem))
-	case reflect.Uint:
-		rem := uint(lv.Uint()) % uint(rv.Uint())
-		newVal.SetUint(uint64(rem))
-	case reflect.Uint8:
-		rem := uint8(lv.Uint()) % uint8(rv.Uint())
-		newVal.SetUint(uint64(rem))
-	case reflect.Uint16:
-		rem := uint16(lv.Uint()) % uint16(rv.Uint())
-		newVal.SetUint(uint64(rem))
-	case reflect.Uint32:
-		rem := uint32(lv.Uint()) % uint32(rv.Uint())
-		newVal.SetUint(uint64(rem))
-	case reflect.Uint64:
-		rem := uint64(lv.Uint()) % uint64(rv.Uint())
-		newVal.SetUint(uint64(rem))
-	case reflect.Uintptr:
-		rem := uintptr(lv.Uint()) % uintptr(rv.Uint())
-		newVal.SetUint(uint64(rem))
-	default:
-		panic("Type error: Invalid operands to '%' operator: " + TypeString(left.Typ) + ", " + TypeString(right.Typ))
-	}
-	return Object{
-		Value: newVal,
-		Typ:   newTyp,
-	}
-}
-
-// operatorAnd implements the binary operation '&'.
-func operatorAnd(env *environ, left, right Object) Object {
-	left = getTypedObject(left)
-	right = getTypedObject(right)
-	lv := left.Value.(reflect.Value)
-	rv := right.Value.(reflect.Value)
-
-	newTyp := left.Typ
-	newRtyp, _ := getReflectType(env.interp.typeMap, newTyp)
-	if newRtyp == nil {
-		log.Fatal("operatorAnd: Couldn't get reflect.Type from types.Type")
-	}
-	newVal := getSettableZeroVal(newRtyp)
-
-	switch lv.Kind() {
-	case reflect.Int:
-		and := int(lv.Int()) & int(rv.Int())
-		newVal.SetInt(int64(and))
-	case reflect.Int8:
-		and := int8(lv.Int()) & int8(rv.Int())
-		newVal.SetInt(int64(and))
-	case reflect.Int16:
-		and := int16(lv.Int()) & int16(rv.Int())
-		newVal.SetInt(int64(and))
-	case reflect.Int32:
-		and := int32(lv.Int()) & int32(rv.Int())
-		newVal.SetInt(int64(and))
-	case reflect.Int64:
-		and := int64(lv.Int()) & int64(rv.Int())
-		newVal.SetInt(int64(and))
-	case reflect.Uint:
-		and := uint(lv.Uint()) & uint(rv.Uint())
-		newVal.SetUint(uint64(and))
-	case reflect.Uint8:
-		and := uint8(lv.Uint()) & uint8(rv.Uint())
-		newVal.SetUint(uint64(and))
-	case reflect.Uint16:
-		and := uint16(lv.Uint()) & uint16(rv.Uint())
-		newVal.SetUint(uint64(and))
-	case reflect.Uint32:
-		and := uint32(lv.Uint()) & uint32(rv.Uint())
-		newVal.SetUint(uint64(and))
-	case reflect.Uint64:
-		and := uint64(lv.Uint()) & uint64(rv.Uint())
-		newVal.SetUint(uint64(and))
-	case reflect.Uintptr:
-		and := uintptr(lv.Uint()) & uintptr(rv.Uint())
-		newVal.SetUint(uint64(and))
-	default:
-		panic("Type error: Invalid operands to '&' operator: " + TypeString(left.Typ) + ", " + TypeString(right.Typ))
-	}
-	return Object{
-		Value: newVal,
-		Typ:   newTyp,
-	}
-}
-
-// operatorOr implements the binary operation '|'.
-func operatorOr(env *environ, left, right Object) Object {
-	left = getTypedObject(left)
-	right = getTypedObject(right)
-	lv := left.Value.(reflect.Value)
-	rv := right.Value.(reflect.Value)
-
-	newTyp := left.Typ
-	newRtyp, _ := getReflectType(env.interp.typeMap, newTyp)
-	if newRtyp == nil {
-		log.Fatal("operatorOr: Couldn't get reflect.Type from types.Type")
-	}
-	newVal := getSettableZeroVal(newRtyp)
-
-	switch lv.Kind() {
-	case reflect.Int:
-		or := int(lv.Int()) | int(rv.Int())
-		newVal.SetInt(int64(or))
-	case reflect.Int8:
-		or := int8(lv.Int()) | int8(rv.Int())
-		newVal.SetInt(int64(or))
-	case reflect.Int16:
-		or := int16(lv.Int()) | int16(rv.Int())
-		newVal.SetInt(int64(or))
-	case reflect.Int32:
-		or := int32(lv.Int()) | int32(rv.Int())
-		newVal.SetInt(int64(or))
-	case reflect.Int64:
-		or := int64(lv.Int()) | int64(rv.Int())
-		newVal.SetInt(int64(or))
-	case reflect.Uint:
-		or := uint(lv.Uint()) | uint(rv.Uint())
-		newVal.SetUint(uint64(or))
-	case reflect.Uint8:
-		or := uint8(lv.Uint()) | uint8(rv.Uint())
-		newVal.SetUint(uint64(or))
-	case reflect.Uint16:
-		or := uint16(lv.Uint()) | uint16(rv.Uint())
-		newVal.SetUint(uint64(or))
-	case reflect.Uint32:
-		or := uint32(lv.Uint()) | uint32(rv.Uint())
-		newVal.SetUint(uint64(or))
-	case reflect.Uint64:
-		or := uint64(lv.Uint()) | uint64(rv.Uint())
-		newVal.SetUint(uint64(or))
-	case reflect.Uintptr:
-		or := uintptr(lv.Uint()) | uintptr(rv.Uint())
-		newVal.SetUint(uint64(or))
-	default:
-		panic("Type error: Invalid operands to '|' operator: " + TypeString(left.Typ) + ", " + TypeString(right.Typ))
-	}
-	return Object{
-		Value: newVal,
-		Typ:   newTyp,
-	}
-}
-
-// operatorXor implements the binary operation '^'.
-func operatorXor(env *environ, left, right Object) Object {
-	left = getTypedObject(left)
-	right = getTypedObject(right)
-	lv := left.Value.(reflect.Value)
-	rv := right.Value.(reflect.Value)
-
-	newTyp := left.Typ
-	newRtyp, _ := getReflectType(env.interp.typeMap, newTyp)
-	if newRtyp == nil {
-		log.Fatal("operatorXor: Couldn't get reflect.Type from types.Type")
-	}
-	newVal := getSettableZeroVal(newRtyp)
-
-	switch lv.Kind() {
-	case reflect.Int:
-		xor := int(lv.Int()) ^ int(rv.Int())
-		newVal.SetInt(int64(xor))
-	case reflect.Int8:
-		xor := int8(lv.Int()) ^ int8(rv.Int())
-		newVal.SetInt(int64(xor))
-	case reflect.Int16:
-		xor := int16(lv.Int()) ^ int16(rv.Int())
-		newVal.SetInt(int64(xor))
-	case reflect.Int32:
-		xor := int32(lv.Int()) ^ int32(rv.Int())
-		newVal.SetInt(int64(xor))
-	case reflect.Int64:
-		xor := int64(lv.Int()) ^ int64(rv.Int())
-		newVal.SetInt(int64(xor))
-	case reflect.Uint:
-		xor := uint(lv.Uint()) ^ uint(rv.Uint())
-		newVal.SetUint(uint64(xor))
-	case reflect.Uint8:
-		xor := uint8(lv.Uint()) ^ uint8(rv.Uint())
-		newVal.SetUint(uint64(xor))
-	case reflect.Uint16:
-		xor := uint16(lv.Uint()) ^ uint16(rv.Uint())
-		newVal.SetUint(uint64(xor))
-	case reflect.Uint32:
-		xor := uint32(lv.Uint()) ^ uint32(rv.Uint())
-		newVal.SetUint(uint64(xor))
-	case reflect.Uint64:
-		xor := uint64(lv.Uint()) ^ uint64(rv.Uint())
-		newVal.SetUint(uint64(xor))
-	case reflect.Uintptr:
-		xor := uintptr(lv.Uint()) ^ uintptr(rv.Uint())
-		newVal.SetUint(uint64(xor))
-	default:
-		panic("Type error: Invalid operands to '^' operator: " + TypeString(left.Typ) + ", " + TypeString(right.Typ))
-	}
-	return Object{
-		Value: newVal,
-		Typ:   newTyp,
-	}
-}
-
-// operatorAndNot implements the binary operation '&^'.
-func operatorAndNot(env *environ, left, right Object) Object {
-	left = getTypedObject(left)
-	right = getTypedObject(right)
-	lv := left.Value.(reflect.Value)
-	rv := right.Value.(reflect.Value)
-
-	newTyp := left.Typ
-	newRtyp, _ := getReflectType(env.interp.typeMap, newTyp)
-	if newRtyp == nil {
-		log.Fatal("operatorAndNot: Couldn't get reflect.Type from types.Type")
-	}
-	newVal := getSettableZeroVal(newRtyp)
-
-	switch lv.Kind() {
-	case reflect.Int:
-		andNot := int(lv.Int()) &^ int(rv.Int())
-		newVal.SetInt(int64(andNot))
-	case reflect.Int8:
-		andNot := int8(lv.Int()) &^ int8(rv.Int())
-		newVal.SetInt(int64(andNot))
-	case reflect.Int16:
-		andNot := int16(lv.Int()) &^ int16(rv.Int())
-		newVal.SetInt(int64(andNot))
-	case reflect.Int32:
-		andNot := int32(lv.Int()) &^ int32(rv.Int())
-		newVal.SetInt(int64(andNot))
-	case reflect.Int64:
-		andNot := int64(lv.Int()) &^ int64(rv.Int())
-		newVal.SetInt(int64(andNot))
-	case reflect.Uint:
-		andNot := uint(lv.Uint()) &^ uint(rv.Uint())
-		newVal.SetUint(uint64(andNot))
-	case reflect.Uint8:
-		andNot := uint8(lv.Uint()) &^ uint8(rv.Uint())
-		newVal.SetUint(uint64(andNot))
-	case reflect.Uint16:
-		andNot := uint16(lv.Uint()) &^ uint16(rv.Uint())
-		newVal.SetUint(uint64(andNot))
-	case reflect.Uint32:
-		andNot := uint32(lv.Uint()) &^ uint32(rv.Uint())
-		newVal.SetUint(uint64(andNot))
-	case reflect.Uint64:
-		andNot := uint64(lv.Uint()) &^ uint64(rv.Uint())
-		newVal.SetUint(uint64(andNot))
-	case reflect.Uintptr:
-		andNot := uintptr(lv.Uint()) &^ uintptr(rv.Uint())
-		newVal.SetUint(uint64(andNot))
-	default:
-		panic("Type error: Invalid operands to '&^' operator: " + TypeString(left.Typ) + ", " + TypeString(right.Typ))
-	}
-	return Object{
-		Value: newVal,
-		Typ:   newTyp,
-	}
-}
-
-// operatorShiftRight implements the binary operation '>>'.
-func operatorShiftRight(env *environ, left, right Object) Object {
-	left = getTypedObject(left)
-	lv := left.Value.(reflect.Value)
-
-	rv, ok := right.Value.(reflect.Value)
-	if !ok {
-		// We have an exact.Value. Turn it into a uint64 and set rv from that
-		ev := right.Value.(exact.Value)
-		v64, _ := exact.Uint64Val(ev)
-		rv = reflect.ValueOf(v64)
-	}
-	amt := rv.Uint()
-
-	newTyp := left.Typ
-	newRtyp, _ := getReflectType(env.interp.typeMap, newTyp)
-	if newRtyp == nil {
-		log.Fatal("operatorShiftRight: Couldn't get reflect.Type from types.Type")
-	}
-	newVal := getSettableZeroVal(newRtyp)
-
-	switch lv.Kind() {
-	case reflect.Int:
-		shifted := int(lv.Int()) >> amt
-		newVal.SetInt(int64(shifted))
-	case reflect.Int8:
-		shifted := int8(lv.Int()) >> amt
-		newVal.SetInt(int64(shifted))
-	case reflect.Int16:
-		shifted := int16(lv.Int()) >> amt
-		newVal.SetInt(int64(shifted))
-	case reflect.Int32:
-		shifted := int32(lv.Int()) >> amt
-		newVal.SetInt(int64(shifted))
-	case reflect.Int64:
-		shifted := int64(lv.Int()) >> amt
-		newVal.SetInt(int64(shifted))
-	case reflect.Uint:
-		shifted := uint(lv.Uint()) >> amt
-		newVal.SetUint(uint64(shifted))
-	case reflect.Uint8:
-		shifted := uint8(lv.Uint()) >> amt
-		newVal.SetUint(uint64(shifted))
-	case reflect.Uint16:
-		shifted := uint16(lv.Uint()) >> amt
-		newVal.SetUint(uint64(shifted))
-	case reflect.Uint32:
-		shifted := uint32(lv.Uint()) >> amt
-		newVal.SetUint(uint64(shifted))
-	case reflect.Uint64:
-		shifted := uint64(lv.Uint()) >> amt
-		newVal.SetUint(uint64(shifted))
-	case reflect.Uintptr:
-		shifted := uintptr(lv.Uint()) >> amt
-		newVal.SetUint(uint64(shifted))
-	default:
-		panic("Type error: Invalid operands to shift: " + TypeString(left.Typ) + ", " + TypeString(right.Typ))
-	}
-	return Object{
-		Value: newVal,
-		Typ:   newTyp,
-	}
-}
-
-// operatorShiftLeft implements the binary operation '<<'.
-func operatorShiftLeft(env *environ, left, right Object) Object {
-	left = getTypedObject(left)
-	lv := left.Value.(reflect.Value)
-
-	rv, ok := right.Value.(reflect.Value)
-	if !ok {
-		// We have an exact.Value. Turn it into a uint64 and set rv from that
-		ev := right.Value.(exact.Value)
-		v64, _ := exact.Uint64Val(ev)
-		rv = reflect.ValueOf(v64)
-	}
-	amt := rv.Uint()
-
-	newTyp := left.Typ
-	newRtyp, _ := getReflectType(env.interp.typeMap, newTyp)
-	if newRtyp == nil {
-		log.Fatal("operatorShiftLeft: Couldn't get reflect.Type from types.Type")
-	}
-	newVal := getSettableZeroVal(newRtyp)
-
-	switch lv.Kind() {
-	case reflect.Int:
-		shifted := int(lv.Int()) << amt
-		newVal.SetInt(int64(shifted))
-	case reflect.Int8:
-		shifted := int8(lv.Int()) << amt
-		newVal.SetInt(int64(shifted))
-	case reflect.Int16:
-		shifted := int16(lv.Int()) << amt
-		newVal.SetInt(int64(shifted))
-	case reflect.Int32:
-		shifted := int32(lv.Int()) << amt
-		newVal.SetInt(int64(shifted))
-	case reflect.Int64:
-		shifted := int64(lv.Int()) << amt
-		newVal.SetInt(int64(shifted))
-	case reflect.Uint:
-		shifted := uint(lv.Uint()) << amt
-		newVal.SetUint(uint64(shifted))
-	case reflect.Uint8:
-		shifted := uint8(lv.Uint()) << amt
-		newVal.SetUint(uint64(shifted))
-	case reflect.Uint16:
-		shifted := uint16(lv.Uint()) << amt
-		newVal.SetUint(uint64(shifted))
-	case reflect.Uint32:
-		shifted := uint32(lv.Uint()) << amt
-		newVal.SetUint(uint64(shifted))
-	case reflect.Uintptr:
-		shifted := uintptr(lv.Uint()) << amt
-		newVal.SetUint(uint64(shifted))
-	default:
-		panic("Type error: Invalid operands to shift: " + TypeString(left.Typ) + ", " + TypeString(right.Typ))
-	}
-	return Object{
-		Value: newVal,
-		Typ:   newTyp,
-	}
-}
-
-// operatorLess implements the binary operation '<'.
-// If this is being called at all, then the left and right objects
-// have a value that's a "reflect.Value". Also, they can be compared,
-// since the expression passed type checking.
-func operatorLess(env *environ, left, right Object, typ types.Type) Object {
-	left = getTypedObject(left)
-	right = getTypedObject(right)
-	lv := left.Value.(reflect.Value)
-	rv := right.Value.(reflect.Value)
-
-	less := false
-	switch lv.Kind() {
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		less = lv.Int() < rv.Int()
-	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
-		less = lv.Uint() < rv.Uint()
-	case reflect.Float32, reflect.Float64:
-		less = lv.Float() < rv.Float()
-	case reflect.String:
-		less = lv.String() < rv.String()
-	default:
-		panic("Type error: Invalid operands to ordered comparison: " + TypeString(left.Typ) + ", " + TypeString(right.Typ))
-	}
-
-	var newVal reflect.Value
-	if _, isNamed := typ.(*types.Named); isNamed {
-		// Type is not "bool" but some other named boolean type.
-		newRtyp, _ := getReflectType(env.interp.typeMap, typ)
-		if newRtyp == nil {
-			log.Fatal("operatorLess: Couldn't get reflect.Type from types.Type")
-		}
-		newVal = getSettableZeroVal(newRtyp)
-		newVal.SetBool(less)
-	} else {
-		// Type is "bool" or "untyped bool". Use "bool".
-		newVal = reflect.ValueOf(less)
-	}
-
-	return Object{
-		Value: newVal,
-		Typ:   typ,
-	}
-}
-
-// operatorGreater implements the binary operation '>'.
-// If this is being called at all, then the left and right objects
-// have a value that's a "reflect.Value". Also, they can be compared,
-// since the expression passed type checking.
-func operatorGreater(env *environ, left, right Object, typ types.Type) Object {
-	left = getTypedObject(left)
-	right = getTypedObject(right)
-	lv := left.Value.(reflect.Value)
-	rv := right.Value.(reflect.Value)
-
-	greater := false
-	switch lv.Kind() {
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		greater = lv.Int() > rv.Int()
-	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
-		greater = lv.Uint() > rv.Uint()
-	case reflect.Float32, reflect.Float64:
-		greater = lv.Float() > rv.Float()
-	case reflect.String:
-		greater = lv.String() > rv.String()
-	default:
-		panic("Type error: Invalid operands to ordered comparison: " + TypeString(left.Typ) + ", " + TypeString(right.Typ))
-	}
-
-	var newVal reflect.Value
-	if _, isNamed := typ.(*types.Named); isNamed {
-		// Type is not "bool" but some other named boolean type.
-		newRtyp, _ := getReflectType(env.interp.typeMap, typ)
-		if newRtyp == nil {
-			log.Fatal("operatorGreater: Couldn't get reflect.Type from types.Type")
-		}
-		newVal = reflect.New(newRtyp).Elem()
-		newVal.SetBool(greater)
-	} else {
-		// Type is "bool" or "untyped bool". Use "bool".
-		newVal = reflect.ValueOf(greater)
-	}
-
-	return Object{
-		Value: newVal,
-		Typ:   typ,
-	}
-}
-
-// operatorLessEqual implements the binary operation '<='.
-// If this is being called at all, then the left and right objects
-// have a value that's a "reflect.Value". Also, they can be compared,
-// since the expression passed type checking.
-func operatorLessEqual(env *environ, left, right Object, typ types.Type) Object {
-	left = getTypedObject(left)
-	right = getTypedObject(right)
-	lv := left.Value.(reflect.Value)
-	rv := right.Value.(reflect.Value)
-
-	lessEqual := false
-	switch lv.Kind() {
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		lessEqual = lv.Int() <= rv.Int()
-	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
-		lessEqual = lv.Uint() <= rv.Uint()
-	case reflect.Float32, reflect.Float64:
-		lessEqual = lv.Float() <= rv.Float()
-	case reflect.String:
-		lessEqual = lv.String() <= rv.String()
-	default:
-		panic("Type error: Invalid operands to ordered comparison: " + TypeString(left.Typ) + ", " + TypeString(right.Typ))
-	}
-
-	var newVal reflect.Value
-	if _, isNamed := typ.(*types.Named); isNamed {
-		// Type is not "bool" but some other named boolean type.
-		newRtyp, _ := getReflectType(env.interp.typeMap, typ)
-		if newRtyp == nil {
-			log.Fatal("operatorLessEqual: Couldn't get reflect.Type from types.Type")
-		}
-		newVal = reflect.New(newRtyp).Elem()
-		newVal.SetBool(lessEqual)
-	} else {
-		// Type is "bool" or "untyped bool". Use "bool".
-		newVal = reflect.ValueOf(lessEqual)
-	}
-
-	return Object{
-		Value: newVal,
-		Typ:   typ,
-	}
-}
-
-// operatorGreaterEqual implements the binary operation '>='.
-// If this is being called at all, then the left and right objects
-// have a value that's a "reflect.Value". Also, they can be compared,
-// since the expression passed type checking.
-func operatorGreaterEqual(env *environ, left, right Object, typ types.Type) Object {
-	left = getTypedObject(left)
-	right = getTypedObject(right)
-	lv := left.Value.(reflect.Value)
-	rv := right.Value.(reflect.Value)
-
-	greaterEqual := false
-	switch lv.Kind() {
-	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		greaterEqual = lv.Int() >= rv.Int()
-	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
-		greaterEqual = lv.Uint() >= rv.Uint()
-	case reflect.Float32, reflect.Float64:
-		greaterEqual = lv.Float() >= rv.Float()
-	case reflect.String:
-		greaterEqual = lv.String() >= rv.String()
-	default:
-		panic("Type error: Invalid operands to ordered comparison: " + TypeString(left.Typ) + ", " + TypeString(right.Typ))
-	}
-
-	var newVal reflect.Value
-	if _, isNamed := typ.(*types.Named); isNamed {
-		// Type is not "bool" but some other named boolean type.
-		newRtyp, _ := getReflectType(env.interp.typeMap, typ)
-		if newRtyp == nil {
-			log.Fatal("operatorGreaterEqual: Couldn't get reflect.Type from types.Type")
-		}
-		newVal = reflect.New(newRtyp).Elem()
-		newVal.SetBool(greaterEqual)
-	} else {
-		// Type is "bool" or "untyped bool". Use "bool".
-		newVal = reflect.ValueOf(greaterEqual)
-	}
-
-	return Object{
-		Value: newVal,
-		Typ:   typ,
-	}
-}
-
-// operatorGreaterEqual implements the binary operation '=='.
-// If this is being called at all, then the left and right objects
-// have a value that's a "reflect.Value". Also, they can be compared,
-// since the expression passed type checking.
-func operatorEqual(env *environ, left, right Object, typ types.Type) Object {
+// equalObjects implements the binary operation '==' for operands that aren't
+// both of basic types, such as pointers, channels and interfaces, or untyped nil.
+// The operands can be compared, since the expression passed type checking.
+func equalObjects(left, right Object) bool {
 	isUntypedNil := func(t types.Type) bool {
 		if isTyped(t) {
 			return false
@@ -1030,38 +444,17 @@ func operatorEqual(env *environ, left, right Object, typ types.Type) Object {
 		rv = right.Value.(reflect.Value)
 	}
 
-	var equal bool
 	switch {
 	case leftIsUntypedNil:
-		equal = rightIsUntypedNil || rv.IsNil()
+		return rightIsUntypedNil || rv.IsNil()
 	case rightIsUntypedNil:
-		equal = lv.IsNil()
+		return lv.IsNil()
 	case types.Identical(left.Typ, right.Typ):
-		equal = lv.Interface() == rv.Interface()
+		return lv.Interface() == rv.Interface()
 	case types.AssignableTo(left.Typ, right.Typ):
 		clv := lv.Convert(rv.Type())
-		equal = clv.Interface() == rv.Interface()
-	default:
-		crv := rv.Convert(lv.Type())
-		equal = lv.Interface() == crv.Interface()
-	}
-
-	var newVal reflect.Value
-	if _, isNamed := typ.(*types.Named); isNamed {
-		// Type is not "bool" but some other named boolean type.
-		newRtyp, _ := getReflectType(env.interp.typeMap, typ)
-		if newRtyp == nil {
-			log.Fatal("operatorEqual: Couldn't get reflect.Type from types.Type")
-		}
-		newVal = reflect.New(newRtyp).Elem()
-		newVal.SetBool(equal)
-	} else {
-		// Type is "bool" or "untyped bool". Use "bool".
-		newVal = reflect.ValueOf(equal)
-	}
-
-	return Object{
-		Value: newVal,
-		Typ:   typ,
+		return clv.Interface() == rv.Interface()
 	}
+	crv := rv.Convert(lv.Type())
+	return lv.Interface() == crv.Interface()
 }
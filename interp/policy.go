@@ -0,0 +1,86 @@
+package interp
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+
+	"golang.org/x/tools/go/types"
+)
+
+// A Policy decides which objects of imported packages code may refer to. Each
+// entry of Allow and Deny is either the import path of a package, which covers
+// all of its objects, or an import path and an object name joined by a dot,
+// like "os.Exit".
+//
+// An object may be referred to unless Deny covers it, or Allow is not empty and
+// does not cover it. The zero Policy allows everything.
+type Policy struct {
+	Allow []string
+	Deny  []string
+}
+
+// notAllowed returns the message of the error for a reference to obj that the
+// policy does not allow.
+func notAllowed(obj types.Object) string {
+	return fmt.Sprintf("use of %s.%s not allowed", obj.Pkg().Path(), obj.Name())
+}
+
+// covers reports whether entries cover the object name of the package with import path path.
+func covers(entries []string, path, name string) bool {
+	for _, entry := range entries {
+		if entry == path || entry == path+"."+name {
+			return true
+		}
+	}
+	return false
+}
+
+// allows reports whether code may refer to the object name of the package with
+// import path path.
+func (p *Policy) allows(path, name string) bool {
+	if covers(p.Deny, path, name) {
+		return false
+	}
+	return len(p.Allow) == 0 || covers(p.Allow, path, name)
+}
+
+// isPkgObject reports whether obj is declared at the top level of an imported package.
+func isPkgObject(obj types.Object) bool {
+	if obj == nil || obj.Pkg() == nil {
+		// Universe objects, like the predeclared types and builtins
+		return false
+	}
+	switch obj.Pkg().Path() {
+	case "", hostPkgPath, exprPkgPath:
+		// The code being checked, or the variables we declared for it
+		return false
+	}
+	return obj.Parent() == obj.Pkg().Scope()
+}
+
+// checkPolicy returns an error for the first reference in node to a package
+// object that the policy of the interpreter does not allow.
+func (i *interp) checkPolicy(fset *token.FileSet, info *types.Info, node ast.Node) error {
+	var err error
+	ast.Inspect(node, func(n ast.Node) bool {
+		if err != nil {
+			return false
+		}
+		e, ok := n.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		obj := info.Uses[e.Sel]
+		if !isPkgObject(obj) || i.policy.allows(obj.Pkg().Path(), obj.Name()) {
+			return true
+		}
+		err = types.Error{
+			Fset: fset,
+			Pos:  e.Sel.Pos(),
+			Msg:  notAllowed(obj),
+		}
+		return false
+	})
+	return err
+}
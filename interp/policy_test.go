@@ -0,0 +1,110 @@
+package interp
+
+import (
+	"go/ast"
+	"go/token"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/types"
+)
+
+// strsPkg returns a package strs with the functions Upper and Lower of strings.
+func strsPkg() *Package {
+	pkg := types.NewPackage("strs", "strs")
+	str := func() *types.Tuple {
+		return types.NewTuple(types.NewParam(token.NoPos, pkg, "", types.Typ[types.String]))
+	}
+	objs := map[string]Object{}
+	for name, fn := range map[string]func(string) string{
+		"Upper": strings.ToUpper,
+		"Lower": strings.ToLower,
+	} {
+		sig := types.NewSignature(nil, str(), str(), false)
+		pkg.Scope().Insert(types.NewFunc(token.NoPos, pkg, name, sig))
+		objs[name] = Object{Value: reflect.ValueOf(fn), Typ: sig}
+	}
+	pkg.MarkComplete()
+	return &Package{Name: "strs", Objs: objs, Pkg: pkg}
+}
+
+// policyOptions returns Options with the package strs and the given policy.
+func policyOptions(policy Policy) Options {
+	p := strsPkg()
+	return Options{
+		Packages: []*Package{p},
+		PkgMap:   map[string]*types.Package{"strs": p.Pkg},
+		Policy:   policy,
+	}
+}
+
+// testPolicy checks that with policy, the interpreter and an Evaluator run the
+// functions of strs that are allowed, and refuse to type check the others.
+func testPolicy(t *testing.T, policy Policy, allowed, denied []string) {
+	i := New(policyOptions(policy))
+	ev := NewEvaluator(policyOptions(policy))
+	for _, name := range allowed {
+		src := "strs." + name + `("aB")`
+		if _, err := i.Eval(src); err != nil {
+			t.Errorf("%v: interpreting %s: %v", policy, src, err)
+		}
+		if _, err := ev.Eval(src, nil); err != nil {
+			t.Errorf("%v: evaluating %s: %v", policy, src, err)
+		}
+	}
+	for _, name := range denied {
+		src := "strs." + name + `("aB")`
+		want := "use of strs." + name + " not allowed"
+		if _, err := i.Eval(src); err == nil || !strings.HasSuffix(err.Error(), ": "+want) {
+			t.Errorf("%v: interpreting %s: got error %v, want %q", policy, src, err, want)
+		}
+		if _, err := ev.Eval(src, nil); err == nil || err.Error() != "expr:1:6: "+want {
+			t.Errorf("%v: evaluating %s: got error %v, want %q", policy, src, err, want)
+		}
+	}
+}
+
+func TestPolicyDeny(t *testing.T) {
+	testPolicy(t, Policy{Deny: []string{"strs.Upper"}}, []string{"Lower"}, []string{"Upper"})
+	testPolicy(t, Policy{Deny: []string{"strs"}}, nil, []string{"Upper", "Lower"})
+}
+
+// Deny covers an object even if Allow does too, and a non-empty Allow leaves
+// out everything it doesn't cover.
+func TestPolicyPrecedence(t *testing.T) {
+	testPolicy(t, Policy{Allow: []string{"strs.Lower"}}, []string{"Lower"}, []string{"Upper"})
+	testPolicy(t, Policy{Allow: []string{"strs"}, Deny: []string{"strs.Upper"}}, []string{"Lower"}, []string{"Upper"})
+	testPolicy(t, Policy{Allow: []string{"strs.Upper"}, Deny: []string{"strs.Upper"}}, nil, []string{"Upper", "Lower"})
+}
+
+// Code that gets past the type checker without the policy being checked still
+// can't use a denied object, in the interpreter or an Evaluator.
+func TestPolicyDenyAtRuntime(t *testing.T) {
+	policy := Policy{Deny: []string{"strs.Upper"}}
+	for _, i := range []*interp{
+		New(policyOptions(policy)).(*interp),
+		NewEvaluator(policyOptions(policy)).interp,
+	} {
+		// The type-checked expression strs.Upper
+		upper := i.pkgs["strs"].Pkg.Scope().Lookup("Upper")
+		e := &ast.SelectorExpr{X: ast.NewIdent("strs"), Sel: ast.NewIdent("Upper")}
+		info := &types.Info{
+			Types: map[ast.Expr]types.TypeAndValue{e: {Type: upper.Type()}},
+			Uses:  map[*ast.Ident]types.Object{e.Sel: upper},
+		}
+		code, err := i.compileExpr(token.NewFileSet(), info, e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		func() {
+			defer func() {
+				u, ok := recover().(unwind)
+				if want := "use of strs.Upper not allowed"; !ok || u.err.Error() != want {
+					t.Errorf("got %v, want an error %q", u.err, want)
+				}
+			}()
+			code(nil)
+		}()
+	}
+}
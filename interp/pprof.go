@@ -0,0 +1,157 @@
+package interp
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+// WritePprof writes the profile to w in the gzipped protocol buffer format read
+// by pprof. Each sample has a count and a time in nanoseconds. Interpreted
+// frames are at lines of the file "input", and compiled ones in "(compiled)".
+func (prof *Profile) WritePprof(w io.Writer) error {
+	var b protobuf
+	strs := map[string]int64{}
+	str := func(s string) int64 {
+		if id, ok := strs[s]; ok {
+			return id
+		}
+		id := int64(len(strs))
+		strs[s] = id
+		b.string(6, s) // string_table
+		return id
+	}
+	str("")
+
+	// sample_type: ValueType{type, unit}
+	for _, vt := range [][2]string{{"samples", "count"}, {"time", "nanoseconds"}} {
+		var m protobuf
+		m.int64(1, str(vt[0]))
+		m.int64(2, str(vt[1]))
+		b.message(1, m)
+	}
+
+	// Functions are interpreted function literals and compiled functions, and
+	// locations are lines in them
+	type funcKey struct {
+		name, file string
+		line       int
+	}
+	funcs := map[funcKey]uint64{}
+	type locKey struct {
+		fn   uint64
+		line int
+	}
+	locs := map[locKey]uint64{}
+	location := func(f Frame) uint64 {
+		fk := funcKey{name: f.Func, file: "(compiled)"}
+		line := 0
+		if !f.Compiled {
+			fk.file = f.Pos.Filename
+			fk.line = f.Entry.Line
+			line = f.Pos.Line
+			if f.Entry.IsValid() {
+				fk.name = f.Func + " at " + f.Entry.String()
+			}
+		}
+		fn, ok := funcs[fk]
+		if !ok {
+			fn = uint64(len(funcs) + 1)
+			funcs[fk] = fn
+			var m protobuf // Function{id, name, system_name, filename, start_line}
+			m.uint64(1, fn)
+			m.int64(2, str(fk.name))
+			m.int64(3, str(fk.name))
+			m.int64(4, str(fk.file))
+			m.int64(5, int64(fk.line))
+			b.message(5, m)
+		}
+		lk := locKey{fn, line}
+		loc, ok := locs[lk]
+		if !ok {
+			loc = uint64(len(locs) + 1)
+			locs[lk] = loc
+			var l protobuf // Line{function_id, line}
+			l.uint64(1, fn)
+			l.int64(2, int64(line))
+			var m protobuf // Location{id, line}
+			m.uint64(1, loc)
+			m.message(4, l)
+			b.message(4, m)
+		}
+		return loc
+	}
+
+	for _, s := range prof.Samples {
+		ids := make([]uint64, len(s.Stack))
+		for j, f := range s.Stack {
+			ids[j] = location(f)
+		}
+		var m protobuf // Sample{location_id, value}
+		m.packedUint64(1, ids)
+		m.packedInt64(2, []int64{s.Count, int64(s.Time)})
+		b.message(2, m)
+	}
+
+	b.int64(9, prof.Start.UnixNano()) // time_nanos
+	b.int64(10, int64(prof.Duration)) // duration_nanos
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(b); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// protobuf is an encoded protocol buffer message, with methods to append fields.
+type protobuf []byte
+
+func (b *protobuf) varint(x uint64) {
+	for x >= 0x80 {
+		*b = append(*b, byte(x)|0x80)
+		x >>= 7
+	}
+	*b = append(*b, byte(x))
+}
+
+func (b *protobuf) key(field int, wireType uint64) {
+	b.varint(uint64(field)<<3 | wireType)
+}
+
+func (b *protobuf) uint64(field int, x uint64) {
+	b.key(field, 0)
+	b.varint(x)
+}
+
+func (b *protobuf) int64(field int, x int64) {
+	b.uint64(field, uint64(x))
+}
+
+func (b *protobuf) bytes(field int, data []byte) {
+	b.key(field, 2)
+	b.varint(uint64(len(data)))
+	*b = append(*b, data...)
+}
+
+func (b *protobuf) string(field int, s string) {
+	b.bytes(field, []byte(s))
+}
+
+func (b *protobuf) message(field int, m protobuf) {
+	b.bytes(field, m)
+}
+
+func (b *protobuf) packedUint64(field int, xs []uint64) {
+	var p protobuf
+	for _, x := range xs {
+		p.varint(x)
+	}
+	b.bytes(field, p)
+}
+
+func (b *protobuf) packedInt64(field int, xs []int64) {
+	var p protobuf
+	for _, x := range xs {
+		p.varint(uint64(x))
+	}
+	b.bytes(field, p)
+}
@@ -0,0 +1,242 @@
+package interp
+
+import (
+	"fmt"
+	"go/token"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A Profile is what the profiler found about code run between StartProfile and
+// StopProfile, in every goroutine.
+type Profile struct {
+	Start    time.Time
+	Duration time.Duration
+	Samples  []ProfileSample
+}
+
+// A ProfileSample is the time spent with a stack of calls, innermost first,
+// which ends with either a statement or a call of a compiled function.
+type ProfileSample struct {
+	Stack []Frame
+	Count int64         // the number of times the statement ran or the function was called
+	Time  time.Duration // the time spent in the statement or the function itself
+}
+
+// A ProfileEntry totals the samples ending with the same line or compiled function.
+type ProfileEntry struct {
+	Name  string // the position of the line, or the compiled function
+	Count int64
+	Time  time.Duration
+}
+
+// The profiler charges the time between events on a thread to the stack the
+// thread had at the first of them. Events are statements starting, and compiled
+// functions being called and returning, so a compiled function isn't charged
+// the time spent in interpreted functions it calls back.
+//
+// The samples form a tree, where the sample of a stack is a child of the sample
+// of the stack of its caller, so a stack's sample is found by following its
+// frames from the outermost without formatting them.
+type profiler struct {
+	active int32 // whether code is being profiled; accessed atomically
+
+	mu      sync.Mutex
+	start   time.Time
+	gen     int // counts the profiles started, so threads can tell samples are stale
+	samples map[profKey]*profSample
+}
+
+// profFrame is a frame as profiled, before its positions are looked up.
+type profFrame struct {
+	name       string
+	entry, pos token.Pos
+	compiled   bool
+}
+
+// A profKey finds the sample of a stack, from the sample of the stack of its
+// caller, or nil for the outermost frame, and its innermost frame.
+type profKey struct {
+	caller *profSample
+	frame  profFrame
+}
+
+type profSample struct {
+	profKey
+	count int64
+	nanos int64
+}
+
+// profiling reports whether code is being profiled.
+func (i *interp) profiling() bool {
+	return atomic.LoadInt32(&i.profiler.active) != 0
+}
+
+func (i *interp) StartProfile() {
+	p := &i.profiler
+	p.mu.Lock()
+	p.start = time.Now()
+	p.gen++
+	p.samples = map[profKey]*profSample{}
+	atomic.StoreInt32(&p.active, 1)
+	p.mu.Unlock()
+}
+
+func (i *interp) StopProfile() *Profile {
+	p := &i.profiler
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if atomic.LoadInt32(&p.active) == 0 {
+		return nil
+	}
+	atomic.StoreInt32(&p.active, 0)
+
+	prof := &Profile{
+		Start:    p.start,
+		Duration: time.Since(p.start),
+	}
+	for _, s := range p.samples {
+		if s.count == 0 && s.nanos == 0 {
+			// Only the caller of others
+			continue
+		}
+		var stack []Frame
+		for c := s; c != nil; c = c.caller {
+			f := Frame{
+				Func:     c.frame.name,
+				Compiled: c.frame.compiled,
+			}
+			if !c.frame.compiled {
+				f.Entry = i.fset.Position(c.frame.entry)
+				f.Pos = i.fset.Position(c.frame.pos)
+			}
+			stack = append(stack, f)
+		}
+		prof.Samples = append(prof.Samples, ProfileSample{
+			Stack: stack,
+			Count: s.count,
+			Time:  time.Duration(s.nanos),
+		})
+	}
+	p.samples = nil
+	return prof
+}
+
+// charge charges the time since the last event on t to the stack t had then.
+func (p *profiler) charge(t *thread) {
+	if t.profSample == nil {
+		return
+	}
+	elapsed := time.Since(t.profMark)
+	p.mu.Lock()
+	if t.profGen == p.gen {
+		t.profSample.nanos += int64(elapsed)
+	}
+	p.mu.Unlock()
+}
+
+// enter makes the current stack of t the one charged for the time until the
+// next event on t, counting it as run again if count is set. The time starts
+// once the sample is found, so the profiler's own work isn't charged.
+func (p *profiler) enter(t *thread, count bool) {
+	p.mu.Lock()
+	var s *profSample
+	if p.samples != nil {
+		for _, f := range t.frames {
+			key := profKey{
+				caller: s,
+				frame: profFrame{
+					name:     f.name,
+					entry:    f.entry,
+					pos:      token.Pos(atomic.LoadInt64(&f.pos)),
+					compiled: f.compiled,
+				},
+			}
+			next := p.samples[key]
+			if next == nil {
+				next = &profSample{profKey: key}
+				p.samples[key] = next
+			}
+			s = next
+		}
+	}
+	if s != nil && count {
+		s.count++
+	}
+	t.profGen = p.gen
+	p.mu.Unlock()
+	t.profSample = s
+	t.profMark = time.Now()
+}
+
+// stmt is called by enterStmt, after noting the statement about to run, while profiling.
+func (p *profiler) stmt(t *thread) {
+	p.charge(t)
+	p.enter(t, true)
+}
+
+// leave is called when t stops running code, so the time since the last event
+// is charged.
+func (p *profiler) leave(t *thread) {
+	p.charge(t)
+	t.profSample = nil
+}
+
+// Lines totals the samples of the profile by the line of their statement,
+// most time first.
+func (prof *Profile) Lines() []ProfileEntry {
+	return prof.total(func(s ProfileSample) (string, bool) {
+		if len(s.Stack) == 0 || s.Stack[0].Compiled {
+			return "", false
+		}
+		pos := s.Stack[0].Pos
+		return fmt.Sprintf("%s:%d", pos.Filename, pos.Line), true
+	})
+}
+
+// Funcs totals the samples of the profile by compiled function, most time first.
+func (prof *Profile) Funcs() []ProfileEntry {
+	return prof.total(func(s ProfileSample) (string, bool) {
+		if len(s.Stack) == 0 || !s.Stack[0].Compiled {
+			return "", false
+		}
+		return s.Stack[0].Func, true
+	})
+}
+
+// total totals the samples by the name key gives them, leaving out samples it rejects.
+func (prof *Profile) total(key func(ProfileSample) (string, bool)) []ProfileEntry {
+	totals := map[string]*ProfileEntry{}
+	for _, s := range prof.Samples {
+		name, ok := key(s)
+		if !ok {
+			continue
+		}
+		e := totals[name]
+		if e == nil {
+			e = &ProfileEntry{Name: name}
+			totals[name] = e
+		}
+		e.Count += s.Count
+		e.Time += s.Time
+	}
+	entries := make([]ProfileEntry, 0, len(totals))
+	for _, e := range totals {
+		entries = append(entries, *e)
+	}
+	sort.Sort(byTime(entries))
+	return entries
+}
+
+type byTime []ProfileEntry
+
+func (es byTime) Len() int      { return len(es) }
+func (es byTime) Swap(i, j int) { es[i], es[j] = es[j], es[i] }
+func (es byTime) Less(i, j int) bool {
+	if es[i].Time != es[j].Time {
+		return es[i].Time > es[j].Time
+	}
+	return es[i].Name < es[j].Name
+}
@@ -0,0 +1,122 @@
+package interp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// profileWork profiles a loop calling the compiled function work, which sleeps
+// for the number of milliseconds it's given.
+func profileWork(t *testing.T) *Profile {
+	i := New(Options{})
+	work := func(n int) int {
+		time.Sleep(time.Duration(n) * time.Millisecond)
+		return n
+	}
+	if err := i.Define("work", work); err != nil {
+		t.Fatal(err)
+	}
+	i.StartProfile()
+	if _, err := i.Eval("s := 0\nfor k := 0; k < 3; k++ {\ns += work(2)\n}"); err != nil {
+		t.Fatal(err)
+	}
+	prof := i.StopProfile()
+	if prof == nil {
+		t.Fatal("StopProfile returned nil")
+	}
+	return prof
+}
+
+func TestProfileTotals(t *testing.T) {
+	prof := profileWork(t)
+
+	lines := map[string]ProfileEntry{}
+	for _, e := range prof.Lines() {
+		lines[e.Name] = e
+	}
+	for name, count := range map[string]int64{"input:1": 1, "input:3": 3} {
+		if e := lines[name]; e.Count != count {
+			t.Errorf("line %s ran %d times, want %d", name, e.Count, count)
+		}
+	}
+
+	funcs := prof.Funcs()
+	if len(funcs) != 1 || funcs[0].Name != "work" || funcs[0].Count != 3 {
+		t.Fatalf("Funcs() = %v, want work called 3 times", funcs)
+	}
+	// The time in work isn't charged to the line calling it
+	if funcs[0].Time < 6*time.Millisecond {
+		t.Errorf("work took %v, want at least 6ms", funcs[0].Time)
+	}
+	if line := lines["input:3"]; line.Time >= funcs[0].Time {
+		t.Errorf("line 3 took %v, as long as work's %v", line.Time, funcs[0].Time)
+	}
+}
+
+func TestWritePprof(t *testing.T) {
+	prof := profileWork(t)
+	var buf bytes.Buffer
+	if err := prof.WritePprof(&buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Read the fields of the Profile message
+	var samples int
+	strs := map[string]bool{}
+	for len(data) > 0 {
+		key, n := readVarint(t, data)
+		data = data[n:]
+		switch key & 7 {
+		case 0:
+			_, n = readVarint(t, data)
+			data = data[n:]
+		case 2:
+			size, n := readVarint(t, data)
+			data = data[n:]
+			if uint64(len(data)) < size {
+				t.Fatalf("field %d: %d bytes left, want %d", key>>3, len(data), size)
+			}
+			switch key >> 3 {
+			case 2: // sample
+				samples++
+			case 6: // string_table
+				strs[string(data[:size])] = true
+			}
+			data = data[size:]
+		default:
+			t.Fatalf("field %d has wire type %d", key>>3, key&7)
+		}
+	}
+	if samples != len(prof.Samples) {
+		t.Errorf("wrote %d samples, want %d", samples, len(prof.Samples))
+	}
+	for _, s := range []string{"samples", "count", "time", "nanoseconds", "work", "(compiled)", "input"} {
+		if !strs[s] {
+			t.Errorf("string table is missing %q", s)
+		}
+	}
+}
+
+// readVarint reads a varint from the start of data, returning it and its length.
+func readVarint(t *testing.T, data []byte) (uint64, int) {
+	var x uint64
+	for n, b := range data {
+		x |= uint64(b&0x7f) << (7 * uint(n))
+		if b < 0x80 {
+			return x, n + 1
+		}
+	}
+	t.Fatal("truncated varint")
+	return 0, 0
+}
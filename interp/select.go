@@ -3,70 +3,160 @@ package interp
 import (
 	"go/ast"
 	"go/token"
-	"log"
 	"reflect"
+
+	"golang.org/x/tools/go/types"
 )
 
 // reflect.Select takes a []reflect.SelectCase, which includes a channel, a direction,
 // the value to send (if sending), and does the select. It returns the index of the
 // chosen case and the two results of the receive (if a receive case was chosen).
 
-// We'll keep another slice of select case contexts, encapsulating the stmtlist associated
-// with each case (which can be length 0). In the receive case, the context must also include
+// We'll keep another slice of compiled select cases, with the channel and value to
+// send for the reflect.SelectCase and the statement list to run if the case is
+// chosen (which can be length 0). In the receive case, it must also include
 // information about what to do with the receive results:
 //  * short decl, assignment, or neither?
 //  * zero, one, or two expressions to declare or assign to
 
-type selectCaseContext struct {
-	lhs   []ast.Expr  // left-hand side expressions of assignment in recv stmt (nil if not applicable)
-	tok   token.Token // DEFINE or ASSIGN in recv stmt (ILLEGAL if not applicable)
-	stmts []ast.Stmt  // statement list to execute if case is chosen
+type selectCase struct {
+	dir  reflect.SelectDir
+	ch   evalFunc // the channel, or nil for the default case
+	send evalFunc // the value to send (nil if not applicable)
+
+	block *block         // the block of the case's variables
+	lhs   []assignTarget // left-hand side of assignment in recv stmt (nil if not applicable)
+	stmts []execFunc     // statement list to execute if case is chosen
 }
 
-func (env *environ) runSelect(clauses []ast.Stmt, cases []reflect.SelectCase, ctxs []selectCaseContext) stmtResult {
-	chosen, recv, recvOK := reflect.Select(cases)
-	ctx := ctxs[chosen]
-	clause := clauses[chosen]
+// selectStmt compiles a select statement with the given label.
+func (c *compiler) selectStmt(stmt *ast.SelectStmt, label string) execFunc {
+	clauses := stmt.Body.List
+	cases := make([]selectCase, len(clauses))
+	for j, clause := range clauses {
+		clause := clause.(*ast.CommClause)
+		sc := &cases[j]
+		var lhs []ast.Expr
+		tok := token.ILLEGAL
+		switch commStmt := clause.Comm.(type) {
+		case nil:
+			sc.dir = reflect.SelectDefault
+		case *ast.SendStmt:
+			sc.dir = reflect.SelectSend
+			sc.ch = c.expr(commStmt.Chan)
+			sc.send = c.send(commStmt)
+		default:
+			sc.dir = reflect.SelectRecv
 
-	// Create new environment that "inherits" from env
-	caseEnv := &environ{
-		info:   env.info,
-		interp: env.interp,
-		scope:  env.info.Scopes[clause],
-		parent: env,
-		objs:   map[string]Object{},
+			// Extract the recv expression and set lhs and tok if applicable
+			var recvExpr ast.Expr
+			switch stmt := commStmt.(type) {
+			case *ast.ExprStmt:
+				recvExpr = stmt.X
+			case *ast.AssignStmt:
+				lhs = stmt.Lhs
+				tok = stmt.Tok
+				recvExpr = stmt.Rhs[0] // Must only be one, from spec
+			}
+			// Drill down into paren exprs
+		removeParens:
+			for {
+				switch expr := recvExpr.(type) {
+				case *ast.ParenExpr:
+					// Remove parens
+					recvExpr = expr.X
+				default:
+					// If it's not a ParenExpr, we're done
+					break removeParens
+				}
+			}
+
+			// The channel operand of the receive expression is evaluated before
+			// selecting
+			sc.ch = c.expr(recvExpr.(*ast.UnaryExpr).X)
+		}
+
+		// The assignment and the statement list are in the case's own scope
+		sc.block = c.enter(c.info.Scopes[clause])
+		if tok != token.ILLEGAL {
+			sc.lhs = c.assignTargets(lhs, tok)
+		}
+		sc.stmts = c.stmtList(clause.Body, false)
+		c.leave(sc.block)
 	}
 
-	if cases[chosen].Dir == reflect.SelectRecv {
-		var lhs []Object
-		var mapIndexExprs map[int]bool
-		switch ctx.tok {
-		// Handle  short decl or assignment, if it exists (only possible in recv)
-		case token.DEFINE:
-			lhs = caseEnv.getDeclVars(ctx.lhs)
-		case token.ASSIGN:
-			lhs, mapIndexExprs = caseEnv.getAssignmentLhs(ctx.lhs)
+	return func(env *environ) stmtResult {
+		// Need to set up the reflect.SelectCases to pass to selectCases
+		rcases := make([]reflect.SelectCase, len(cases))
+		for j, sc := range cases {
+			rcases[j].Dir = sc.dir
+			if sc.ch != nil {
+				rcases[j].Chan = sc.ch(env).Value.(reflect.Value)
+			}
+			if sc.send != nil {
+				rcases[j].Send = sc.send(env).Value.(reflect.Value)
+			}
+		}
+		return env.runSelect(cases, rcases, label)
+	}
+}
+
+// send compiles the value sent by a send statement in a select case, which may
+// be untyped nil.
+func (c *compiler) send(stmt *ast.SendStmt) evalFunc {
+	value := c.expr(stmt.Value)
+	elemTyp := c.info.TypeOf(stmt.Chan).Underlying().(*types.Chan).Elem()
+	rTyp, _ := getReflectType(c.interp.typeMap, elemTyp)
+	if rTyp == nil {
+		return c.notImplemented(stmt, "Channels of %s not implemented yet", elemTyp)
+	}
+	return func(env *environ) Object {
+		sendObj := value(env)
+		if _, ok := sendObj.Value.(reflect.Value); ok {
+			return sendObj
+		}
+		// Must be untyped nil
+		return Object{
+			Value: reflect.Zero(rTyp),
+			Typ:   elemTyp,
+		}
+	}
+}
+
+// runSelect runs a select statement with the given label, whose cases have been
+// evaluated to rcases. A break out of the statement ends it, and other results
+// of the chosen case's statements are the result of the statement.
+func (env *environ) runSelect(cases []selectCase, rcases []reflect.SelectCase, label string) stmtResult {
+	chosen, recv, recvOK := env.thread.selectCases("select", rcases)
+	sc := cases[chosen]
+
+	// Create new environment that "inherits" from env
+	caseEnv := sc.block.newEnv(env)
+
+	if sc.lhs != nil {
+		// Handle short decl or assignment, if it exists (only possible in recv)
+		lhs := make([]Object, len(sc.lhs))
+		for j, target := range sc.lhs {
+			if target.get != nil {
+				lhs[j] = target.get(caseEnv)
+			}
 		}
 
 		// Get the RHS
 		rhs := []Object{{Value: recv}, {Value: reflect.ValueOf(recvOK)}} // Typ and Sim don't matter
 
 		// Do the assignment
-		for i, _ := range lhs {
-			if mapIndexExprs[i] {
-				env.assignMapIndex(ctx.lhs[i], rhs[i])
-			} else {
-				assignObj(lhs[i], rhs[i])
-			}
+		for j := range sc.lhs {
+			sc.lhs[j].assign(caseEnv, lhs[j], rhs[j])
 		}
 	}
 	// In any case, run the statement list
-	for _, stmt := range ctx.stmts {
-		// TODO: Handle label
-		stmtRes := caseEnv.runStmt(stmt, "", false)
-		if stmtRes != nil {
-			// TODO: Handle stmtRes
-			log.Fatal("return/break from select statement not yet implemented")
+	for _, stmt := range sc.stmts {
+		if stmtRes := stmt(caseEnv); stmtRes != nil {
+			if brk, ok := stmtRes.(breakResult); ok && (brk == "" || string(brk) == label) {
+				return nil
+			}
+			return stmtRes
 		}
 	}
 	return nil
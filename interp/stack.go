@@ -0,0 +1,177 @@
+package interp
+
+import (
+	"bytes"
+	"fmt"
+	"go/token"
+	"reflect"
+	"runtime"
+	"sync/atomic"
+)
+
+// A frame is a call of an interpreted function on a thread, or a call of a
+// compiled function from interpreted code, which may in turn call interpreted
+// functions on the same thread.
+type frame struct {
+	name     string    // "func literal", "top level", or the compiled function called
+	entry    token.Pos // the function literal, for interpreted functions
+	pos      int64     // token.Pos of the statement being run; accessed atomically
+	compiled bool
+
+	line int // the line of the last statement the debugger looked at
+
+	defers []func(t *thread) // calls deferred by an interpreted function
+}
+
+// Frame describes a call on the stack of interpreted code.
+type Frame struct {
+	// Func is "func literal" or "top level" for interpreted code, and the
+	// expression that named the function for compiled functions.
+	Func     string
+	Compiled bool
+
+	// For interpreted code, the function literal and the statement being run
+	Entry token.Position
+	Pos   token.Position
+}
+
+// A PanicError is returned by Run and Eval when code panics without recovering.
+// It holds the stack of the goroutine at the panic, innermost call first.
+type PanicError struct {
+	Value interface{}
+	Stack []Frame
+
+	// For panics in goroutines started by go statements, the goroutine's id
+	// and the position of the call it started with
+	Goroutine int64
+	Start     token.Position
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Trace returns the panic and the stack in the style of the Go runtime.
+func (e *PanicError) Trace() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "panic: %v\n\n", e.Value)
+	if e.Goroutine != 0 {
+		fmt.Fprintf(&buf, "goroutine %d [running]:\n", e.Goroutine)
+	}
+	for _, f := range e.Stack {
+		fmt.Fprintln(&buf, f)
+	}
+	if e.Goroutine != 0 {
+		fmt.Fprintf(&buf, "created by go statement at %s\n", e.Start)
+	}
+	return buf.String()
+}
+
+// String returns the frame as it appears in a Go stack trace: the function on
+// one line, and where it is on the next, indented.
+func (f Frame) String() string {
+	switch {
+	case f.Compiled:
+		return fmt.Sprintf("%s(...)\n\t(compiled)", f.Func)
+	case f.Entry.IsValid():
+		return fmt.Sprintf("%s at %s\n\t%s", f.Func, f.Entry, f.Pos)
+	}
+	return fmt.Sprintf("%s\n\t%s", f.Func, f.Pos)
+}
+
+// push pushes f on the stack of the thread, returning the depth of the stack
+// before, for popTo.
+//
+// Callers pop when the call returns rather than in a defer, so that the stack
+// is still there when a panic reaches the bottom of the thread. Compiled code
+// may recover a panic from interpreted code it called, so calls pop to the
+// depth they started at rather than popping a single frame.
+func (t *thread) push(f *frame) int {
+	t.mu.Lock()
+	depth := len(t.frames)
+	t.frames = append(t.frames, f)
+	t.mu.Unlock()
+	return depth
+}
+
+func (t *thread) popTo(depth int) {
+	t.mu.Lock()
+	t.frames = t.frames[:depth]
+	t.mu.Unlock()
+}
+
+// deferCall defers f until the interpreted function running on the thread returns.
+func (t *thread) deferCall(f func(t *thread)) {
+	fr := t.frames[len(t.frames)-1]
+	fr.defers = append(fr.defers, f)
+}
+
+// runDeferred runs the calls deferred by the function of fr, the last first.
+// Each is removed before it runs, so a panic in one doesn't run it again.
+func (t *thread) runDeferred(fr *frame) {
+	for n := len(fr.defers); n > 0; n = len(fr.defers) {
+		f := fr.defers[n-1]
+		fr.defers = fr.defers[:n-1]
+		f(t)
+	}
+}
+
+// at records that the thread is running the statement at pos.
+func (t *thread) at(pos token.Pos) {
+	// Only this thread changes its frames, so it doesn't need the lock to read them
+	if n := len(t.frames); n > 0 {
+		atomic.StoreInt64(&t.frames[n-1].pos, int64(pos))
+	}
+}
+
+// stack returns the stack of the thread, innermost call first.
+func (t *thread) stack(fset *token.FileSet) []Frame {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stack := make([]Frame, len(t.frames))
+	for j, f := range t.frames {
+		sf := Frame{
+			Func:     f.name,
+			Compiled: f.compiled,
+		}
+		if !f.compiled {
+			sf.Entry = fset.Position(f.entry)
+			sf.Pos = fset.Position(token.Pos(atomic.LoadInt64(&f.pos)))
+		}
+		stack[len(stack)-1-j] = sf
+	}
+	return stack
+}
+
+// isMakeFunc reports whether fun was made by reflect.MakeFunc.
+func isMakeFunc(fun reflect.Value) bool {
+	f := runtime.FuncForPC(fun.Pointer())
+	return f != nil && f.Name() == "reflect.makeFuncStub"
+}
+
+// callCompiled calls fun, named name, with a frame for it on the stack of t. The
+// arguments are passed as by callFunWithObjs.
+func (i *interp) callCompiled(t *thread, name string, fun reflect.Value, argObjs []Object, slice bool) []reflect.Value {
+	if isMakeFunc(fun) {
+		// Most likely an interpreted function, which pushes its own frame
+		return i.callFunWithObjs(fun, argObjs, slice)
+	}
+	depth := t.push(&frame{
+		name:     name,
+		compiled: true,
+	})
+	if i.profiling() {
+		i.profiler.charge(t)
+		i.profiler.enter(t, true)
+	}
+	results := i.callFunWithObjs(fun, argObjs, slice)
+	if i.profiling() {
+		i.profiler.charge(t)
+	}
+	t.popTo(depth)
+	if i.profiling() {
+		// Back to charging the statement that made the call
+		i.profiler.enter(t, false)
+	}
+	return results
+}
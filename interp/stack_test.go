@@ -0,0 +1,69 @@
+package interp
+
+import (
+	"bytes"
+	"testing"
+)
+
+// The trace of a panic has the interpreted and compiled calls it went through.
+func TestPanicTrace(t *testing.T) {
+	var stderr bytes.Buffer
+	i := New(Options{Stderr: &stderr})
+	if err := i.Define("call", func(f func()) { f() }); err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Define("fail", func() { panic("boom") }); err != nil {
+		t.Fatal(err)
+	}
+	for _, src := range []string{
+		"boom := func() {\nfail()\n}",
+		"outer := func() {\ncall(boom)\n}",
+	} {
+		if _, err := i.Eval(src); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := i.Eval("outer()")
+	perr, ok := err.(*PanicError)
+	if !ok {
+		t.Fatalf("got error %v, want a *PanicError", err)
+	}
+	want := `panic: boom
+
+fail(...)
+	(compiled)
+func literal at input:1
+	input:2
+call(...)
+	(compiled)
+func literal at input:4
+	input:5
+top level
+	input:7
+`
+	if got := perr.Trace(); got != want {
+		t.Errorf("got trace:\n%s\nwant:\n%s", got, want)
+	}
+
+	// A goroutine's trace is written to Stderr, and says where it started. The
+	// input that panicked isn't kept, so the next starts at line 7 again.
+	if _, err := i.Eval("go func() {\nboom()\n}()"); err != nil {
+		t.Fatal(err)
+	}
+	waitGoroutines(t, i, 0)
+	want = `panic: boom
+
+goroutine 1 [running]:
+fail(...)
+	(compiled)
+func literal at input:1
+	input:2
+func literal at input:7
+	input:8
+created by go statement at input:7
+`
+	if got := stderr.String(); got != want {
+		t.Errorf("in a goroutine: got trace:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -1,14 +1,10 @@
 package interp
 
 import (
-	"fmt"
 	"go/ast"
 	"go/token"
-	"log"
 	"reflect"
-
-	"golang.org/x/tools/go/exact"
-	"golang.org/x/tools/go/types"
+	"sync/atomic"
 )
 
 type stmtResult interface {
@@ -23,14 +19,39 @@ func (r returnResult) stmtResult()   {}
 func (r breakResult) stmtResult()    {}
 func (r continueResult) stmtResult() {}
 
-func (env *environ) runStmt(stmt ast.Stmt, label string, topLevel bool) stmtResult {
+// enterStmt is called before running stmt in env. It counts a step, notes the
+// position of the statement, and lets the debugger, tracer and profiler see it.
+func (env *environ) enterStmt(stmt ast.Stmt) {
+	env.thread.step()
+	env.thread.at(stmt.Pos())
+	if atomic.LoadInt32(&env.interp.debugger.active) != 0 {
+		env.interp.stmt(env, stmt)
+	}
+	if env.tracing() {
+		env.traceStmt(stmt)
+	}
+	if env.interp.profiling() {
+		env.interp.profiler.stmt(env.thread)
+	}
+}
+
+// stmt compiles a statement with the given label. Top-level statements are the
+// statements of an input, rather than of a block or function.
+func (c *compiler) stmt(stmt ast.Stmt, label string, topLevel bool) execFunc {
+	run := c.stmtBody(stmt, label, topLevel)
+	return func(env *environ) stmtResult {
+		env.enterStmt(stmt)
+		return run(env)
+	}
+}
+
+func (c *compiler) stmtBody(stmt ast.Stmt, label string, topLevel bool) execFunc {
 	switch stmt := stmt.(type) {
 	case *ast.ReturnStmt:
-		if topLevel {
-			log.Fatal("Return from top-level not allowed")
+		results := c.exprs(stmt.Results)
+		return func(env *environ) stmtResult {
+			return returnResult(results(env))
 		}
-		resObjs := env.evalExprs(stmt.Results)
-		return returnResult(resObjs)
 	case *ast.BranchStmt:
 		label := ""
 		if stmt.Label != nil {
@@ -38,244 +59,242 @@ func (env *environ) runStmt(stmt ast.Stmt, label string, topLevel bool) stmtResu
 		}
 		switch stmt.Tok {
 		case token.BREAK:
-			return breakResult(label)
+			return func(env *environ) stmtResult {
+				return breakResult(label)
+			}
 		case token.CONTINUE:
-			return continueResult(label)
+			return func(env *environ) stmtResult {
+				return continueResult(label)
+			}
 		case token.GOTO:
-			log.Fatal("Goto statements not implemented")
+			return c.execNotImplemented(stmt, "Goto statements not implemented yet")
 		case token.FALLTHROUGH:
-			log.Fatal("Fallthrough statements not implemented")
+			return c.execNotImplemented(stmt, "Fallthrough statements not implemented yet")
 		}
 	case *ast.AssignStmt:
-		// First, get LHS
-		var lhs []Object
-		var mapIndexExprs map[int]bool
-
-		switch stmt.Tok {
-		case token.DEFINE:
-			// Short variable declaration
-			lhs = env.getDeclVars(stmt.Lhs)
-		default:
-			// Normal assignment or assignment operation (= or op=)
-			lhs, mapIndexExprs = env.getAssignmentLhs(stmt.Lhs)
-		}
-
-		// Second, evaluate RHS
-		rhs := env.evalExprs(stmt.Rhs)
-
-		// Do assignment operation if applicable
-		if stmt.Tok != token.DEFINE && stmt.Tok != token.ASSIGN {
-			// The spec guarantees that there is exactly one lhs and rhs
-			op := assignOps[stmt.Tok]
-			obj := doBinaryOp(env, lhs[0], rhs[0], op)
-
-			// Just replace the rhs with the result of the binary op
-			// Then process it as a normal assignment
-			rhs[0] = obj
-		}
-
-		// Finally, do the assignment
-		for i, _ := range lhs {
-			if mapIndexExprs[i] {
-				env.assignMapIndex(stmt.Lhs[i], rhs[i])
-			} else {
-				assignObj(lhs[i], rhs[i])
-			}
-		}
+		return c.assignStmt(stmt)
 	case *ast.IncDecStmt:
-		obj := env.Eval(stmt.X)[0]
-		switch stmt.Tok {
-		case token.INC:
-			doInc(obj)
-		case token.DEC:
-			doDec(obj)
-		}
+		return c.incDec(stmt)
 	case *ast.ExprStmt:
 		// If we're not at top level, then only call expressions and receive operations are valid statements
 		if !topLevel {
 			if _, ok := stmt.X.(*ast.CallExpr); !ok {
 				// TODO: handle this error better
-				log.Fatal("Expression used as statement inappropriately")
+				return c.execNotImplemented(stmt, "Expression used as statement inappropriately")
 			}
 			// TODO: what about receive operations?
 		}
-		objs := env.Eval(stmt.X)
-		if topLevel {
-			for _, obj := range objs {
-				// TODO: do something better than print the results to stdout
-				switch v := obj.Value.(type) {
-				case reflect.Value:
-					fmt.Printf("=> %s: %v\n", TypeString(obj.Typ), v.Interface())
-				case exact.Value, nil:
-					fmt.Printf("=> %s: %v\n", TypeString(obj.Typ), v)
-				}
+		x := c.multi(stmt.X)
+		return func(env *environ) stmtResult {
+			objs := x(env)
+			if topLevel {
+				env.interp.results = append(env.interp.results, objs...)
 			}
+			return nil
 		}
 	case *ast.GoStmt:
-		callKind := env.getCallExprKind(stmt.Call)
-		if callKind == builtinKind {
-			env.evalBuiltinCall(stmt.Call, true)
+		var call multiFunc
+		if c.callKind(stmt.Call) == builtinKind {
+			call = c.builtinCall(stmt.Call, token.GO)
+		} else {
+			call = c.funcCall(stmt.Call, token.GO)
+		}
+		return func(env *environ) stmtResult {
+			call(env)
+			return nil
+		}
+	case *ast.DeferStmt:
+		var call multiFunc
+		if c.callKind(stmt.Call) == builtinKind {
+			call = c.builtinCall(stmt.Call, token.DEFER)
 		} else {
-			env.evalFuncCall(stmt.Call, true)
+			call = c.funcCall(stmt.Call, token.DEFER)
+		}
+		return func(env *environ) stmtResult {
+			call(env)
+			return nil
 		}
 	case *ast.SendStmt:
-		chanObj := env.Eval(stmt.Chan)[0]
-		sentObj := env.Eval(stmt.Value)[0]
-		chanVal := chanObj.Value.(reflect.Value)
-		sentVal := sentObj.Value.(reflect.Value)
-		chanVal.Send(sentVal)
+		ch := c.expr(stmt.Chan)
+		value := c.expr(stmt.Value)
+		return func(env *environ) stmtResult {
+			chanVal := ch(env).Value.(reflect.Value)
+			sentVal := value(env).Value.(reflect.Value)
+			env.thread.send(chanVal, sentVal)
+			return nil
+		}
 	case *ast.ForStmt:
-		// Set up scope and environment for the for statement
-		forScope := env.scope
-		forClauseEnv := env
+		return c.forStmt(stmt, label)
+	case *ast.IfStmt:
+		// Set up the block for the if statement, for variables declared by
+		// its init statement
+		b := c.enter(c.info.Scopes[stmt])
+		var init execFunc
 		if stmt.Init != nil {
-			forScope = env.info.Scopes[stmt]
-			forClauseEnv = &environ{
-				info:   env.info,
-				interp: env.interp,
-				scope:  forScope,
-				parent: env,
-				objs:   map[string]Object{},
+			init = c.stmt(stmt.Init, "", false)
+		}
+		cond := c.cond(stmt.Cond)
+		body := c.stmt(stmt.Body, "", false)
+		var els execFunc
+		if stmt.Else != nil {
+			els = c.stmt(stmt.Else, "", false)
+		}
+		c.leave(b)
+		return func(env *environ) stmtResult {
+			ifClauseEnv := b.newEnv(env)
+			if init != nil {
+				init(ifClauseEnv)
+			}
+			if cond(ifClauseEnv) {
+				return body(ifClauseEnv)
 			}
-			forClauseEnv.runStmt(stmt.Init, "", false)
+			if els != nil {
+				return els(ifClauseEnv)
+			}
+			return nil
 		}
-		for {
-			if stmt.Cond != nil {
-				condObj := forClauseEnv.Eval(stmt.Cond)[0]
-				if !condObj.Value.(reflect.Value).Bool() {
-					break
+	case *ast.SelectStmt:
+		return c.selectStmt(stmt, label)
+	case *ast.BlockStmt:
+		b := c.enter(c.info.Scopes[stmt])
+		list := c.stmtList(stmt.List, false)
+		c.leave(b)
+		return func(env *environ) stmtResult {
+			blockEnv := b.newEnv(env)
+			for _, st := range list {
+				if stmtRes := st(blockEnv); stmtRes != nil {
+					return stmtRes
 				}
 			}
-			if stmtRes := forClauseEnv.runStmt(stmt.Body, "", false); stmtRes != nil {
-				switch stmtRes := stmtRes.(type) {
-				case breakResult:
-					if string(stmtRes) == "" || string(stmtRes) == label {
-						return nil
-					}
-				case continueResult:
-					if string(stmtRes) == "" || string(stmtRes) == label {
-						if stmt.Post != nil {
-							forClauseEnv.runStmt(stmt.Post, "", false)
-						}
-						continue
+			return nil
+		}
+	}
+	return c.execNotImplemented(stmt, "Statement %T not implemented yet", stmt)
+}
+
+// assignStmt compiles an assignment, short variable declaration or assignment
+// operation.
+func (c *compiler) assignStmt(stmt *ast.AssignStmt) execFunc {
+	// First, get LHS
+	targets := c.assignTargets(stmt.Lhs, stmt.Tok)
+
+	// Assigning a single value, which most assignments do. Variables of basic
+	// types are set in place.
+	if len(targets) == 1 && len(stmt.Rhs) == 1 {
+		target := targets[0]
+		op := token.ILLEGAL
+		if stmt.Tok != token.DEFINE && stmt.Tok != token.ASSIGN {
+			op = assignOps[stmt.Tok]
+		}
+		if target.get != nil && target.setMap == nil {
+			if set := c.assignBasic(target.get, c.info.TypeOf(stmt.Lhs[0]), op, stmt.Rhs[0]); set != nil {
+				return func(env *environ) stmtResult {
+					lObj := set(env)
+					if env.tracing() {
+						env.traceAssign(stmt.Lhs, []Object{lObj})
 					}
+					return nil
 				}
-				return stmtRes
-			}
-			if stmt.Post != nil {
-				forClauseEnv.runStmt(stmt.Post, "", false)
 			}
 		}
-	case *ast.IfStmt:
-		// Set up scope and environment for the for statement
-		ifScope := env.scope
-		ifClauseEnv := env
-		if stmt.Init != nil {
-			ifScope = env.info.Scopes[stmt]
-			ifClauseEnv = &environ{
-				info:   env.info,
-				interp: env.interp,
-				scope:  ifScope,
-				parent: env,
-				objs:   map[string]Object{},
-			}
-			ifClauseEnv.runStmt(stmt.Init, "", false)
-		}
-		var stmtRes stmtResult
-		condObj := ifClauseEnv.Eval(stmt.Cond)[0]
-		cond := false
-		if condVal, ok := condObj.Value.(reflect.Value); ok {
-			cond = condVal.Bool()
+
+		var rhs evalFunc
+		if op != token.ILLEGAL {
+			// Do assignment operation. The spec guarantees that there is
+			// exactly one lhs and rhs, so replace the rhs with the result of
+			// the binary op, then process it as a normal assignment.
+			rhs = c.operation(op, c.info.TypeOf(stmt.Lhs[0]), target.get, stmt.Rhs[0])
 		} else {
-			ev := condObj.Value.(exact.Value)
-			cond = exact.BoolVal(ev)
+			rhs = c.expr(stmt.Rhs[0])
 		}
-		if cond {
-			stmtRes = ifClauseEnv.runStmt(stmt.Body, "", false)
-		} else {
-			if stmt.Else != nil {
-				stmtRes = ifClauseEnv.runStmt(stmt.Else, "", false)
+		return func(env *environ) stmtResult {
+			var lObj Object
+			if target.get != nil {
+				lObj = target.get(env)
 			}
+			rObj := rhs(env)
+			target.assign(env, lObj, rObj)
+			if env.tracing() {
+				env.traceAssign(stmt.Lhs, []Object{rObj})
+			}
+			return nil
 		}
-		return stmtRes
-	case *ast.SelectStmt:
-		// Need to set up data structures to pass to env.runSelect
-		clauses := stmt.Body.List
-		cases := make([]reflect.SelectCase, len(clauses))
-		ctxs := make([]selectCaseContext, len(clauses))
-		for i, clause := range clauses {
-			clause := clause.(*ast.CommClause)
-			ctxs[i].stmts = clause.Body
-			switch commStmt := clause.Comm.(type) {
-			case nil:
-				cases[i].Dir = reflect.SelectDefault
-			case *ast.SendStmt:
-				cases[i].Dir = reflect.SelectSend
-				chanObj := env.Eval(commStmt.Chan)[0]
-				sendObj := env.Eval(commStmt.Value)[0]
-				cases[i].Chan = chanObj.Value.(reflect.Value)
-				switch sendVal := sendObj.Value.(type) {
-				case reflect.Value:
-					cases[i].Send = sendVal
-				default:
-					// Must be untyped nil
-					fmt.Println("sent untyped nil")
-					elemTyp := chanObj.Typ.Underlying().(*types.Chan).Elem()
-					rTyp, _ := getReflectType(env.interp.typeMap, elemTyp)
-					if rTyp == nil {
-						log.Fatal("Failed to obtain reflect.Type to represent type:", elemTyp)
-					}
-					cases[i].Send = reflect.Zero(rTyp)
-				}
-			default:
-				cases[i].Dir = reflect.SelectRecv
-
-				// Extract the recv expression and set lhs and tok in the context if applicable.
-				var recvExpr ast.Expr
-				switch stmt := commStmt.(type) {
-				case *ast.ExprStmt:
-					recvExpr = stmt.X
-				case *ast.AssignStmt:
-					ctxs[i].lhs = stmt.Lhs
-					ctxs[i].tok = stmt.Tok
-					recvExpr = stmt.Rhs[0] // Must only be one, from spec
-				}
-				// Drill down into paren exprs
-			removeParens:
-				for {
-					switch expr := recvExpr.(type) {
-					case *ast.ParenExpr:
-						// Remove parens
-						recvExpr = expr.X
-					default:
-						// If it's not a ParenExpr, we're done
-						break removeParens
-					}
-				}
+	}
 
-				// Evaluate the channel operand of the receive expression
-				chanExpr := recvExpr.(*ast.UnaryExpr).X
-				chanObj := env.Eval(chanExpr)[0]
-				cases[i].Chan = chanObj.Value.(reflect.Value)
+	// Second, evaluate RHS
+	rhs := c.exprs(stmt.Rhs)
+	return func(env *environ) stmtResult {
+		lhs := make([]Object, len(targets))
+		for j, target := range targets {
+			if target.get != nil {
+				lhs[j] = target.get(env)
 			}
 		}
-		env.runSelect(clauses, cases, ctxs)
-	case *ast.BlockStmt:
-		blockScope := env.info.Scopes[stmt]
-		blockEnv := &environ{
-			info:   env.info,
-			interp: env.interp,
-			scope:  blockScope,
-			parent: env,
-			objs:   map[string]Object{},
+		rObjs := rhs(env)
+		if len(targets) > 1 {
+			// The values may be those of the variables assigned, as in a, b = b, a
+			rObjs = copyObjs(rObjs)
 		}
-		for _, st := range stmt.List {
-			if stmtRes := blockEnv.runStmt(st, "", false); stmtRes != nil {
+
+		// Finally, do the assignment
+		for j := range targets {
+			targets[j].assign(env, lhs[j], rObjs[j])
+		}
+		if env.tracing() {
+			env.traceAssign(stmt.Lhs, rObjs)
+		}
+		return nil
+	}
+}
+
+// forStmt compiles a for statement with the given label.
+func (c *compiler) forStmt(stmt *ast.ForStmt, label string) execFunc {
+	// Set up the block for the for statement, for variables declared by its
+	// init statement
+	b := c.enter(c.info.Scopes[stmt])
+	var init, post execFunc
+	if stmt.Init != nil {
+		init = c.stmt(stmt.Init, "", false)
+	}
+	var cond func(env *environ) bool
+	if stmt.Cond != nil {
+		cond = c.cond(stmt.Cond)
+	}
+	body := c.stmt(stmt.Body, "", false)
+	if stmt.Post != nil {
+		post = c.stmt(stmt.Post, "", false)
+	}
+	c.leave(b)
+
+	return func(env *environ) stmtResult {
+		forClauseEnv := b.newEnv(env)
+		if init != nil {
+			init(forClauseEnv)
+		}
+		for {
+			if cond != nil && !cond(forClauseEnv) {
+				break
+			}
+			if stmtRes := body(forClauseEnv); stmtRes != nil {
+				switch stmtRes := stmtRes.(type) {
+				case breakResult:
+					if string(stmtRes) == "" || string(stmtRes) == label {
+						return nil
+					}
+				case continueResult:
+					if string(stmtRes) == "" || string(stmtRes) == label {
+						if post != nil {
+							post(forClauseEnv)
+						}
+						continue
+					}
+				}
 				return stmtRes
 			}
+			if post != nil {
+				post(forClauseEnv)
+			}
 		}
-	default:
-		log.Fatalf("Unhandled statement type: %T", stmt)
+		return nil
 	}
-	return nil
 }
@@ -0,0 +1,286 @@
+package interp
+
+import (
+	"context"
+	"fmt"
+	"go/token"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A thread holds the state of a goroutine running interpreted code. That's either
+// the goroutine calling Run, a goroutine started by a go statement, or a goroutine
+// started by compiled code that calls an interpreted function.
+//
+// Every environment running statements has the thread it runs on, and interpreted
+// code passes it to the interpreted functions it calls. Compiled code doesn't, so
+// when it calls an interpreted function, the function looks up the thread of the
+// goroutine it's running on.
+type thread struct {
+	ctx    context.Context
+	budget *budget
+
+	// For goroutines started by go statements, the id the interpreter gave the
+	// goroutine, which is 0 otherwise, and the position of the call it started with
+	id    int64
+	start token.Pos
+
+	// Whether the thread runs the top-level statements of an input, which is
+	// the only thread the debugger stops
+	input bool
+
+	// The time of the last event the profiler saw on the thread, and the
+	// sample to charge until the next one, of the profile numbered profGen
+	profMark   time.Time
+	profSample *profSample
+	profGen    int
+
+	// Only the thread changes its frames, but others may look at them
+	mu      sync.Mutex
+	frames  []*frame
+	blocked string // the channel operation the thread is blocked on, if any
+}
+
+// A budget tracks the resources used by the code of one input, including the
+// goroutines it starts, against the interpreter's limits.
+type budget struct {
+	limits     Limits
+	steps      int64 // accessed atomically
+	goroutines int64 // accessed atomically
+	running    int64 // the threads running on the budget, accessed atomically
+	cancel     context.CancelFunc
+}
+
+// start counts a thread starting to run on the budget.
+func (b *budget) start() {
+	atomic.AddInt64(&b.running, 1)
+}
+
+// done counts a thread finishing, canceling the context of the budget once
+// the input and every goroutine it started have finished.
+func (b *budget) done() {
+	if atomic.AddInt64(&b.running, -1) == 0 && b.cancel != nil {
+		b.cancel()
+	}
+}
+
+// unwind is panicked with to unwind the interpreter when the context of a
+// thread is done or it runs over its budget. It is recovered at the bottom
+// of the thread.
+type unwind struct {
+	err error
+}
+
+// step counts a statement or expression against the budget of the thread, and
+// unwinds the thread if it's over budget or its context is done.
+func (t *thread) step() {
+	if max := t.budget.limits.MaxSteps; max > 0 && atomic.AddInt64(&t.budget.steps, 1) > max {
+		panic(unwind{ErrStepLimit})
+	}
+	select {
+	case <-t.ctx.Done():
+		panic(unwind{t.ctx.Err()})
+	default:
+	}
+}
+
+// alloc unwinds the thread if allocating n values of type rtyp would go over the limit.
+func (t *thread) alloc(rtyp reflect.Type, n int) {
+	if max := t.budget.limits.MaxAlloc; max > 0 && int64(n)*int64(rtyp.Size()) > max {
+		panic(unwind{ErrAllocLimit})
+	}
+}
+
+// recv receives from ch, unless the context of the thread is done first.
+func (t *thread) recv(ch reflect.Value) (reflect.Value, bool) {
+	cases := []reflect.SelectCase{{Dir: reflect.SelectRecv, Chan: ch}}
+	_, recv, recvOK := t.selectCases("chan receive", cases)
+	return recv, recvOK
+}
+
+// send sends val on ch, unless the context of the thread is done first.
+func (t *thread) send(ch, val reflect.Value) {
+	cases := []reflect.SelectCase{{Dir: reflect.SelectSend, Chan: ch, Send: val}}
+	t.selectCases("chan send", cases)
+}
+
+// selectCases is reflect.Select, except that it stops waiting if the context
+// of the thread is done. While it waits, the thread is blocked on op.
+func (t *thread) selectCases(op string, cases []reflect.SelectCase) (int, reflect.Value, bool) {
+	hasDefault := false
+	for _, c := range cases {
+		hasDefault = hasDefault || c.Dir == reflect.SelectDefault
+	}
+	if !hasDefault {
+		// Try without waiting first, so we only say we're blocked when we are
+		defaultCase := reflect.SelectCase{Dir: reflect.SelectDefault}
+		chosen, recv, recvOK := reflect.Select(append(cases, defaultCase))
+		if chosen < len(cases) {
+			return chosen, recv, recvOK
+		}
+		t.setBlocked(op)
+		defer t.setBlocked("")
+	}
+
+	doneCase := reflect.SelectCase{
+		Dir:  reflect.SelectRecv,
+		Chan: reflect.ValueOf(t.ctx.Done()),
+	}
+	chosen, recv, recvOK := reflect.Select(append(cases, doneCase))
+	if chosen == len(cases) {
+		panic(unwind{t.ctx.Err()})
+	}
+	return chosen, recv, recvOK
+}
+
+func (t *thread) setBlocked(op string) {
+	t.mu.Lock()
+	t.blocked = op
+	t.mu.Unlock()
+}
+
+// info returns a description of the thread as a goroutine.
+func (t *thread) info(fset *token.FileSet) GoroutineInfo {
+	t.mu.Lock()
+	blocked := t.blocked
+	t.mu.Unlock()
+	g := GoroutineInfo{
+		ID:      t.id,
+		Start:   fset.Position(t.start),
+		Pos:     fset.Position(t.start),
+		Blocked: blocked,
+		Stack:   t.stack(fset),
+	}
+	for _, f := range g.Stack {
+		if !f.Compiled {
+			g.Pos = f.Pos
+			break
+		}
+	}
+	return g
+}
+
+// goid returns the id of the current goroutine.
+func goid() int64 {
+	var buf [64]byte
+	n := runtime.Stack(buf[:], false)
+	s := strings.TrimPrefix(string(buf[:n]), "goroutine ")
+	s = s[:strings.IndexByte(s, ' ')]
+	id, _ := strconv.ParseInt(s, 10, 64)
+	return id
+}
+
+// enterThread registers t as the thread of the current goroutine, returning a
+// function that restores the previous one.
+func (i *interp) enterThread(t *thread) (leave func()) {
+	id := goid()
+	i.threadsMu.Lock()
+	prev := i.threads[id]
+	i.threads[id] = t
+	i.threadsMu.Unlock()
+	return func() {
+		i.threadsMu.Lock()
+		if prev != nil {
+			i.threads[id] = prev
+		} else {
+			delete(i.threads, id)
+		}
+		i.threadsMu.Unlock()
+	}
+}
+
+// currentThread returns the thread of the current goroutine, creating one if this
+// is a goroutine started by compiled code. The returned function must be called
+// when done with the thread.
+func (i *interp) currentThread() (*thread, func()) {
+	id := goid()
+	i.threadsMu.Lock()
+	t := i.threads[id]
+	i.threadsMu.Unlock()
+	if t != nil {
+		return t, func() {}
+	}
+	t = &thread{
+		ctx:    context.Background(),
+		budget: &budget{limits: i.limits},
+	}
+	return t, i.enterThread(t)
+}
+
+// goroutine runs f in a new goroutine, started by a go statement with the call
+// at pos, on a new thread with the same context and budget as parent. The
+// goroutine is listed by Goroutines until f returns. If f panics, the panic is
+// reported on the interpreter's stderr rather than crashing the program.
+func (i *interp) goroutine(parent *thread, pos token.Pos, f func(t *thread)) {
+	b := parent.budget
+	if max := b.limits.MaxGoroutines; max > 0 && atomic.AddInt64(&b.goroutines, 1) > int64(max) {
+		panic(unwind{ErrGoroutineLimit})
+	}
+	t := &thread{
+		ctx:    parent.ctx,
+		budget: b,
+		start:  pos,
+	}
+	i.threadsMu.Lock()
+	i.lastGoroutineID++
+	t.id = i.lastGoroutineID
+	i.goroutines[t.id] = t
+	i.threadsMu.Unlock()
+
+	b.start()
+	go func() {
+		defer b.done()
+		leave := i.enterThread(t)
+		defer leave()
+		defer func() {
+			i.threadsMu.Lock()
+			delete(i.goroutines, t.id)
+			i.threadsMu.Unlock()
+			if i.profiling() {
+				i.profiler.leave(t)
+			}
+		}()
+		defer func() {
+			if r := recover(); r != nil {
+				if _, ok := r.(unwind); !ok {
+					e := &PanicError{
+						Value:     r,
+						Stack:     t.stack(i.fset),
+						Goroutine: t.id,
+						Start:     i.fset.Position(t.start),
+					}
+					fmt.Fprint(i.stderr, e.Trace())
+				}
+			}
+		}()
+		f(t)
+	}()
+}
+
+func (i *interp) Goroutines() []GoroutineInfo {
+	i.threadsMu.Lock()
+	threads := make([]*thread, 0, len(i.goroutines))
+	for _, t := range i.goroutines {
+		threads = append(threads, t)
+	}
+	i.threadsMu.Unlock()
+
+	gs := make([]GoroutineInfo, len(threads))
+	for j, t := range threads {
+		gs[j] = t.info(i.fset)
+	}
+	sort.Sort(byID(gs))
+	return gs
+}
+
+type byID []GoroutineInfo
+
+func (gs byID) Len() int           { return len(gs) }
+func (gs byID) Less(i, j int) bool { return gs[i].ID < gs[j].ID }
+func (gs byID) Swap(i, j int)      { gs[i], gs[j] = gs[j], gs[i] }
@@ -0,0 +1,67 @@
+package interp
+
+import (
+	"testing"
+	"time"
+)
+
+// waitGoroutines waits until i has n goroutines blocked, and returns them.
+func waitGoroutines(t *testing.T, i Interpreter, n int) []GoroutineInfo {
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		gs := i.Goroutines()
+		blocked := 0
+		for _, g := range gs {
+			if g.Blocked != "" {
+				blocked++
+			}
+		}
+		if len(gs) == n && blocked == n {
+			return gs
+		}
+	}
+	t.Fatalf("goroutines: got %v, want %d blocked", i.Goroutines(), n)
+	return nil
+}
+
+func TestGoroutines(t *testing.T) {
+	i := New(Options{})
+	for _, src := range []string{
+		"ch := make(chan int)",
+		"wait := func() {\n_ = <-ch\n}",
+		"go wait()\ngo func() { ch <- 1 }()",
+	} {
+		if _, err := i.Eval(src); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// One of the goroutines gets the value the other sends
+	gs := waitGoroutines(t, i, 0)
+	if len(gs) != 0 {
+		t.Fatalf("goroutines left: %v", gs)
+	}
+
+	if _, err := i.Eval("go wait()\ngo wait()"); err != nil {
+		t.Fatal(err)
+	}
+	gs = waitGoroutines(t, i, 2)
+	for j, g := range gs {
+		if j > 0 && g.ID <= gs[j-1].ID {
+			t.Errorf("goroutine %d has ID %d after %d", j, g.ID, gs[j-1].ID)
+		}
+		if g.Start.Line != 7+j {
+			t.Errorf("goroutine %d started at line %d, want %d", g.ID, g.Start.Line, 7+j)
+		}
+		if g.Pos.Line != 3 || g.Blocked != "chan receive" {
+			t.Errorf("goroutine %d is at line %d blocked on %q, want line 3 blocked on %q", g.ID, g.Pos.Line, g.Blocked, "chan receive")
+		}
+		if len(g.Stack) != 1 || g.Stack[0].Func != "func literal" || g.Stack[0].Entry.Line != 2 {
+			t.Errorf("goroutine %d has stack %v, want wait", g.ID, g.Stack)
+		}
+	}
+
+	if _, err := i.Eval("ch <- 1\nch <- 2"); err != nil {
+		t.Fatal(err)
+	}
+	waitGoroutines(t, i, 0)
+}
@@ -0,0 +1,153 @@
+package interp
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/printer"
+	"go/token"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/tools/go/exact"
+	"golang.org/x/tools/go/types"
+)
+
+// A TraceEvent is something code did while tracing: run a statement, assign
+// values, or return from a call. It encodes to JSON for machine-readable traces.
+type TraceEvent struct {
+	Kind      string         `json:"kind"` // "stmt", "assign" or "call"
+	Pos       token.Position `json:"pos"`
+	Goroutine int64          `json:"goroutine,omitempty"` // as in GoroutineInfo, 0 for the input
+	Depth     int            `json:"depth"`               // the number of interpreted calls under way
+
+	// Source is the statement run, the expression assigned to, or the call.
+	// Values are the values assigned or the results of the call.
+	Source string   `json:"source"`
+	Values []string `json:"values,omitempty"`
+}
+
+// String returns the event as a line of a trace, indented by its depth.
+func (e TraceEvent) String() string {
+	indent := strings.Repeat("  ", e.Depth)
+	switch e.Kind {
+	case "assign":
+		return fmt.Sprintf("%s\t%s%s = %s", e.Pos, indent, e.Source, strings.Join(e.Values, ", "))
+	case "call":
+		return fmt.Sprintf("%s\t%s%s => %s", e.Pos, indent, e.Source, strings.Join(e.Values, ", "))
+	}
+	return fmt.Sprintf("%s\t%s%s", e.Pos, indent, e.Source)
+}
+
+type tracer struct {
+	active int32 // whether there's a function to call; accessed atomically
+
+	mu sync.Mutex
+	f  func(TraceEvent)
+}
+
+func (i *interp) SetTracer(f func(TraceEvent)) {
+	t := &i.tracer
+	t.mu.Lock()
+	t.f = f
+	var active int32
+	if f != nil {
+		active = 1
+	}
+	atomic.StoreInt32(&t.active, active)
+	t.mu.Unlock()
+}
+
+// tracing reports whether code is being traced.
+func (env *environ) tracing() bool {
+	return atomic.LoadInt32(&env.interp.tracer.active) != 0
+}
+
+// trace sends an event about node to the tracer, if there still is one.
+func (env *environ) trace(kind string, node ast.Node, source string, values []string) {
+	i := env.interp
+	i.tracer.mu.Lock()
+	f := i.tracer.f
+	i.tracer.mu.Unlock()
+	if f == nil {
+		return
+	}
+
+	depth := 0
+	for _, fr := range env.thread.frames {
+		if !fr.compiled {
+			depth++
+		}
+	}
+	if env.thread.input {
+		// Not counting the top level
+		depth--
+	}
+	f(TraceEvent{
+		Kind:      kind,
+		Pos:       i.fset.Position(node.Pos()),
+		Goroutine: env.thread.id,
+		Depth:     depth,
+		Source:    source,
+		Values:    values,
+	})
+}
+
+// traceStmt traces running stmt. Only the first line of the statement is given,
+// since the statements in its body are traced as they run.
+func (env *environ) traceStmt(stmt ast.Stmt) {
+	if _, ok := stmt.(*ast.BlockStmt); ok {
+		return
+	}
+	var buf bytes.Buffer
+	printer.Fprint(&buf, env.interp.fset, stmt)
+	src := buf.String()
+	if i := strings.IndexByte(src, '\n'); i >= 0 {
+		src = strings.TrimSuffix(src[:i], " {")
+	}
+	env.trace("stmt", stmt, src, nil)
+}
+
+// traceAssign traces assigning the values of objs to exprs.
+func (env *environ) traceAssign(exprs []ast.Expr, objs []Object) {
+	for j, expr := range exprs {
+		if id, ok := expr.(*ast.Ident); ok && id.Name == "_" {
+			continue
+		}
+		env.trace("assign", expr, types.ExprString(expr), []string{formatObj(objs[j])})
+	}
+}
+
+// traceCall traces the results of call, if it has any.
+func (env *environ) traceCall(call *ast.CallExpr, results []Object) {
+	if len(results) == 0 {
+		return
+	}
+	values := make([]string, len(results))
+	for j, obj := range results {
+		values[j] = formatObj(obj)
+	}
+	env.trace("call", call, types.ExprString(call), values)
+}
+
+// formatObj formats the value of obj for a trace.
+func formatObj(obj Object) string {
+	switch v := obj.Value.(type) {
+	case reflect.Value:
+		if obj.Sim || !v.CanInterface() || v.Kind() == reflect.Func {
+			return fmt.Sprintf("<%s value>", TypeString(obj.Typ))
+		}
+		if v.Kind() == reflect.String {
+			return strconv.Quote(v.String())
+		}
+		return fmt.Sprint(v.Interface())
+	case exact.Value:
+		return ConstString(v)
+	case nil:
+		return "nil"
+	}
+	return fmt.Sprint(obj.Value)
+}
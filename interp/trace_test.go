@@ -0,0 +1,43 @@
+package interp
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTracer(t *testing.T) {
+	i := New(Options{})
+	if _, err := i.Eval("f := func(n int) int {\nm := n + 1\nreturn m\n}"); err != nil {
+		t.Fatal(err)
+	}
+	var events []string
+	i.SetTracer(func(e TraceEvent) {
+		events = append(events, fmt.Sprintf("%s %d %d %s %v", e.Kind, e.Pos.Line, e.Depth, e.Source, e.Values))
+	})
+	if _, err := i.Eval("x := f(1)"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"stmt 5 0 x := f(1) []",
+		"stmt 2 1 m := n + 1 []",
+		"assign 2 1 m [2]",
+		"stmt 3 1 return m []",
+		"call 5 0 f(1) [2]",
+		"assign 5 0 x [2]",
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("got events:\n%s\nwant:\n%s", strings.Join(events, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Nothing is traced once the tracer is removed
+	i.SetTracer(nil)
+	events = nil
+	if _, err := i.Eval("f(2)"); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Errorf("traced without a tracer: %q", events)
+	}
+}
@@ -1,8 +1,12 @@
 package interp
 
 import (
+	"fmt"
+	"go/token"
 	"log"
 	"reflect"
+	"strings"
+	"sync"
 
 	"golang.org/x/tools/go/types"
 	"golang.org/x/tools/go/types/typeutil"
@@ -12,13 +16,33 @@ import (
 //   * slice types
 //   * chan types
 //   * map types
-//   * array types (must be simulated)
+//   * array types
 
 var simFuncType reflect.Type
 
+// typeMapMu guards every typeutil.Map used to find reflect.Types. Even lookups
+// need it, since a typeutil.Map memoizes the hashes of types, and interpreted
+// goroutines look up types while the prompt adds them. A map may also be shared
+// by the interpreters and evaluators made with the same Options.
+var typeMapMu sync.Mutex
+
+// setReflectType maps typ to rtyp in typeMap.
+func setReflectType(typeMap *typeutil.Map, typ types.Type, rtyp reflect.Type) {
+	typeMapMu.Lock()
+	defer typeMapMu.Unlock()
+	typeMap.Set(typ, rtyp)
+}
+
+// lookupReflectType returns the reflect.Type typ is mapped to in typeMap, or nil.
+func lookupReflectType(typeMap *typeutil.Map, typ types.Type) reflect.Type {
+	typeMapMu.Lock()
+	defer typeMapMu.Unlock()
+	rt, _ := typeMap.At(typ).(reflect.Type)
+	return rt
+}
+
 func init() {
-	var simFunc func([]Object) []Object
-	simFuncType = reflect.TypeOf(simFunc)
+	simFuncType = reflect.TypeOf(simulatedFunc(nil))
 }
 
 func getReflectDir(dir types.ChanDir) reflect.ChanDir {
@@ -37,11 +61,16 @@ func getReflectDir(dir types.ChanDir) reflect.ChanDir {
 }
 
 func getReflectType(typeMap *typeutil.Map, typ types.Type) (reflect.Type, bool) {
-	rt := typeMap.At(typ)
+	rt := lookupReflectType(typeMap, typ)
 	if rt == nil {
-		// If it's a function type that isn't in typeMap, use a simulated function
 		switch typ := typ.(type) {
 		case *types.Signature:
+			// If it's a function type that isn't in typeMap, make one, unless some
+			// of its parameter or result types can only be simulated. Then use a
+			// simulated function.
+			if rtyp := getReflectFuncType(typeMap, typ); rtyp != nil {
+				return rtyp, false
+			}
 			return simFuncType, true
 		case *types.Pointer:
 			t, _ := getReflectType(typeMap, typ.Elem())
@@ -56,7 +85,7 @@ func getReflectType(typeMap *typeutil.Map, typ types.Type) (reflect.Type, bool)
 		case *types.Array:
 			elem, _ := getReflectType(typeMap, typ.Elem())
 			if elem != nil {
-				return reflect.SliceOf(elem), true
+				return reflect.ArrayOf(int(typ.Len()), elem), false
 			}
 		case *types.Chan:
 			elem, _ := getReflectType(typeMap, typ.Elem())
@@ -74,10 +103,41 @@ func getReflectType(typeMap *typeutil.Map, typ types.Type) (reflect.Type, bool)
 		}
 		return nil, false
 	}
-	return rt.(reflect.Type), false
+	return rt, false
+}
+
+// getReflectFuncType returns the reflect.Type of the function type sig, or nil if
+// it has a parameter or result type without one, or one that is simulated.
+func getReflectFuncType(typeMap *typeutil.Map, sig *types.Signature) reflect.Type {
+	in, ok := getReflectTypes(typeMap, sig.Params())
+	if !ok {
+		return nil
+	}
+	out, ok := getReflectTypes(typeMap, sig.Results())
+	if !ok {
+		return nil
+	}
+	return reflect.FuncOf(in, out, sig.Variadic())
+}
+
+// getReflectTypes returns the reflect.Types of the variables of tuple, and
+// whether they all have one that isn't simulated.
+func getReflectTypes(typeMap *typeutil.Map, tuple *types.Tuple) ([]reflect.Type, bool) {
+	rtyps := make([]reflect.Type, tuple.Len())
+	for j := range rtyps {
+		rtyp, sim := getReflectType(typeMap, tuple.At(j).Type())
+		if rtyp == nil || sim {
+			return nil, false
+		}
+		rtyps[j] = rtyp
+	}
+	return rtyps, true
 }
 
 func addBasicTypes(typeMap *typeutil.Map) {
+	typeMapMu.Lock()
+	defer typeMapMu.Unlock()
+
 	// bool
 	var xBool bool
 	typeMap.Set(types.Typ[types.Bool], reflect.TypeOf(xBool))
@@ -137,4 +197,247 @@ func addBasicTypes(typeMap *typeutil.Map) {
 	var xEmptyInterface interface{}
 	typEmptyInterface := types.NewInterface([]*types.Func{}, []*types.Named{})
 	typeMap.Set(typEmptyInterface, reflect.TypeOf(&xEmptyInterface).Elem())
+	// error
+	var xError error
+	typeMap.Set(types.Universe.Lookup("error").Type(), reflect.TypeOf(&xError).Elem())
+}
+
+// Map from reflect.Kind to the corresponding basic type, for the kinds that have one
+var basicKinds = map[reflect.Kind]types.BasicKind{
+	reflect.Bool:          types.Bool,
+	reflect.Int:           types.Int,
+	reflect.Int8:          types.Int8,
+	reflect.Int16:         types.Int16,
+	reflect.Int32:         types.Int32,
+	reflect.Int64:         types.Int64,
+	reflect.Uint:          types.Uint,
+	reflect.Uint8:         types.Uint8,
+	reflect.Uint16:        types.Uint16,
+	reflect.Uint32:        types.Uint32,
+	reflect.Uint64:        types.Uint64,
+	reflect.Uintptr:       types.Uintptr,
+	reflect.Float32:       types.Float32,
+	reflect.Float64:       types.Float64,
+	reflect.Complex64:     types.Complex64,
+	reflect.Complex128:    types.Complex128,
+	reflect.String:        types.String,
+	reflect.UnsafePointer: types.UnsafePointer,
+}
+
+// checkerTypesOf returns the reverse of typeMap, keeping the first types.Type
+// found for each reflect.Type.
+func checkerTypesOf(typeMap *typeutil.Map) map[reflect.Type]types.Type {
+	typeMapMu.Lock()
+	defer typeMapMu.Unlock()
+	checkerTypes := map[reflect.Type]types.Type{}
+	typeMap.Iterate(func(typ types.Type, rt interface{}) {
+		if _, ok := checkerTypes[rt.(reflect.Type)]; !ok {
+			checkerTypes[rt.(reflect.Type)] = typ
+		}
+	})
+	return checkerTypes
+}
+
+// getCheckerType does the reverse of getReflectType, returning a types.Type that
+// corresponds to rtyp. Types not found in typeMap are constructed and added to it.
+// A named type is looked up in its package if the type checker knows the package,
+// and is otherwise declared, along with its exported methods, in a new package
+// with the same path.
+func (i *interp) getCheckerType(rtyp reflect.Type) (types.Type, error) {
+	i.checkerTypesMu.Lock()
+	defer i.checkerTypesMu.Unlock()
+	return i.checkerType(rtyp)
+}
+
+// checkerType is getCheckerType with i.checkerTypesMu held.
+func (i *interp) checkerType(rtyp reflect.Type) (types.Type, error) {
+	if i.checkerTypes == nil {
+		i.checkerTypes = checkerTypesOf(i.typeMap)
+		i.hiddenPkgs = map[string]*types.Package{}
+	}
+	if typ, ok := i.checkerTypes[rtyp]; ok {
+		return typ, nil
+	}
+
+	if rtyp.Name() == "" || rtyp.PkgPath() == "" {
+		if obj := types.Universe.Lookup(rtyp.Name()); rtyp.Name() != "" && obj != nil {
+			// Predeclared type, like error
+			return obj.Type(), nil
+		}
+		typ, err := i.newCheckerType(rtyp, nil)
+		if err != nil {
+			return nil, err
+		}
+		i.checkerTypes[rtyp] = typ
+		setReflectType(i.typeMap, typ, rtyp)
+		return typ, nil
+	}
+
+	// Named type
+	path := rtyp.PkgPath()
+	pkg := i.checker.config.Packages[path]
+	if pkg != nil {
+		if obj, ok := pkg.Scope().Lookup(rtyp.Name()).(*types.TypeName); ok {
+			i.checkerTypes[rtyp] = obj.Type()
+			setReflectType(i.typeMap, obj.Type(), rtyp)
+			return obj.Type(), nil
+		}
+		// Not in the imported package, which we mustn't change, so declare it
+		// in a package of our own with the same path
+		pkg = i.hiddenPkgs[path]
+		if pkg == nil {
+			pkg = newFakePackage(path)
+			i.hiddenPkgs[path] = pkg
+		}
+	} else {
+		pkg = newFakePackage(path)
+		i.checker.config.Packages[path] = pkg
+	}
+	obj := types.NewTypeName(token.NoPos, pkg, rtyp.Name(), nil)
+	named := types.NewNamed(obj, nil, nil)
+	pkg.Scope().Insert(obj)
+	// Add it before constructing the underlying type, which may refer to it
+	i.checkerTypes[rtyp] = named
+	setReflectType(i.typeMap, named, rtyp)
+
+	und, err := i.newCheckerType(rtyp, pkg)
+	if err != nil {
+		return nil, err
+	}
+	named.SetUnderlying(und)
+
+	if rtyp.Kind() != reflect.Interface {
+		// The method set of *T includes the methods of T. Reflect gives us the
+		// receiver as the first parameter of each method type.
+		ptrRtyp := reflect.PtrTo(rtyp)
+		for j := 0; j < ptrRtyp.NumMethod(); j++ {
+			m := ptrRtyp.Method(j)
+			var recvTyp types.Type = named
+			if _, ok := rtyp.MethodByName(m.Name); !ok {
+				recvTyp = types.NewPointer(named)
+			}
+			recv := types.NewVar(token.NoPos, pkg, "", recvTyp)
+			sig, err := i.newSignature(m.Type, 1, recv)
+			if err != nil {
+				return nil, err
+			}
+			named.AddMethod(types.NewFunc(token.NoPos, pkg, m.Name, sig))
+		}
+	}
+	return named, nil
+}
+
+// newFakePackage returns a new, empty package with the given path, for
+// declaring types the type checker doesn't know.
+func newFakePackage(path string) *types.Package {
+	pkg := types.NewPackage(path, path[strings.LastIndex(path, "/")+1:])
+	pkg.MarkComplete()
+	return pkg
+}
+
+// newCheckerType constructs the types.Type with the same structure as rtyp. If rtyp
+// is a named type, pkg is the package it is declared in.
+func (i *interp) newCheckerType(rtyp reflect.Type, pkg *types.Package) (types.Type, error) {
+	if kind, ok := basicKinds[rtyp.Kind()]; ok {
+		return types.Typ[kind], nil
+	}
+	switch rtyp.Kind() {
+	case reflect.Array:
+		elem, err := i.checkerType(rtyp.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return types.NewArray(elem, int64(rtyp.Len())), nil
+	case reflect.Chan:
+		elem, err := i.checkerType(rtyp.Elem())
+		if err != nil {
+			return nil, err
+		}
+		var dir types.ChanDir
+		switch rtyp.ChanDir() {
+		case reflect.SendDir:
+			dir = types.SendOnly
+		case reflect.RecvDir:
+			dir = types.RecvOnly
+		default:
+			dir = types.SendRecv
+		}
+		return types.NewChan(dir, elem), nil
+	case reflect.Func:
+		return i.newSignature(rtyp, 0, nil)
+	case reflect.Interface:
+		var methods []*types.Func
+		for j := 0; j < rtyp.NumMethod(); j++ {
+			m := rtyp.Method(j)
+			sig, err := i.newSignature(m.Type, 0, nil)
+			if err != nil {
+				return nil, err
+			}
+			methods = append(methods, types.NewFunc(token.NoPos, pkg, m.Name, sig))
+		}
+		return types.NewInterface(methods, nil), nil
+	case reflect.Map:
+		key, err := i.checkerType(rtyp.Key())
+		if err != nil {
+			return nil, err
+		}
+		elem, err := i.checkerType(rtyp.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return types.NewMap(key, elem), nil
+	case reflect.Ptr:
+		elem, err := i.checkerType(rtyp.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return types.NewPointer(elem), nil
+	case reflect.Slice:
+		elem, err := i.checkerType(rtyp.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return types.NewSlice(elem), nil
+	case reflect.Struct:
+		fields := make([]*types.Var, rtyp.NumField())
+		tags := make([]string, rtyp.NumField())
+		for j := range fields {
+			f := rtyp.Field(j)
+			ft, err := i.checkerType(f.Type)
+			if err != nil {
+				return nil, err
+			}
+			fpkg := pkg
+			if f.PkgPath != "" && (fpkg == nil || fpkg.Path() != f.PkgPath) {
+				// Unexported field of an unnamed struct type
+				fpkg = types.NewPackage(f.PkgPath, f.PkgPath[strings.LastIndex(f.PkgPath, "/")+1:])
+			}
+			fields[j] = types.NewField(token.NoPos, fpkg, f.Name, ft, f.Anonymous)
+			tags[j] = string(f.Tag)
+		}
+		return types.NewStruct(fields, tags), nil
+	}
+	return nil, fmt.Errorf("unsupported type %v", rtyp)
+}
+
+// newSignature constructs the signature of the function type rtyp, skipping
+// the first skip parameters. The receiver of the signature is recv.
+func (i *interp) newSignature(rtyp reflect.Type, skip int, recv *types.Var) (*types.Signature, error) {
+	params := make([]*types.Var, rtyp.NumIn()-skip)
+	for j := range params {
+		pt, err := i.checkerType(rtyp.In(j + skip))
+		if err != nil {
+			return nil, err
+		}
+		params[j] = types.NewParam(token.NoPos, nil, "", pt)
+	}
+	results := make([]*types.Var, rtyp.NumOut())
+	for j := range results {
+		rt, err := i.checkerType(rtyp.Out(j))
+		if err != nil {
+			return nil, err
+		}
+		results[j] = types.NewParam(token.NoPos, nil, "", rt)
+	}
+	return types.NewSignature(recv, types.NewTuple(params...), types.NewTuple(results...), rtyp.IsVariadic()), nil
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -8,6 +9,8 @@ import (
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"text/template"
 
@@ -25,6 +28,12 @@ type Import struct {
 	Path      string
 }
 
+type byPath []Import
+
+func (s byPath) Len() int           { return len(s) }
+func (s byPath) Less(i, j int) bool { return s[i].Path < s[j].Path }
+func (s byPath) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
 type Package struct {
 	Path    string
 	Name    string
@@ -48,6 +57,7 @@ type Object struct {
 type Interp struct {
 	Imports  []Import
 	Packages []Package
+	Startup  string
 }
 
 func visitedType(typ types.Type) bool {
@@ -61,18 +71,163 @@ func (p *Package) AddType(typ types.Type, t Type) {
 
 var typeMap = new(typeutil.Map)
 
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: goconsole [-nostartup] [-f script] [packages]")
+	fmt.Fprintln(os.Stderr, "       goconsole build [-nostartup] [-config file] [-o output] [packages]")
+	fmt.Fprintln(os.Stderr, "       goconsole gen [-nostartup] [-config file] [-d dir] [packages]")
+	os.Exit(2)
+}
+
 func main() {
+	args := os.Args[1:]
+	if len(args) > 0 {
+		switch args[0] {
+		case "build":
+			buildCmd(args[1:])
+			return
+		case "gen":
+			genCmd(args[1:])
+			return
+		}
+	}
+	runCmd(args)
+}
+
+// runCmd generates the interpreter for the given packages and runs it
+// in the current terminal.
+func runCmd(args []string) {
+	flags := flag.NewFlagSet("goconsole", flag.ExitOnError)
+	flags.Usage = usage
+	noStartup := flags.Bool("nostartup", false, "do not run the startup code from configuration files")
+	scriptName := flags.String("f", "", "run the statements in this file instead of prompting")
+	flags.Parse(args)
+
+	// Only prompt if we have a terminal and no script to run
+	mode, err := liner.TerminalMode()
+	interactive := err == nil && *scriptName == ""
+
 	var cmdError error
 	defer func() {
-		fmt.Println()
+		if interactive {
+			fmt.Println()
+		}
 		if cmdError != nil {
 			os.Exit(1)
 		}
 	}()
 
+	workDir, err := ioutil.TempDir("", "goconsole")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(workDir)
+
+	cfg, err := loadConfigs()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fn := filepath.Join(workDir, "goconsole.go")
+	writeSource(fn, newInterp(cfg, flags.Args(), !*noStartup))
+
+	var runArgs []string
+	if interactive {
+		// Reset the terminal mode we grabbed above on exit and on interrupt signal, just in case.
+		// The interpreter handles an interrupt by stopping the code it's running, so we
+		// keep going after one.
+		var mu sync.Mutex
+		resetTerminal := func() {
+			mu.Lock()
+			defer mu.Unlock()
+			mode.ApplyMode()
+		}
+
+		go func() {
+			c := make(chan os.Signal, 1)
+			signal.Notify(c, os.Interrupt)
+			for _ = range c {
+				resetTerminal()
+			}
+		}()
+
+		defer resetTerminal()
+	} else if *scriptName != "" {
+		runArgs = append(runArgs, "-f", *scriptName)
+	}
+
+	cmd := exec.Command("go", append([]string{"run", fn}, runArgs...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+	cmdError = cmd.Run()
+}
+
+// buildCmd builds a standalone interpreter binary with the given packages available.
+func buildCmd(args []string) {
+	flags := flag.NewFlagSet("build", flag.ExitOnError)
+	flags.Usage = usage
+	output := flags.String("o", "goconsole-custom", "write the resulting binary to this file")
+	noStartup := flags.Bool("nostartup", false, "do not run the startup code from the configuration file")
+	configFile := flags.String("config", "", "use this configuration file rather than "+configName+" in the working directory")
+	flags.Parse(args)
+
+	cfg, err := loadBuildConfig(*configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	workDir, err := ioutil.TempDir("", "goconsole")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(workDir)
+
+	fn := filepath.Join(workDir, "goconsole.go")
+	writeSource(fn, newInterp(cfg, flags.Args(), !*noStartup))
+
+	cmd := exec.Command("go", "build", "-o", *output, fn)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		os.RemoveAll(workDir)
+		log.Fatal(err)
+	}
+}
+
+// genCmd writes the source of the interpreter for the given packages to a directory,
+// where it can be inspected or built by hand.
+func genCmd(args []string) {
+	flags := flag.NewFlagSet("gen", flag.ExitOnError)
+	flags.Usage = usage
+	dir := flags.String("d", ".", "write goconsole.go to this directory")
+	noStartup := flags.Bool("nostartup", false, "do not run the startup code from the configuration file")
+	configFile := flags.String("config", "", "use this configuration file rather than "+configName+" in the working directory")
+	flags.Parse(args)
+
+	cfg, err := loadBuildConfig(*configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		log.Fatal(err)
+	}
+	writeSource(filepath.Join(*dir, "goconsole.go"), newInterp(cfg, flags.Args(), !*noStartup))
+}
+
+// newInterp imports the packages with the given paths, along with those listed in
+// cfg, and gathers everything needed to generate the source of an interpreter for
+// them. If useStartup is set, the interpreter runs the startup code from cfg before
+// the first prompt, unless it's run with -nostartup.
+func newInterp(cfg *config, paths []string, useStartup bool) *Interp {
+	imps := cfg.Imports
+	for _, path := range paths {
+		imps = append(imps, Import{Path: path})
+	}
+
 	importSet := map[Import]bool{
+		Import{Path: "flag"}:                                             true,
+		Import{Path: "io"}:                                               true,
 		Import{Path: "os"}:                                               true,
 		Import{Path: "fmt"}:                                              true,
+		Import{Path: "github.com/davidthomas426/goconsole/console"}:      true,
 		Import{Path: "github.com/davidthomas426/goconsole/interp"}:       true,
 		Import{Path: "github.com/peterh/liner"}:                          true,
 		Import{LocalName: "_", Path: "golang.org/x/tools/go/gcimporter"}: true,
@@ -80,18 +235,42 @@ func main() {
 		Import{Path: "golang.org/x/tools/go/types/typeutil"}:             true,
 	}
 
-	if len(os.Args) >= 2 {
+	if len(imps) > 0 {
 		// At least one package to import provided on command line
 		importSet[Import{Path: "log"}] = true
 		importSet[Import{Path: "reflect"}] = true
 	}
 
 	pkgNames := make(map[string]bool)
+	pkgPaths := make(map[string]bool)
+	pkgLocalNames := make(map[string]bool)
+
+	// The generated source's own imports take their names, so another package
+	// with one of them can't be imported alongside
+	reservedNames := make(map[string]string) // by name, the path imported with it
+	for imp := range importSet {
+		name := imp.LocalName
+		if name == "" {
+			name = imp.Path[strings.LastIndex(imp.Path, "/")+1:]
+		}
+		if name != "_" {
+			reservedNames[name] = imp.Path
+		}
+	}
+	nameFree := func(name, path string) bool {
+		reservedPath, ok := reservedNames[name]
+		return !pkgNames[name] && !pkgLocalNames[name] && (!ok || reservedPath == path)
+	}
 
 	pkgMap := map[string]*types.Package{}
 	var pkgs []Package
 	var tpkgs []*types.Package
-	for _, path := range os.Args[1:] {
+	importPkg := func(imp Import) {
+		path := imp.Path
+		if pkgPaths[path] {
+			// Already imported
+			return
+		}
 		var tpkg *types.Package
 		var err error
 		if path == "unsafe" {
@@ -102,17 +281,50 @@ func main() {
 				log.Fatal(err)
 			}
 		}
+		name := tpkg.Name()
+		if imp.LocalName != "" {
+			name = imp.LocalName
+		}
+		if !nameFree(name, path) {
+			log.Fatalf("cannot import %q as %s: name already in use", path, name)
+		}
 		tpkgs = append(tpkgs, tpkg)
-		imp := Import{Path: path}
 		importSet[imp] = true
 		pkg := Package{
 			Path: path,
-			Name: tpkg.Name(),
+			Name: name,
 		}
 		pkgs = append(pkgs, pkg)
-		// Store the local package name in pkgNames
-		pkgNames[pkg.Name] = true
+		pkgPaths[path] = true
+		// Store the package name in pkgNames if that's the name it's imported as.
+		// Otherwise, the types of this package can't be written by name in the generated
+		// source, since they're written using the package's own name.
+		if imp.LocalName == "" {
+			pkgNames[name] = true
+		} else {
+			pkgLocalNames[name] = true
+		}
+	}
+	for _, imp := range imps {
+		importPkg(imp)
+	}
+
+	// Also import any package whose exported types show up in the API of a package
+	// we were asked for, so those types can be written at the prompt rather than only
+	// being reachable through reflection. This isn't done for the packages imported
+	// here in turn, which would pull in much of their dependencies. A package is
+	// skipped if the generated source can't import it, or if its name is already
+	// taken, by another package or by an import of the generated source itself,
+	// since packages are referred to by name in the interpreter.
+	numRequested := len(tpkgs)
+	for i := 0; i < numRequested; i++ {
+		for _, tpkg := range referencedPkgs(tpkgs[i]) {
+			if importable(tpkg.Path()) && nameFree(tpkg.Name(), tpkg.Path()) {
+				importPkg(Import{Path: tpkg.Path()})
+			}
+		}
 	}
+
 	for i, tpkg := range tpkgs {
 		for _, name := range tpkg.Scope().Names() {
 			obj := tpkg.Scope().Lookup(name)
@@ -126,20 +338,21 @@ func main() {
 	for imp := range importSet {
 		imports = append(imports, imp)
 	}
+	// Sort the imports so the generated source is the same from run to run
+	sort.Sort(byPath(imports))
 
 	interp := &Interp{
 		Imports:  imports,
 		Packages: pkgs,
 	}
-
-	workDir, err := ioutil.TempDir("", "goconsole")
-	if err != nil {
-		log.Fatal(err)
+	if useStartup {
+		interp.Startup = cfg.Startup
 	}
-	defer os.RemoveAll(workDir)
-
-	fn := filepath.Join(workDir, "goconsole.go")
+	return interp
+}
 
+// writeSource writes the generated interpreter source to the file fn.
+func writeSource(fn string, interp *Interp) {
 	srcFile, err := os.Create(fn)
 	if err != nil {
 		log.Panic(err)
@@ -150,33 +363,6 @@ func main() {
 	if err != nil {
 		log.Panic(err)
 	}
-
-	srcFile.Close()
-
-	// Grab the terminal mode and reset it on exit interrupt signal, just in case
-	mode, err := liner.TerminalMode()
-	if err != nil {
-		log.Panic(err)
-	}
-	var once sync.Once
-	resetTerminal := func() {
-		mode.ApplyMode()
-	}
-
-	go func() {
-		c := make(chan os.Signal, 1)
-		signal.Notify(c, os.Interrupt)
-		<-c
-		once.Do(resetTerminal)
-	}()
-
-	defer once.Do(resetTerminal)
-
-	cmd := exec.Command("go", "run", fn)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Stdin = os.Stdin
-	cmdError = cmd.Run()
 }
 
 func processObj(pkg *Package, obj types.Object, pkgNames map[string]bool) {
@@ -295,10 +481,11 @@ func processType(pkg *Package, typ types.Type, checkerTypeStr string, reflectTyp
 		erts := fmt.Sprintf("rt%d.Elem()", index)
 		processType(pkg, et, ects, erts, true, pkgNames)
 	case *types.Struct:
-		// Process exported field types
+		// Process exported field types, and embedded fields, since the exported fields
+		// and methods of an embedded field are promoted even if its type is unexported
 		for i := 0; i < undTyp.NumFields(); i++ {
 			f := undTyp.Field(i)
-			if f.Exported() {
+			if f.Exported() || f.Anonymous() {
 				ft := undTyp.Field(i).Type()
 				fcts := fmt.Sprintf("t%d.Underlying().(*types.Struct).Field(%d).Type()", index, i)
 				frts := fmt.Sprintf("rt%d.Field(%d).Type", index, i) // TODO: FIX THIS LINE
@@ -307,6 +494,12 @@ func processType(pkg *Package, typ types.Type, checkerTypeStr string, reflectTyp
 		}
 	}
 
+	// Process the types in the signature of each exported method of a named type, since
+	// a type may be reachable only as a parameter or result of a method
+	if typ, ok := typ.(*types.Named); ok {
+		processMethods(pkg, typ, index, pkgNames)
+	}
+
 	// TODO: Add (or process?) the method type for each method in the type's method set
 	// What does "the method type" mean?
 	// Suppose we have the following definitions:
@@ -354,6 +547,129 @@ func processType(pkg *Package, typ types.Type, checkerTypeStr string, reflectTyp
 	}
 }
 
+// processMethods processes the parameter and result types of each exported method
+// of the named type typ, which has already been added to pkg at the given index.
+func processMethods(pkg *Package, typ *types.Named, index int, pkgNames map[string]bool) {
+	// The method set of *T includes the methods of T, and reflect only gives us
+	// method types for a concrete type that include the receiver as the first
+	// parameter. Interface method types have no receiver parameter.
+	mset := types.NewMethodSet(typ)
+	recvRts := fmt.Sprintf("rt%d", index)
+	recvOffset := 0
+	if _, isInterface := typ.Underlying().(*types.Interface); !isInterface {
+		mset = types.NewMethodSet(types.NewPointer(typ))
+		recvRts = fmt.Sprintf("reflect.PtrTo(rt%d)", index)
+		recvOffset = 1
+	}
+	for i := 0; i < mset.Len(); i++ {
+		m := mset.At(i).Obj()
+		if !m.Exported() {
+			continue
+		}
+		sig := m.Type().(*types.Signature)
+		mcts := fmt.Sprintf("methodSig(t%d, %q)", index, m.Name())
+		mrts := fmt.Sprintf("methodType(%s, %q)", recvRts, m.Name())
+		for j := 0; j < sig.Params().Len(); j++ {
+			pt := sig.Params().At(j).Type()
+			pcts := fmt.Sprintf("%s.Params().At(%d).Type()", mcts, j)
+			prts := fmt.Sprintf("%s.In(%d)", mrts, j+recvOffset)
+			processType(pkg, pt, pcts, prts, true, pkgNames)
+		}
+		for j := 0; j < sig.Results().Len(); j++ {
+			rt := sig.Results().At(j).Type()
+			rcts := fmt.Sprintf("%s.Results().At(%d).Type()", mcts, j)
+			rrts := fmt.Sprintf("%s.Out(%d)", mrts, j)
+			processType(pkg, rt, rcts, rrts, true, pkgNames)
+		}
+	}
+}
+
+// referencedPkgs returns the packages, other than tpkg itself, that declare an exported
+// named type reachable from the exported API of tpkg. Reachable types include types of
+// exported objects, exported fields, and parameters and results of exported methods,
+// looking through unexported types of tpkg along the way.
+func referencedPkgs(tpkg *types.Package) []*types.Package {
+	var pkgs []*types.Package
+	pkgSet := map[*types.Package]bool{}
+	visited := new(typeutil.Map)
+
+	var visit func(typ types.Type)
+	visitTuple := func(tup *types.Tuple) {
+		for i := 0; i < tup.Len(); i++ {
+			visit(tup.At(i).Type())
+		}
+	}
+	visit = func(typ types.Type) {
+		if visited.At(typ) != nil {
+			return
+		}
+		visited.Set(typ, struct{}{})
+		switch typ := typ.(type) {
+		case *types.Array:
+			visit(typ.Elem())
+		case *types.Chan:
+			visit(typ.Elem())
+		case *types.Interface:
+			for i := 0; i < typ.NumMethods(); i++ {
+				if m := typ.Method(i); m.Exported() {
+					visit(m.Type())
+				}
+			}
+		case *types.Map:
+			visit(typ.Key())
+			visit(typ.Elem())
+		case *types.Named:
+			obj := typ.Obj()
+			if p := obj.Pkg(); p != nil && p != tpkg && obj.Exported() {
+				// This type will be processed along with its own package
+				if !pkgSet[p] {
+					pkgSet[p] = true
+					pkgs = append(pkgs, p)
+				}
+				return
+			}
+			visit(typ.Underlying())
+			for i := 0; i < typ.NumMethods(); i++ {
+				if m := typ.Method(i); m.Exported() {
+					visit(m.Type())
+				}
+			}
+		case *types.Pointer:
+			visit(typ.Elem())
+		case *types.Signature:
+			visitTuple(typ.Params())
+			visitTuple(typ.Results())
+		case *types.Slice:
+			visit(typ.Elem())
+		case *types.Struct:
+			for i := 0; i < typ.NumFields(); i++ {
+				if f := typ.Field(i); f.Exported() || f.Anonymous() {
+					visit(f.Type())
+				}
+			}
+		}
+	}
+
+	for _, name := range tpkg.Scope().Names() {
+		if obj := tpkg.Scope().Lookup(name); obj.Exported() {
+			visit(obj.Type())
+		}
+	}
+	return pkgs
+}
+
+// importable reports whether the package with the given path can be imported by the
+// generated source, which is outside every tree internal and vendored packages can be
+// imported from.
+func importable(path string) bool {
+	for _, elem := range strings.Split(path, "/") {
+		if elem == "internal" || elem == "vendor" {
+			return false
+		}
+	}
+	return true
+}
+
 func processVar(pkg *Package, obj types.Object, pkgNames map[string]bool) {
 	if !obj.Exported() {
 		return
@@ -440,7 +756,28 @@ import ({{range .Imports}}
 	{{with .LocalName}}{{.}} {{end}}{{printf "%q" .Path}}{{end}}
 )
 
+{{if .Packages}}
+// methodSig returns the signature of the named method in the method set of typ,
+// or of *typ if typ is not an interface type.
+func methodSig(typ types.Type, name string) *types.Signature {
+	if _, isInterface := typ.Underlying().(*types.Interface); !isInterface {
+		typ = types.NewPointer(typ)
+	}
+	return types.NewMethodSet(typ).Lookup(nil, name).Type().(*types.Signature)
+}
+
+// methodType returns the type of the named method of rtyp. Unless rtyp is an
+// interface type, the receiver is the first parameter of the method type.
+func methodType(rtyp reflect.Type, name string) reflect.Type {
+	m, _ := rtyp.MethodByName(name)
+	return m.Type
+}
+{{end}}
 func main() {
+	scriptName := flag.String("f", "", "run the statements in this file instead of prompting")
+	noStartup := flag.Bool("nostartup", false, "do not run the startup code built into the interpreter")
+	flag.Parse()
+
 	// Silence errors about not using reflect package
 	{{if .Packages}}_ = reflect.ValueOf{{end}}
 
@@ -480,35 +817,46 @@ func main() {
 	}
 {{end}}
 
-	interp := interp.NewInterpreter(pkgs, pkgMap, typeMap)
-
-	var lerr error
-	defer func() {
-		if lerr == liner.ErrPromptAborted {
-			os.Exit(2)
+	interpreter := interp.NewInterpreter(pkgs, pkgMap, typeMap)
+{{with .Startup}}
+	// Run the startup code from the configuration files. It's compiled first, since
+	// if it's incomplete, running it would prepend it to the first input.
+	if !*noStartup {
+		startup := {{printf "%q" .}}
+		if _, err := interpreter.Compile(startup); err == interp.ErrIncomplete {
+			fmt.Println("startup:", err)
+		} else if _, err := interpreter.Run(startup); err != nil {
+			fmt.Println("startup:", err)
 		}
-	}()
-
-	line := liner.NewLiner()
-	defer line.Close()
-
-	line.SetCtrlCAborts(true)
-
-	src, lerr := line.Prompt(">>> ")
-	for lerr == nil {
-		incomplete, err := interp.Run(src)
+	}
+{{else}}
+	_ = noStartup
+{{end}}
+	// Without a terminal to prompt on, run the script file or standard input non-interactively
+	var script io.Reader
+	if *scriptName != "" {
+		f, err := os.Open(*scriptName)
 		if err != nil {
-			fmt.Println(err)
-			break
-		}
-		if src != "" {
-			line.AppendHistory(src)
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
-		if incomplete {
-			src, lerr = line.Prompt("... ")
-		} else {
-			src, lerr = line.Prompt(">>> ")
+		defer f.Close()
+		script = f
+	} else if _, err := liner.TerminalMode(); err != nil {
+		*scriptName = "<stdin>"
+		script = os.Stdin
+	}
+	cfg := console.DefaultConfig()
+	if script != nil {
+		if err := console.RunScript(interpreter, script, *scriptName, cfg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
+		return
+	}
+
+	if err := console.Run(interpreter, cfg); err == liner.ErrPromptAborted {
+		os.Exit(2)
 	}
 }
 `